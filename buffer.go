@@ -0,0 +1,31 @@
+package schemaless
+
+import (
+	"context"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// WithBuffer writes the cells of tblName, and of any index table that
+// shares its shards, to buffer while their shard is unreachable. See
+// core.KVStore.WithBuffer.
+func (ds *DataStore) WithBuffer(tblName string, buffer core.Storage) *DataStore {
+	tbl, err := ds.getTable(tblName)
+	if err != nil {
+		panic(err)
+	}
+
+	tbl.WithBuffer(buffer)
+	return ds
+}
+
+// ReplayBuffer moves up to limit buffered cells of tblName to their shards,
+// returning how many were moved. It is safe to call repeatedly, and leaves
+// the cells of still unreachable shards buffered.
+func (ds *DataStore) ReplayBuffer(ctx context.Context, tblName string, limit int) (int64, error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return 0, err
+	}
+	return source.ReplayBuffer(ctx, tblName, limit)
+}
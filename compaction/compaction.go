@@ -0,0 +1,223 @@
+// Package compaction enforces retention policies on the versions of a
+// column. Every Put of a new ref key leaves the previous versions behind;
+// a Compactor deletes the ones a Policy no longer wants to keep, shard by
+// shard and a batch at a time, but never a cell's latest version.
+package compaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Policy is the retention policy of a (table, column). A version other than
+// the latest is deleted once it is beyond the newest KeepLast versions, or
+// was created more than MaxAge ago; a zero KeepLast or MaxAge doesn't limit
+// on that basis.
+type Policy struct {
+	Table    string
+	Column   string
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+func (p Policy) validate() error {
+	if p.Table == "" || p.Column == "" {
+		return errors.New("retention policy needs a table and a column")
+	}
+	if p.KeepLast < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("retention policy for %s (%s) has a negative limit", p.Table, p.Column)
+	}
+	if p.KeepLast == 0 && p.MaxAge == 0 {
+		return fmt.Errorf("retention policy for %s (%s) keeps everything", p.Table, p.Column)
+	}
+	return nil
+}
+
+// superseded returns the versions, given highest ref key first, that the
+// policy no longer keeps.
+func (p Policy) superseded(versions []models.Cell, now time.Time) []models.Cell {
+	var old []models.Cell
+	for i, cell := range versions {
+		if i == 0 {
+			continue
+		}
+		tooMany := p.KeepLast > 0 && i >= p.KeepLast
+		tooOld := p.MaxAge > 0 && now.Sub(time.Unix(0, cell.CreatedAt)) > p.MaxAge
+		if tooMany || tooOld {
+			old = append(old, cell)
+		}
+	}
+	return old
+}
+
+// Result reports one pass of a policy over one shard.
+type Result struct {
+	Shard     string
+	Table     string
+	Column    string
+	Rows      int64 // rows whose versions were checked
+	Reclaimed int64 // cells deleted
+	Err       error
+}
+
+// Compactor applies retention policies to the shards of a datastore. The
+// shards' backends must implement core.Purger.
+type Compactor struct {
+	policies  []Policy
+	batchSize int
+	throttle  time.Duration
+	now       func() time.Time
+}
+
+// New returns a Compactor for policies that reads 1000 cells per batch and
+// does not pause between batches.
+func New(policies ...Policy) (*Compactor, error) {
+	seen := make(map[[2]string]bool)
+	for _, p := range policies {
+		err := p.validate()
+		if err != nil {
+			return nil, err
+		}
+		key := [2]string{p.Table, p.Column}
+		if seen[key] {
+			return nil, fmt.Errorf("more than one retention policy for %s (%s)", p.Table, p.Column)
+		}
+		seen[key] = true
+	}
+	return &Compactor{policies: policies, batchSize: 1000, now: time.Now}, nil
+}
+
+// WithBatchSize sets how many cells are read from a shard at a time.
+func (c *Compactor) WithBatchSize(n int) *Compactor {
+	c.batchSize = n
+	return c
+}
+
+// WithThrottle pauses for d after each batch, to limit the load compaction
+// puts on a shard.
+func (c *Compactor) WithThrottle(d time.Duration) *Compactor {
+	c.throttle = d
+	return c
+}
+
+// WithClock sets the clock MaxAge is measured against.
+func (c *Compactor) WithClock(now func() time.Time) *Compactor {
+	c.now = now
+	return c
+}
+
+// CompactShard makes one pass of every policy over shard, and returns what
+// each reclaimed. It stops at the first error, which is also set on the
+// result of the policy that failed.
+func (c *Compactor) CompactShard(ctx context.Context, shard core.Shard) ([]Result, error) {
+	purger, ok := shard.Backend.(core.Purger)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w: %T", shard.Name, core.ErrPurgeNotSupported, shard.Backend)
+	}
+
+	var results []Result
+	for _, p := range c.policies {
+		res := Result{Shard: shard.Name, Table: p.Table, Column: p.Column}
+		res.Err = c.compact(ctx, shard.Backend, purger, p, &res)
+		results = append(results, res)
+		if res.Err != nil {
+			return results, res.Err
+		}
+	}
+	return results, nil
+}
+
+// compact scans the cells of p.Table on one shard in added_at order and,
+// for every row with a cell of p.Column in a batch, deletes the versions p
+// doesn't keep. A row whose versions span batches is checked once per batch.
+func (c *Compactor) compact(ctx context.Context, backend core.Storage, purger core.Purger, p Policy, res *Result) error {
+	var cursor int64
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		cells, found, err := backend.PartitionRead(ctx, p.Table, 0, "added_at", cursor, c.batchSize)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return nil
+		}
+
+		rows := make(map[string]bool)
+		for _, cell := range cells {
+			if cell.AddedAt >= cursor {
+				cursor = cell.AddedAt + 1
+			}
+			if cell.ColumnName == p.Column {
+				rows[cell.RowKey] = true
+			}
+		}
+
+		now := c.now()
+		for rowKey := range rows {
+			versions, _, err := backend.History(ctx, p.Table, rowKey, p.Column, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			res.Rows++
+
+			for _, cell := range p.superseded(versions, now) {
+				err = purger.PurgeCell(ctx, p.Table, rowKey, p.Column, cell.RefKey)
+				if err != nil {
+					return fmt.Errorf("compacting %s (%s, %s, %d): %w", p.Table, rowKey, p.Column, cell.RefKey, err)
+				}
+				res.Reclaimed++
+			}
+		}
+
+		if c.throttle > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.throttle):
+			}
+		}
+	}
+}
+
+// Start compacts every shard, then again every interval, until ctx is
+// done. The result of each policy on each shard is sent on the returned
+// channel, which is closed when compaction stops; the caller must keep
+// receiving from it.
+func (c *Compactor) Start(ctx context.Context, shards []core.Shard, interval time.Duration) <-chan Result {
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+		for {
+			for _, shard := range shards {
+				rs, err := c.CompactShard(ctx, shard)
+				if len(rs) == 0 && err != nil {
+					rs = []Result{{Shard: shard.Name, Err: err}}
+				}
+				for _, r := range rs {
+					select {
+					case results <- r:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return results
+}
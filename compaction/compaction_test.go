@@ -0,0 +1,81 @@
+package compaction
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+func TestCompactShard(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	ctx := context.TODO()
+	for _, rowKey := range []string{"trip1", "trip2"} {
+		for refKey := int64(1); refKey <= 10; refKey++ {
+			err := backend.Put(ctx, "cell", rowKey, "STATUS", refKey, `{"n": `+strconv.FormatInt(refKey, 10)+`}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := backend.Put(ctx, "cell", rowKey, "BASE", 1, `{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compactor, err := New(Policy{Table: "cell", Column: "STATUS", KeepLast: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactor = compactor.WithBatchSize(4)
+
+	results, err := compactor.CompactShard(ctx, core.Shard{Name: "shard0", Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Reclaimed != 14 {
+		t.Fatalf("expected 14 cells reclaimed, got %+v", results)
+	}
+
+	for _, rowKey := range []string{"trip1", "trip2"} {
+		cells, _, err := backend.History(ctx, "cell", rowKey, "STATUS", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cells) != 3 || cells[0].RefKey != 10 || cells[2].RefKey != 8 {
+			t.Errorf("%s: expected ref keys 10 to 8 to be kept, got %v", rowKey, cells)
+		}
+		_, found, err := backend.GetLatest(ctx, "cell", rowKey, "BASE")
+		if err != nil || !found {
+			t.Errorf("%s: expected BASE to be untouched, found=%v err=%v", rowKey, found, err)
+		}
+	}
+
+	// by age, the latest version survives even when it is too old
+	compactor, err = New(Policy{Table: "cell", Column: "STATUS", MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactor = compactor.WithClock(func() time.Time { return time.Now().Add(2 * time.Hour) })
+
+	results, err = compactor.CompactShard(ctx, core.Shard{Name: "shard0", Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Reclaimed != 4 {
+		t.Errorf("expected 4 cells reclaimed, got %+v", results)
+	}
+	cell, found, err := backend.GetLatest(ctx, "cell", "trip1", "STATUS")
+	if err != nil || !found || cell.RefKey != 10 {
+		t.Errorf("expected the latest version to be kept, got %v found=%v err=%v", cell, found, err)
+	}
+
+	_, err = New(Policy{Table: "cell", Column: "STATUS"})
+	if err == nil {
+		t.Error("expected an error for a policy that keeps everything")
+	}
+}
@@ -0,0 +1,376 @@
+package core
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/rbastic/go-schemaless/models"
+	"go.uber.org/zap"
+)
+
+// IsConnectionError reports whether err means a backend could not be
+// reached, rather than that it rejected the request. A timeout is not one:
+// a slow backend may still have done what was asked, and a caller's own
+// deadline says nothing about the backend. Only a dial that timed out is.
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrShardUnavailable) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial" || !opErr.Timeout()
+	}
+	return false
+}
+
+// buffered is the Storage of a shard whose writes fall back to a buffer
+// backend while the shard cannot be reached. Reads merge the shard's cells
+// with the buffered ones until ReplayBuffer moves them to the shard.
+type buffered struct {
+	primary Storage
+	buffer  Storage
+	// shard and log name the shard in the warning of a buffered write
+	shard string
+	log   *zap.Logger
+}
+
+// WithBuffer makes every shard's Puts go to buffer when the shard fails
+// with a connection error (see IsConnectionError). Buffered cells are read
+// back alongside the shard's own, except by PartitionRead, until
+// ReplayBuffer drains them. The buffer must implement Purger, and holds the
+// same tables as the shards; the KVStore does not Destroy it.
+func (kv *KVStore) WithBuffer(buffer Storage) *KVStore {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.buffer = buffer
+	for name, storage := range kv.storages {
+		kv.storages[name] = kv.bufferedStorage(name, storage)
+	}
+	for name, storage := range kv.mstorages {
+		kv.mstorages[name] = kv.bufferedStorage(name, storage)
+	}
+	return kv
+}
+
+// bufferedStorage wraps the named shard's storage with the KVStore's
+// buffer, if it has one. kv.mu must be held.
+func (kv *KVStore) bufferedStorage(name string, storage Storage) Storage {
+	if kv.buffer == nil {
+		return storage
+	}
+	if _, ok := storage.(*buffered); ok {
+		return storage
+	}
+	return &buffered{primary: storage, buffer: kv.buffer, shard: name, log: kv.log}
+}
+
+// ReplayBuffer moves up to limit buffered cells of tblName to the shards
+// they belong to, and returns how many it moved. A cell the shard already
+// has is only removed from the buffer, so replaying twice is harmless.
+// Cells whose shard is still unreachable stay buffered for the next call,
+// and are passed over so that the cells of reachable shards behind them are
+// replayed. kv.mu is only held to route each cell, not during replay.
+func (kv *KVStore) ReplayBuffer(ctx context.Context, tblName string, limit int) (int64, error) {
+	kv.mu.Lock()
+	buffer := kv.buffer
+	kv.mu.Unlock()
+
+	if buffer == nil {
+		return 0, nil
+	}
+	purger, ok := buffer.(Purger)
+	if !ok {
+		return 0, fmt.Errorf("buffer: %w: %T", ErrPurgeNotSupported, buffer)
+	}
+
+	var (
+		n      int64
+		cursor int64
+		// shards that failed with a connection error during this call
+		down = make(map[string]bool)
+	)
+	for n < int64(limit) {
+		cells, found, err := buffer.PartitionRead(ctx, tblName, 0, "added_at", cursor, limit)
+		if err != nil {
+			return n, err
+		}
+		if !found {
+			break
+		}
+
+		for _, cell := range cells {
+			cursor = cell.AddedAt + 1
+
+			shard, storage := kv.replayStorage(cell.RowKey)
+			if down[shard] {
+				continue
+			}
+			err = replayCell(ctx, storage, tblName, cell)
+			if IsConnectionError(err) {
+				down[shard] = true
+				continue
+			}
+			if err != nil {
+				return n, fmt.Errorf("replaying %s (%s, %s, %d): %w", tblName, cell.RowKey, cell.ColumnName, cell.RefKey, err)
+			}
+
+			err = purger.PurgeCell(ctx, tblName, cell.RowKey, cell.ColumnName, cell.RefKey)
+			if err != nil {
+				return n, err
+			}
+			n++
+			if n == int64(limit) {
+				break
+			}
+		}
+	}
+	if n > 0 {
+		kv.log.Info("replayed buffered writes", zap.String("table", tblName), zap.Int64("cells", n))
+	}
+	return n, nil
+}
+
+// replayStorage returns the shard that rowKey's buffered cells belong to,
+// without its buffer.
+func (kv *KVStore) replayStorage(rowKey string) (string, Storage) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	shard := kv.continuum.Choose(rowKey)
+	storage := kv.storages[shard]
+	if kv.migration != nil {
+		if mshard := kv.migration.Choose(rowKey); kv.mstorages[mshard] != nil {
+			shard, storage = mshard, kv.mstorages[mshard]
+		}
+	}
+	if b, ok := storage.(*buffered); ok {
+		storage = b.primary
+	}
+	return shard, storage
+}
+
+// replayCell writes a buffered cell to storage, unless it is already there.
+func replayCell(ctx context.Context, storage Storage, tblName string, cell models.Cell) error {
+	_, found, err := storage.Get(ctx, tblName, cell.RowKey, cell.ColumnName, cell.RefKey)
+	if err != nil || found {
+		return err
+	}
+	if cell.ExpiresAt == 0 {
+		return storage.Put(ctx, tblName, cell.RowKey, cell.ColumnName, cell.RefKey, cell.Body)
+	}
+	expirer, ok := storage.(Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrExpiryNotSupported, storage)
+	}
+	return expirer.PutWithExpiry(ctx, tblName, cell.RowKey, cell.ColumnName, cell.RefKey, cell.Body, time.Unix(0, cell.ExpiresAt))
+}
+
+// Get looks in the buffer for a cell the shard doesn't have, or can't be
+// asked for.
+func (b *buffered) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	cell, found, err = b.primary.Get(ctx, tblName, rowKey, columnKey, refKey)
+	if found || (err != nil && !IsConnectionError(err)) {
+		return cell, found, err
+	}
+	bcell, bfound, berr := b.buffer.Get(ctx, tblName, rowKey, columnKey, refKey)
+	if berr != nil || !bfound {
+		return cell, found, err
+	}
+	return bcell, true, nil
+}
+
+// latest returns whichever of the shard's and the buffer's cell has the
+// higher ref key.
+func (b *buffered) latest(cell models.Cell, found bool, err error, read func(Storage) (models.Cell, bool, error)) (models.Cell, bool, error) {
+	if err != nil && !IsConnectionError(err) {
+		return cell, found, err
+	}
+	bcell, bfound, berr := read(b.buffer)
+	if berr != nil {
+		return cell, found, berr
+	}
+	if !bfound {
+		return cell, found, err
+	}
+	if err == nil && found && cell.RefKey >= bcell.RefKey {
+		return cell, true, nil
+	}
+	return bcell, true, nil
+}
+
+func (b *buffered) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (models.Cell, bool, error) {
+	cell, found, err := b.primary.GetLatest(ctx, tblName, rowKey, columnKey)
+	return b.latest(cell, found, err, func(s Storage) (models.Cell, bool, error) {
+		return s.GetLatest(ctx, tblName, rowKey, columnKey)
+	})
+}
+
+func (b *buffered) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (models.Cell, bool, error) {
+	cell, found, err := b.primary.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	return b.latest(cell, found, err, func(s Storage) (models.Cell, bool, error) {
+		return s.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	})
+}
+
+// merge combines the shard's and the buffer's cells, highest ref key first
+// within a column, keeping one cell per (column, ref key) and, if latest,
+// only the highest ref key of each column.
+func (b *buffered) merge(cells []models.Cell, err error, read func(Storage) ([]models.Cell, bool, error), latest bool) ([]models.Cell, bool, error) {
+	if err != nil && !IsConnectionError(err) {
+		return cells, len(cells) > 0, err
+	}
+	bcells, _, berr := read(b.buffer)
+	if berr != nil {
+		return cells, len(cells) > 0, berr
+	}
+	if len(bcells) == 0 {
+		return cells, len(cells) > 0, err
+	}
+
+	all := append(append([]models.Cell{}, cells...), bcells...)
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].ColumnName != all[j].ColumnName {
+			return all[i].ColumnName < all[j].ColumnName
+		}
+		return all[i].RefKey > all[j].RefKey
+	})
+
+	var merged []models.Cell
+	for i, cell := range all {
+		if i > 0 && cell.ColumnName == all[i-1].ColumnName {
+			if latest || cell.RefKey == all[i-1].RefKey {
+				continue
+			}
+		}
+		merged = append(merged, cell)
+	}
+	return merged, true, nil
+}
+
+func (b *buffered) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error) {
+	cells, _, err := b.primary.History(ctx, tblName, rowKey, columnKey, limit)
+	merged, found, err := b.merge(cells, err, func(s Storage) ([]models.Cell, bool, error) {
+		return s.History(ctx, tblName, rowKey, columnKey, limit)
+	}, false)
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, found, err
+}
+
+func (b *buffered) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) ([]models.Cell, bool, error) {
+	cells, _, err := b.primary.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	return b.merge(cells, err, func(s Storage) ([]models.Cell, bool, error) {
+		return s.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	}, true)
+}
+
+// PartitionRead reads the shard alone; buffered cells are in no partition
+// until they are replayed.
+func (b *buffered) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) ([]models.Cell, bool, error) {
+	return b.primary.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+}
+
+func (b *buffered) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	err := b.primary.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+	if IsConnectionError(err) {
+		b.warn(tblName, rowKey, err)
+		return b.buffer.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+	}
+	return err
+}
+
+func (b *buffered) warn(tblName, rowKey string, err error) {
+	b.log.Warn("shard unreachable, buffering write",
+		zap.String("shard", b.shard),
+		zap.String("table", tblName),
+		zap.String("row_key", rowKey),
+		zap.Error(err))
+}
+
+func (b *buffered) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := b.primary.(Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrExpiryNotSupported, b.primary)
+	}
+	err := expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+	if !IsConnectionError(err) {
+		return err
+	}
+	bexpirer, ok := b.buffer.(Expirer)
+	if !ok {
+		return err
+	}
+	b.warn(tblName, rowKey, err)
+	return bexpirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+func (b *buffered) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error) {
+	expirer, ok := b.primary.(Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrExpiryNotSupported, b.primary)
+	}
+	return expirer.SweepExpired(ctx, tblName, now, limit)
+}
+
+// Purge deletes the row from the buffer as well, so that a replay cannot
+// bring it back.
+func (b *buffered) Purge(ctx context.Context, tblName, rowKey string) (int64, error) {
+	purger, ok := b.primary.(Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrPurgeNotSupported, b.primary)
+	}
+	n, err := purger.Purge(ctx, tblName, rowKey)
+	if err != nil {
+		return n, err
+	}
+	if bpurger, ok := b.buffer.(Purger); ok {
+		bn, err := bpurger.Purge(ctx, tblName, rowKey)
+		return n + bn, err
+	}
+	return n, nil
+}
+
+func (b *buffered) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := b.primary.(Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrPurgeNotSupported, b.primary)
+	}
+	err := purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+	if err != nil {
+		return err
+	}
+	if bpurger, ok := b.buffer.(Purger); ok {
+		return bpurger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+	}
+	return nil
+}
+
+func (b *buffered) FindPartition(tblName, rowKey string) int {
+	return b.primary.FindPartition(tblName, rowKey)
+}
+
+func (b *buffered) ResetConnection(ctx context.Context, key string) error {
+	return b.primary.ResetConnection(ctx, key)
+}
+
+// Destroy leaves the buffer, which is shared by every shard, alone.
+func (b *buffered) Destroy(ctx context.Context) error {
+	return b.primary.Destroy(ctx)
+}
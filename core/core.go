@@ -3,11 +3,16 @@ package core
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/tracing"
+	"go.uber.org/zap"
 )
 
 // Storage is a key-value storage backend
@@ -18,7 +23,16 @@ type Storage interface {
 	// GetLatest returns the latest value for a given rowKey and columnKey, and a bool indicating if the key was present
 	GetLatest(ctx context.Context, tblName string, rowKey string, columnKey string) (cell models.Cell, found bool, err error)
 
-	// PartitionRead returns 'limit' cells after 'location' from shard 'shard_no'
+	// History returns up to 'limit' versions of a given rowKey and columnKey, highest ref key first
+	History(ctx context.Context, tblName string, rowKey string, columnKey string, limit int) (cells []models.Cell, found bool, err error)
+
+	// GetLatestAsOf returns the latest value for a given rowKey and columnKey that was created at or before asOf
+	GetLatestAsOf(ctx context.Context, tblName string, rowKey string, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error)
+
+	// GetRowAsOf returns the latest value of every column of rowKey that was created at or before asOf
+	GetRowAsOf(ctx context.Context, tblName string, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error)
+
+	// PartitionRead returns 'limit' cells after 'location' from shard 'shard_no', in 'location' order
 	PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error)
 
 	// Put inits a cell with given row key, column key, and ref key
@@ -34,6 +48,49 @@ type Storage interface {
 	Destroy(ctx context.Context) error
 }
 
+// ErrPurgeNotSupported is returned by Purge and PurgeCell when a shard's
+// backend does not implement Purger.
+var ErrPurgeNotSupported = errors.New("backend does not support purging cells")
+
+// Purger is implemented by backends that can physically delete cells.
+type Purger interface {
+	// Purge deletes every cell of rowKey, returning how many were deleted
+	Purge(ctx context.Context, tblName string, rowKey string) (int64, error)
+
+	// PurgeCell deletes the cell designated (row key, column key, ref key)
+	PurgeCell(ctx context.Context, tblName string, rowKey string, columnKey string, refKey int64) error
+}
+
+// ErrExpiryNotSupported is returned by PutWithExpiry when a shard's backend
+// does not implement Expirer.
+var ErrExpiryNotSupported = errors.New("backend does not support expiring cells")
+
+// Expirer is implemented by backends that can store cells that expire.
+// Expired cells are not read back, except by History with a context from
+// IncludeExpired, and are deleted by SweepExpired.
+type Expirer interface {
+	// PutWithExpiry is Put for a cell that expires at expiresAt; a zero expiresAt never expires
+	PutWithExpiry(ctx context.Context, tblName string, rowKey string, columnKey string, refKey int64, body string, expiresAt time.Time) error
+
+	// SweepExpired deletes up to limit cells that expired at or before now, returning how many were deleted
+	SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error)
+}
+
+type expiredKey struct{}
+
+// IncludeExpired returns a context whose History calls also return cells
+// that have expired but not yet been swept, for maintenance such as a purge
+// that must find every cell.
+func IncludeExpired(ctx context.Context) context.Context {
+	return context.WithValue(ctx, expiredKey{}, true)
+}
+
+// IncludesExpired reports whether ctx was returned by IncludeExpired.
+func IncludesExpired(ctx context.Context) bool {
+	include, _ := ctx.Value(expiredKey{}).(bool)
+	return include
+}
+
 // KVStore is a sharded key-value store
 type KVStore struct {
 	continuum Chooser
@@ -41,6 +98,21 @@ type KVStore struct {
 
 	migration Chooser
 	mstorages map[string]Storage
+	// mreused is set while mstorages is storages itself, as BeginMigration
+	// leaves it
+	mreused bool
+
+	// buffer takes the writes of unreachable shards; see WithBuffer
+	buffer Storage
+
+	// middleware wraps every shard, in the order registered; see Use
+	middleware []use
+
+	// migrationStats counts where reads were served during a migration
+	migrationStats MigrationStats
+
+	// log gets migrations, buffered writes and replays; see WithLogger
+	log *zap.Logger
 
 	name string
 
@@ -58,10 +130,12 @@ type Chooser interface {
 	Buckets() []string
 }
 
-// Shard is a named storage backend
+// Shard is a named storage backend, optionally with read replicas of it
 type Shard struct {
 	Name    string
 	Backend Storage
+	// Replicas serve reads that tolerate replication lag; see Consistency
+	Replicas []Storage
 }
 
 // New returns a KVStore that uses chooser to shard the keys across the provided shards
@@ -70,11 +144,12 @@ func New(chooser Chooser, shards []Shard) *KVStore {
 	kv := &KVStore{
 		continuum: chooser,
 		storages:  make(map[string]Storage),
+		log:       zap.NewNop(),
 		// migration is initialized separately by calling BeginMigration
 	}
 	for _, shard := range shards {
 		buckets = append(buckets, shard.Name)
-		kv.AddShard(shard.Name, shard.Backend)
+		kv.AddShard(shard.Name, replicate(shard.Backend, shard.Replicas))
 	}
 	chooser.SetBuckets(buckets)
 	return kv
@@ -92,6 +167,9 @@ func (kv *KVStore) Get(ctx context.Context, tblName, rowKey, columnKey string, r
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
+	ctx, span := kv.trace(ctx, "Get", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
 	if kv.migration != nil {
 		shard := kv.migration.Choose(rowKey)
 		migStorage = kv.mstorages[shard]
@@ -101,8 +179,10 @@ func (kv *KVStore) Get(ctx context.Context, tblName, rowKey, columnKey string, r
 				return val, false, err
 			}
 			if ok {
+				kv.migrationStats.MigratedReads++
 				return val, ok, err
 			}
+			kv.migrationStats.FallbackReads++
 		}
 	}
 
@@ -116,6 +196,9 @@ func (kv *KVStore) GetLatest(ctx context.Context, tblName, rowKey, columnKey str
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
+	ctx, span := kv.trace(ctx, "GetLatest", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
 	if kv.migration != nil {
 		shard := kv.migration.Choose(rowKey)
 		migStorage := kv.mstorages[shard]
@@ -126,8 +209,10 @@ func (kv *KVStore) GetLatest(ctx context.Context, tblName, rowKey, columnKey str
 				return vals, ok, err
 			}
 			if ok {
+				kv.migrationStats.MigratedReads++
 				return vals, ok, nil
 			}
+			kv.migrationStats.FallbackReads++
 		}
 	}
 
@@ -136,10 +221,134 @@ func (kv *KVStore) GetLatest(ctx context.Context, tblName, rowKey, columnKey str
 	return storage.GetLatest(ctx, tblName, rowKey, columnKey)
 }
 
-func (kv *KVStore) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+func (kv *KVStore) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "History", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	if kv.migration != nil {
+		shard := kv.migration.Choose(rowKey)
+		migStorage := kv.mstorages[shard]
+
+		if migStorage != nil {
+			vals, ok, err := migStorage.History(ctx, tblName, rowKey, columnKey, limit)
+			if err != nil {
+				return vals, ok, err
+			}
+			if ok {
+				kv.migrationStats.MigratedReads++
+				return vals, ok, nil
+			}
+			kv.migrationStats.FallbackReads++
+		}
+	}
+
+	shard := kv.continuum.Choose(rowKey)
+	storage := kv.storages[shard]
+	return storage.History(ctx, tblName, rowKey, columnKey, limit)
+}
+
+// HistoryAll is History over every shard that may hold rowKey, both
+// continuums' during a migration, including cells that have expired but not
+// yet been swept. It is for maintenance, such as a purge, that must find
+// every version of a cell.
+func (kv *KVStore) HistoryAll(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "HistoryAll", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	ctx = IncludeExpired(ctx)
+	seen := make(map[int64]bool)
+	for _, storage := range kv.shardsFor(rowKey) {
+		var vals []models.Cell
+		vals, _, err = storage.History(ctx, tblName, rowKey, columnKey, limit)
+		if err != nil {
+			return cells, len(cells) > 0, err
+		}
+		for _, cell := range vals {
+			if !seen[cell.RefKey] {
+				seen[cell.RefKey] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+
+	sort.Slice(cells, func(i, j int) bool { return cells[i].RefKey > cells[j].RefKey })
+	if len(cells) > limit {
+		cells = cells[:limit]
+	}
+	return cells, len(cells) > 0, nil
+}
+
+func (kv *KVStore) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "GetLatestAsOf", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	if kv.migration != nil {
+		shard := kv.migration.Choose(rowKey)
+		migStorage := kv.mstorages[shard]
+
+		if migStorage != nil {
+			val, ok, err := migStorage.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+			if err != nil {
+				return val, ok, err
+			}
+			if ok {
+				kv.migrationStats.MigratedReads++
+				return val, ok, nil
+			}
+			kv.migrationStats.FallbackReads++
+		}
+	}
+
+	shard := kv.continuum.Choose(rowKey)
+	storage := kv.storages[shard]
+	return storage.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+}
+
+func (kv *KVStore) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "GetRowAsOf", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	if kv.migration != nil {
+		shard := kv.migration.Choose(rowKey)
+		migStorage := kv.mstorages[shard]
+
+		if migStorage != nil {
+			vals, ok, err := migStorage.GetRowAsOf(ctx, tblName, rowKey, asOf)
+			if err != nil {
+				return vals, ok, err
+			}
+			if ok {
+				kv.migrationStats.MigratedReads++
+				return vals, ok, nil
+			}
+			kv.migrationStats.FallbackReads++
+		}
+	}
+
+	shard := kv.continuum.Choose(rowKey)
+	storage := kv.storages[shard]
+	return storage.GetRowAsOf(ctx, tblName, rowKey, asOf)
+}
+
+func (kv *KVStore) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) (err error) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
+	ctx, span := kv.trace(ctx, "Put", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
 	if kv.migration != nil {
 		shard := kv.migration.Choose(rowKey)
 		storage := kv.mstorages[shard]
@@ -153,6 +362,89 @@ func (kv *KVStore) Put(ctx context.Context, tblName, rowKey, columnKey string, r
 	return storage.Put(ctx, tblName, rowKey, columnKey, refKey, body)
 }
 
+// PutWithExpiry is Put for a cell that expires at expiresAt.
+func (kv *KVStore) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) (err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "PutWithExpiry", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	storage := kv.storages[kv.continuum.Choose(rowKey)]
+	if kv.migration != nil {
+		if migStorage := kv.mstorages[kv.migration.Choose(rowKey)]; migStorage != nil {
+			storage = migStorage
+		}
+	}
+
+	expirer, ok := storage.(Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrExpiryNotSupported, storage)
+	}
+	return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+// Purge deletes every cell of rowKey from the shard that holds it, and
+// during a migration from the row's shard in the new continuum as well.
+func (kv *KVStore) Purge(ctx context.Context, tblName, rowKey string) (n int64, err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "Purge", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	for _, storage := range kv.shardsFor(rowKey) {
+		purger, ok := storage.(Purger)
+		if !ok {
+			return n, fmt.Errorf("%w: %T", ErrPurgeNotSupported, storage)
+		}
+		deleted, err := purger.Purge(ctx, tblName, rowKey)
+		n += deleted
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// PurgeCell deletes a single cell wherever it may be stored.
+func (kv *KVStore) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (err error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ctx, span := kv.trace(ctx, "PurgeCell", tblName, rowKey)
+	defer func() { tracing.End(span, err) }()
+
+	for _, storage := range kv.shardsFor(rowKey) {
+		purger, ok := storage.(Purger)
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrPurgeNotSupported, storage)
+		}
+		err = purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shardsFor returns every storage that may hold cells of key: its shard in
+// the migration continuum, if any, and its shard in the current one.
+// kv.mu must be held.
+func (kv *KVStore) shardsFor(key string) []Storage {
+	var storages []Storage
+	if kv.migration != nil {
+		if migStorage := kv.mstorages[kv.migration.Choose(key)]; migStorage != nil {
+			storages = append(storages, migStorage)
+		}
+	}
+	storage := kv.storages[kv.continuum.Choose(key)]
+	if len(storages) == 0 || storages[0] != storage {
+		storages = append(storages, storage)
+	}
+	return storages
+}
+
 func (kv *KVStore) FindPartition(tblName, rowKey string) (int, error) {
 
 	kv.mu.Lock()
@@ -176,6 +468,9 @@ func (kv *KVStore) PartitionRead(ctx context.Context, tblName string, partitionN
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
+	ctx, span := kv.tracePartition(ctx, tblName, partitionNumber)
+	defer func() { tracing.End(span, err) }()
+
 	if kv.migration != nil {
 		buckets := kv.migration.Buckets()
 		shard := buckets[partitionNumber]
@@ -241,7 +536,7 @@ func (kv *KVStore) AddShard(shard string, storage Storage) {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 
-	kv.storages[shard] = storage
+	kv.storages[shard] = kv.shardStorage(shard, storage)
 }
 
 // DeleteShard removes a shard from the list of known shards
@@ -261,6 +556,9 @@ func (kv *KVStore) BeginMigration(continuum Chooser) {
 
 	kv.migration = continuum
 	kv.mstorages = kv.storages
+	kv.mreused = true
+	kv.migrationStats = MigrationStats{}
+	kv.log.Info("migration started", zap.String("name", kv.name), zap.Strings("shards", continuum.Buckets()))
 }
 
 // BeginMigrationWithShards begins a continuum migration using the new set of shards.
@@ -273,13 +571,16 @@ func (kv *KVStore) BeginMigrationWithShards(continuum Chooser, shards []Shard) {
 	mstorages := make(map[string]Storage)
 	for _, shard := range shards {
 		buckets = append(buckets, shard.Name)
-		mstorages[shard.Name] = shard.Backend
+		mstorages[shard.Name] = kv.shardStorage(shard.Name, replicate(shard.Backend, shard.Replicas))
 	}
 
 	continuum.SetBuckets(buckets)
 
 	kv.migration = continuum
 	kv.mstorages = mstorages
+	kv.mreused = false
+	kv.migrationStats = MigrationStats{}
+	kv.log.Info("migration started", zap.String("name", kv.name), zap.Strings("shards", buckets))
 }
 
 // EndMigration ends a continuum migration and marks the migration continuum
@@ -294,4 +595,8 @@ func (kv *KVStore) EndMigration() {
 
 	kv.storages = kv.mstorages
 	kv.mstorages = nil
+	kv.mreused = false
+	if kv.continuum != nil {
+		kv.log.Info("migration ended", zap.String("name", kv.name), zap.Strings("shards", kv.continuum.Buckets()))
+	}
 }
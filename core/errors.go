@@ -0,0 +1,61 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// The classes of storage errors. Backends wrap driver errors so that
+// errors.Is(err, class) holds for exactly one of these, or for
+// ErrShardUnavailable.
+var (
+	// ErrNotFound is returned when an operation needs a cell that does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a cell already exists, e.g. a Put of a used ref key
+	ErrConflict = errors.New("conflict")
+	// ErrTransient is returned for failures that may succeed if retried, e.g. a deadlock or a busy database
+	ErrTransient = errors.New("transient failure")
+	// ErrPermanent is returned for failures that retrying won't fix, e.g. a missing table
+	ErrPermanent = errors.New("permanent failure")
+)
+
+var errorClasses = []error{ErrNotFound, ErrConflict, ErrTransient, ErrPermanent, ErrShardUnavailable}
+
+// StorageError is a backend error together with its class.
+type StorageError struct {
+	Class error
+	Err   error
+}
+
+func (e *StorageError) Error() string {
+	return e.Class.Error() + ": " + e.Err.Error()
+}
+
+// Is makes a StorageError match its class.
+func (e *StorageError) Is(target error) bool {
+	return target == e.Class
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns err as a StorageError of class, unless err is nil, a
+// context error, or already classified.
+func Classify(class, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	for _, c := range errorClasses {
+		if errors.Is(err, c) {
+			return err
+		}
+	}
+	return &StorageError{Class: class, Err: err}
+}
+
+// IsRetryable reports whether an operation that failed with err may
+// succeed if tried again.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTransient) || IsConnectionError(err)
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrShardUnavailable is matched, with errors.Is, by every
+// UnavailableError.
+var ErrShardUnavailable = errors.New("shard unavailable")
+
+// UnavailableError is returned for a call to a shard that could not be
+// reached, or that isn't being tried while its circuit breaker is open.
+type UnavailableError struct {
+	Shard string
+	Err   error // the failure that made the shard unavailable, if known
+}
+
+func (e *UnavailableError) Error() string {
+	if e.Err == nil {
+		return "shard " + e.Shard + " unavailable"
+	}
+	return "shard " + e.Shard + " unavailable: " + e.Err.Error()
+}
+
+// Is makes every UnavailableError match ErrShardUnavailable.
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrShardUnavailable
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Pinger is implemented by backends that can check their connection
+// without touching any table.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
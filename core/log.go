@@ -0,0 +1,36 @@
+package core
+
+import (
+	"log/slog"
+
+	"github.com/rbastic/go-schemaless/logging"
+	"go.uber.org/zap"
+)
+
+// WithLogger sets the logger of the KVStore's own events: continuum
+// migrations, writes buffered because their shard could not be reached, and
+// buffer replays. The KVStore logs nothing without one, or with a nil one.
+// Backends take loggers of their own.
+func (kv *KVStore) WithLogger(l *zap.Logger) *KVStore {
+	if l == nil {
+		l = zap.NewNop()
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.log = l
+	for _, storages := range []map[string]Storage{kv.storages, kv.mstorages} {
+		for name, storage := range storages {
+			if b, ok := storage.(*buffered); ok {
+				storages[name] = &buffered{primary: b.primary, buffer: b.buffer, shard: b.shard, log: l}
+			}
+		}
+	}
+	return kv
+}
+
+// WithSlog is WithLogger for a slog.Handler.
+func (kv *KVStore) WithSlog(h slog.Handler) *KVStore {
+	return kv.WithLogger(logging.FromSlog(h))
+}
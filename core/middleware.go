@@ -0,0 +1,83 @@
+package core
+
+// Middleware wraps a shard's Storage with behaviour of its own, such as
+// logging or access control, and returns the wrapped Storage. A middleware
+// that wraps a backend implementing Purger, Expirer or Pinger should
+// implement them too, forwarding to the backend.
+type Middleware func(Storage) Storage
+
+// Chain returns a Middleware that applies mws in order, so the first of
+// them is the outermost: the first to see each call.
+func Chain(mws ...Middleware) Middleware {
+	return func(storage Storage) Storage {
+		for i := len(mws) - 1; i >= 0; i-- {
+			storage = mws[i](storage)
+		}
+		return storage
+	}
+}
+
+// use is a middleware registered with Use, UseShard or UseEach.
+type use struct {
+	shard string // empty for every shard
+	mw    func(shard string) Middleware
+}
+
+// Use wraps every shard of the KVStore, including shards added later and
+// those of a migration, in mws; see Chain for their order. Middlewares
+// added by a later call wrap those of earlier ones. They sit between the
+// KVStore's write buffer and the shard, so buffered writes are replayed
+// through them too.
+func (kv *KVStore) Use(mws ...Middleware) *KVStore {
+	return kv.UseShard("", mws...)
+}
+
+// UseShard is Use for the named shard alone.
+func (kv *KVStore) UseShard(shard string, mws ...Middleware) *KVStore {
+	mw := Chain(mws...)
+	return kv.use(use{shard: shard, mw: func(string) Middleware { return mw }})
+}
+
+// UseEach is Use for a middleware made for each shard, e.g. one that
+// labels what it records with the shard's name.
+func (kv *KVStore) UseEach(mw func(shard string) Middleware) *KVStore {
+	return kv.use(use{mw: mw})
+}
+
+func (kv *KVStore) use(u use) *KVStore {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.middleware = append(kv.middleware, u)
+	for name, storage := range kv.storages {
+		kv.storages[name] = kv.wrap(name, storage, []use{u})
+	}
+	// BeginMigration reuses the KVStore's own shards, already wrapped above
+	if !kv.mreused {
+		for name, storage := range kv.mstorages {
+			kv.mstorages[name] = kv.wrap(name, storage, []use{u})
+		}
+	}
+	return kv
+}
+
+// shardStorage returns the Storage the KVStore uses for a new shard: the
+// backend wrapped in every middleware, then in the write buffer. kv.mu must
+// be held.
+func (kv *KVStore) shardStorage(name string, storage Storage) Storage {
+	return kv.bufferedStorage(name, kv.wrap(name, storage, kv.middleware))
+}
+
+// wrap applies the middlewares of uses that apply to the named shard,
+// beneath its write buffer if it has one.
+func (kv *KVStore) wrap(name string, storage Storage, uses []use) Storage {
+	if b, ok := storage.(*buffered); ok {
+		return &buffered{primary: kv.wrap(name, b.primary, uses), buffer: b.buffer, shard: b.shard, log: b.log}
+	}
+	for _, u := range uses {
+		if u.shard == "" || u.shard == name {
+			storage = u.mw(name)(storage)
+		}
+	}
+	return storage
+}
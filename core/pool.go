@@ -0,0 +1,32 @@
+package core
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Pool sizes the connection pool of a SQL backend. Zero fields keep the
+// defaults of database/sql: no limit on open connections, two idle ones,
+// and connections that are reused for as long as they work.
+type Pool struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration // how long a connection is reused for
+	MaxIdleTime time.Duration // how long a connection may sit idle
+}
+
+// Apply sets the limits of p on db.
+func (p Pool) Apply(db *sql.DB) {
+	if p.MaxOpen > 0 {
+		db.SetMaxOpenConns(p.MaxOpen)
+	}
+	if p.MaxIdle > 0 {
+		db.SetMaxIdleConns(p.MaxIdle)
+	}
+	if p.MaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.MaxLifetime)
+	}
+	if p.MaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(p.MaxIdleTime)
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Consistency says whether a read may be served by a read replica, which
+// can lag behind its primary.
+type Consistency int
+
+const (
+	// Strong reads go to the shard's primary and see every completed write.
+	Strong Consistency = iota
+	// Eventual reads may go to a replica and miss the most recent writes.
+	Eventual
+)
+
+type consistencyKey struct{}
+
+// WithConsistency returns a context whose GetLatest calls read with the
+// given consistency. Without it, GetLatest is Strong.
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// ConsistencyFrom returns the read consistency requested by ctx.
+func ConsistencyFrom(ctx context.Context) Consistency {
+	c, _ := ctx.Value(consistencyKey{}).(Consistency)
+	return c
+}
+
+// replicated is the Storage of a shard with read replicas. Writes go to the
+// primary. Get by exact ref key and PartitionRead are spread across the
+// replicas, as is GetLatest when the caller asks for Eventual consistency;
+// every other read goes to the primary. A read falls back to the primary
+// when its replica fails.
+type replicated struct {
+	primary  Storage
+	replicas []Storage
+	next     uint32
+}
+
+// replicate returns primary alone when it has no replicas.
+func replicate(primary Storage, replicas []Storage) Storage {
+	if len(replicas) == 0 {
+		return primary
+	}
+	return &replicated{primary: primary, replicas: replicas}
+}
+
+// replica picks the next replica, round robin.
+func (r *replicated) replica() Storage {
+	n := atomic.AddUint32(&r.next, 1)
+	return r.replicas[int(n-1)%len(r.replicas)]
+}
+
+// Get reads from a replica. Cells are immutable, so a cell a replica has is
+// current; one it hasn't may not have replicated yet, and is looked for on
+// the primary.
+func (r *replicated) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	cell, found, err = r.replica().Get(ctx, tblName, rowKey, columnKey, refKey)
+	if err == nil && found {
+		return cell, found, nil
+	}
+	return r.primary.Get(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+func (r *replicated) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	if ConsistencyFrom(ctx) == Eventual {
+		cell, found, err = r.replica().GetLatest(ctx, tblName, rowKey, columnKey)
+		if err == nil {
+			return cell, found, nil
+		}
+	}
+	return r.primary.GetLatest(ctx, tblName, rowKey, columnKey)
+}
+
+func (r *replicated) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	return r.primary.History(ctx, tblName, rowKey, columnKey, limit)
+}
+
+func (r *replicated) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	return r.primary.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+}
+
+func (r *replicated) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	return r.primary.GetRowAsOf(ctx, tblName, rowKey, asOf)
+}
+
+func (r *replicated) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	cells, found, err = r.replica().PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+	if err == nil {
+		return cells, found, nil
+	}
+	return r.primary.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+}
+
+func (r *replicated) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	return r.primary.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+func (r *replicated) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := r.primary.(Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrExpiryNotSupported, r.primary)
+	}
+	return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+func (r *replicated) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error) {
+	expirer, ok := r.primary.(Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrExpiryNotSupported, r.primary)
+	}
+	return expirer.SweepExpired(ctx, tblName, now, limit)
+}
+
+func (r *replicated) Purge(ctx context.Context, tblName, rowKey string) (int64, error) {
+	purger, ok := r.primary.(Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", ErrPurgeNotSupported, r.primary)
+	}
+	return purger.Purge(ctx, tblName, rowKey)
+}
+
+func (r *replicated) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := r.primary.(Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrPurgeNotSupported, r.primary)
+	}
+	return purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+func (r *replicated) FindPartition(tblName, rowKey string) int {
+	return r.primary.FindPartition(tblName, rowKey)
+}
+
+func (r *replicated) ResetConnection(ctx context.Context, key string) error {
+	err := r.primary.ResetConnection(ctx, key)
+	for _, replica := range r.replicas {
+		if rerr := replica.ResetConnection(ctx, key); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (r *replicated) Destroy(ctx context.Context) error {
+	err := r.primary.Destroy(ctx)
+	for _, replica := range r.replicas {
+		if rerr := replica.Destroy(ctx); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
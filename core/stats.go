@@ -0,0 +1,39 @@
+package core
+
+// MigrationStats reports on a KVStore's continuum migration. While a
+// migration is in progress, reads look for a cell on its new shard first
+// and fall back to the old one. The share of reads served by the new
+// shards tends to rise as cells are copied across, but depends on which
+// rows are read, so it is not a measure of how many cells have moved.
+type MigrationStats struct {
+	Migrating bool
+	// Shards is the number of shards in the new continuum
+	Shards int
+	// MigratedReads were served by the new shard, FallbackReads by the old
+	MigratedReads uint64
+	FallbackReads uint64
+}
+
+// ReadRatio returns the share of reads served by the new shards, or 0 if no
+// reads have been made.
+func (ms MigrationStats) ReadRatio() float64 {
+	total := ms.MigratedReads + ms.FallbackReads
+	if total == 0 {
+		return 0
+	}
+	return float64(ms.MigratedReads) / float64(total)
+}
+
+// MigrationStats returns the read counts of the current migration, or of
+// the last one if none is in progress.
+func (kv *KVStore) MigrationStats() MigrationStats {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	ms := kv.migrationStats
+	ms.Migrating = kv.migration != nil
+	if ms.Migrating {
+		ms.Shards = len(kv.migration.Buckets())
+	}
+	return ms
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxTableNameLength is the longest table name ValidateTableName accepts.
+// It leaves room for the affixes of index names within the identifier
+// limits of MySQL (64 characters) and PostgreSQL (63).
+const MaxTableNameLength = 48
+
+// ErrInvalidTableName is returned for a table name that ValidateTableName
+// rejects.
+var ErrInvalidTableName = errors.New("invalid table name")
+
+// ValidateTableName returns an error wrapping ErrInvalidTableName unless
+// name is 1 to MaxTableNameLength ASCII letters, digits and underscores,
+// and doesn't start with a digit. The SQL backends check every table name
+// they are given, and quote it as well, so that a name can't change the
+// meaning of a statement.
+func ValidateTableName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidTableName)
+	}
+	if len(name) > MaxTableNameLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidTableName, name, MaxTableNameLength)
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidTableName, name)
+		}
+	}
+	return nil
+}
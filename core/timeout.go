@@ -0,0 +1,25 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts are the default deadlines of a backend's calls to its database,
+// by operation. A call whose context has an earlier deadline keeps it, and a
+// zero timeout adds none.
+type Timeouts struct {
+	Read  time.Duration // Get, GetLatest, History, GetLatestAsOf and GetRowAsOf
+	Scan  time.Duration // PartitionRead
+	Write time.Duration // Put, PutWithExpiry, Rewrite, Purge and PurgeCell
+	Sweep time.Duration // SweepExpired
+}
+
+// WithTimeout returns ctx with a deadline d from now, or ctx itself if d is
+// zero.
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+
+	"github.com/rbastic/go-schemaless/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trace starts the span of a call for key, naming the shard it is routed
+// to and, during a migration, its shard in the new continuum. kv.mu must be
+// held.
+func (kv *KVStore) trace(ctx context.Context, op, tblName, key string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		tracing.TableKey.String(tblName),
+		tracing.ShardKey.String(kv.continuum.Choose(key)),
+	}
+	if kv.migration != nil {
+		attrs = append(attrs, tracing.MigrationShardKey.String(kv.migration.Choose(key)))
+	}
+	return tracing.Start(ctx, "KVStore."+op, attrs...)
+}
+
+// tracePartition is trace for a PartitionRead. kv.mu must be held.
+func (kv *KVStore) tracePartition(ctx context.Context, tblName string, partitionNumber int) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{tracing.TableKey.String(tblName)}
+	if buckets := kv.continuum.Buckets(); partitionNumber >= 0 && partitionNumber < len(buckets) {
+		attrs = append(attrs, tracing.ShardKey.String(buckets[partitionNumber]))
+	}
+	if kv.migration != nil {
+		if buckets := kv.migration.Buckets(); partitionNumber >= 0 && partitionNumber < len(buckets) {
+			attrs = append(attrs, tracing.MigrationShardKey.String(buckets[partitionNumber]))
+		}
+	}
+	return tracing.Start(ctx, "KVStore.PartitionRead", attrs...)
+}
@@ -0,0 +1,42 @@
+package schemaless
+
+import (
+	"context"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Delete marks a column of rowKey as deleted by writing a tombstone (see
+// models.TombstoneBody) at the next ref key. The column is then not found by
+// Get, GetLatest, GetLatestAsOf or GetRowAsOf, and its tombstone is left out
+// of History, but earlier versions remain readable by ref key and in History
+// until the row is purged. Writing a cell with a higher ref key undeletes the
+// column. Deleting a column that has no cells, or is already deleted, does
+// nothing.
+func (ds *DataStore) Delete(ctx context.Context, tblName, rowKey, columnKey string) error {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return err
+	}
+
+	cell, found, err := source.GetLatest(ctx, tblName, rowKey, columnKey)
+	if err != nil {
+		return err
+	}
+	if !found || cell.IsTombstone() {
+		return nil
+	}
+
+	// tombstones are not validated against the column's schema
+	return source.Put(ctx, tblName, rowKey, columnKey, cell.RefKey+1, models.TombstoneBody)
+}
+
+func withoutTombstones(cells []models.Cell) []models.Cell {
+	kept := cells[:0]
+	for _, cell := range cells {
+		if !cell.IsTombstone() {
+			kept = append(kept, cell)
+		}
+	}
+	return kept
+}
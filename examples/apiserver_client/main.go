@@ -122,7 +122,7 @@ func main() {
 	}
 
 	{
-		findPartResponse, err := cl.FindPartition(storeName, tblName, cellID)
+		findPartResponse, err := cl.FindPartition(ctx, storeName, tblName, cellID)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -155,7 +155,7 @@ func main() {
 	// Check index partition
 	{
 		indexTableName := "trips_base_driver_partner_uuid"
-		findPartResponse, err := cl.FindPartition(storeName, indexTableName, driverPartnerUUID)
+		findPartResponse, err := cl.FindPartition(ctx, storeName, indexTableName, driverPartnerUUID)
 		if err != nil {
 			log.Fatal(err)
 		}
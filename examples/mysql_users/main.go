@@ -23,12 +23,7 @@ func newBackend(user, pass, host, port, schemaName string) *st.Storage {
 		WithPort(port).
 		WithDatabase(schemaName)
 
-	err := m.WithZap()
-	if err != nil {
-		panic(err)
-	}
-
-	err = m.Open()
+	err := m.Open()
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/client"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/httpapi"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// backend is the set of operations the CLI needs, implemented either
+// directly against the shards from a shards.json or against a running
+// schemalessd.
+type backend interface {
+	Get(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error)
+	GetLatest(ctx context.Context, storeName, tblName, rowKey, columnKey string) (models.Cell, bool, error)
+	History(ctx context.Context, storeName, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error)
+	Put(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64, body string) error
+	FindPartition(ctx context.Context, storeName, tblName, rowKey string) (int, error)
+	PartitionRead(ctx context.Context, storeName, tblName string, partitionNumber int, location string, value int64, limit int) ([]models.Cell, bool, error)
+	Close(ctx context.Context) error
+}
+
+type localBackend struct {
+	stores map[string]*schemaless.DataStore
+}
+
+func newLocalBackend(shardConfig *config.ShardConfig) (*localBackend, error) {
+	stores, err := httpapi.LoadStores(shardConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &localBackend{stores: stores}, nil
+}
+
+func (lb *localBackend) getStore(storeName string) (*schemaless.DataStore, error) {
+	store, ok := lb.stores[storeName]
+	if !ok {
+		return nil, fmt.Errorf("store %s not found", storeName)
+	}
+	return store, nil
+}
+
+func (lb *localBackend) Get(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error) {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+	return store.Get(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+func (lb *localBackend) GetLatest(ctx context.Context, storeName, tblName, rowKey, columnKey string) (models.Cell, bool, error) {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+	return store.GetLatest(ctx, tblName, rowKey, columnKey)
+}
+
+func (lb *localBackend) History(ctx context.Context, storeName, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error) {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return nil, false, err
+	}
+	return store.History(ctx, tblName, rowKey, columnKey, limit)
+}
+
+func (lb *localBackend) Put(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+func (lb *localBackend) FindPartition(ctx context.Context, storeName, tblName, rowKey string) (int, error) {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return -1, err
+	}
+	return store.FindPartition(tblName, rowKey)
+}
+
+func (lb *localBackend) PartitionRead(ctx context.Context, storeName, tblName string, partitionNumber int, location string, value int64, limit int) ([]models.Cell, bool, error) {
+	store, err := lb.getStore(storeName)
+	if err != nil {
+		return nil, false, err
+	}
+	return store.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+}
+
+func (lb *localBackend) Close(ctx context.Context) error {
+	for _, store := range lb.stores {
+		err := store.Destroy(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type remoteBackend struct {
+	c *client.Client
+}
+
+func newRemoteBackend(addr string) *remoteBackend {
+	return &remoteBackend{c: client.New().WithAddress(addr)}
+}
+
+func (rb *remoteBackend) Get(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error) {
+	return rb.c.Get(ctx, storeName, tblName, rowKey, columnKey, refKey)
+}
+
+func (rb *remoteBackend) GetLatest(ctx context.Context, storeName, tblName, rowKey, columnKey string) (models.Cell, bool, error) {
+	return rb.c.GetLatest(ctx, storeName, tblName, rowKey, columnKey)
+}
+
+func (rb *remoteBackend) History(ctx context.Context, storeName, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error) {
+	return rb.c.History(ctx, storeName, tblName, rowKey, columnKey, limit)
+}
+
+func (rb *remoteBackend) Put(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	pr, err := rb.c.Put(ctx, storeName, tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	if pr.Error != "" {
+		return errors.New(pr.Error)
+	}
+	return nil
+}
+
+func (rb *remoteBackend) FindPartition(ctx context.Context, storeName, tblName, rowKey string) (int, error) {
+	fpr, err := rb.c.FindPartition(ctx, storeName, tblName, rowKey)
+	if err != nil {
+		return -1, err
+	}
+	return fpr.PartitionNumber, nil
+}
+
+func (rb *remoteBackend) PartitionRead(ctx context.Context, storeName, tblName string, partitionNumber int, location string, value int64, limit int) ([]models.Cell, bool, error) {
+	return rb.c.PartitionRead(ctx, storeName, tblName, partitionNumber, location, value, limit)
+}
+
+func (rb *remoteBackend) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+const defaultHistoryLimit = 10
+
+// command is a single CLI/REPL operation. Its arguments always begin with
+// the store and table names, which is what tab completion relies on.
+type command struct {
+	usage   string
+	minArgs int
+	maxArgs int
+	run     func(ctx context.Context, b backend, args []string) (interface{}, error)
+}
+
+// prettyCell mirrors models.Cell for display. Bodies that are themselves
+// JSON are embedded as-is rather than printed as escaped strings.
+type prettyCell struct {
+	AddedAt    int64       `json:"addedAt"`
+	RowKey     string      `json:"rowKey"`
+	ColumnName string      `json:"columnName"`
+	RefKey     int64       `json:"refKey"`
+	Body       interface{} `json:"body"`
+	CreatedAt  int64       `json:"createdAt"`
+}
+
+func newPrettyCell(cell models.Cell) *prettyCell {
+	pc := &prettyCell{
+		AddedAt:    cell.AddedAt,
+		RowKey:     cell.RowKey,
+		ColumnName: cell.ColumnName,
+		RefKey:     cell.RefKey,
+		Body:       cell.Body,
+		CreatedAt:  cell.CreatedAt,
+	}
+	if json.Valid([]byte(cell.Body)) {
+		pc.Body = json.RawMessage(cell.Body)
+	}
+	return pc
+}
+
+type cellResult struct {
+	Found bool        `json:"found"`
+	Cell  *prettyCell `json:"cell,omitempty"`
+}
+
+func newCellResult(cell models.Cell, found bool) cellResult {
+	if !found {
+		return cellResult{}
+	}
+	return cellResult{Found: true, Cell: newPrettyCell(cell)}
+}
+
+type cellsResult struct {
+	Found bool          `json:"found"`
+	Cells []*prettyCell `json:"cells"`
+}
+
+func newCellsResult(cells []models.Cell, found bool) cellsResult {
+	res := cellsResult{Found: found, Cells: []*prettyCell{}}
+	for _, cell := range cells {
+		res.Cells = append(res.Cells, newPrettyCell(cell))
+	}
+	return res
+}
+
+var commands = map[string]command{
+	"get": {
+		usage:   "get <store> <table> <rowKey> <columnKey> <refKey>",
+		minArgs: 5,
+		maxArgs: 5,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			refKey, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			cell, found, err := b.Get(ctx, args[0], args[1], args[2], args[3], refKey)
+			if err != nil {
+				return nil, err
+			}
+			return newCellResult(cell, found), nil
+		},
+	},
+	"get-latest": {
+		usage:   "get-latest <store> <table> <rowKey> <columnKey>",
+		minArgs: 4,
+		maxArgs: 4,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			cell, found, err := b.GetLatest(ctx, args[0], args[1], args[2], args[3])
+			if err != nil {
+				return nil, err
+			}
+			return newCellResult(cell, found), nil
+		},
+	},
+	"history": {
+		usage:   "history <store> <table> <rowKey> <columnKey> [limit]",
+		minArgs: 4,
+		maxArgs: 5,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			limit := defaultHistoryLimit
+			if len(args) == 5 {
+				var err error
+				limit, err = strconv.Atoi(args[4])
+				if err != nil {
+					return nil, err
+				}
+			}
+			cells, found, err := b.History(ctx, args[0], args[1], args[2], args[3], limit)
+			if err != nil {
+				return nil, err
+			}
+			return newCellsResult(cells, found), nil
+		},
+	},
+	"put": {
+		usage:   "put <store> <table> <rowKey> <columnKey> <refKey> <body>",
+		minArgs: 6,
+		maxArgs: -1,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			refKey, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			// the body may contain spaces when entered in the REPL
+			body := strings.Join(args[5:], " ")
+			err = b.Put(ctx, args[0], args[1], args[2], args[3], refKey, body)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]bool{"success": true}, nil
+		},
+	},
+	"find-partition": {
+		usage:   "find-partition <store> <table> <rowKey>",
+		minArgs: 3,
+		maxArgs: 3,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			partitionNumber, err := b.FindPartition(ctx, args[0], args[1], args[2])
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"partitionNumber": partitionNumber}, nil
+		},
+	},
+	"partition-read": {
+		usage:   "partition-read <store> <table> <partitionNumber> <location> <value> <limit>",
+		minArgs: 6,
+		maxArgs: 6,
+		run: func(ctx context.Context, b backend, args []string) (interface{}, error) {
+			partitionNumber, err := strconv.Atoi(args[2])
+			if err != nil {
+				return nil, err
+			}
+			value, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			limit, err := strconv.Atoi(args[5])
+			if err != nil {
+				return nil, err
+			}
+			cells, found, err := b.PartitionRead(ctx, args[0], args[1], partitionNumber, args[3], value, limit)
+			if err != nil {
+				return nil, err
+			}
+			return newCellsResult(cells, found), nil
+		},
+	},
+}
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func runCommand(ctx context.Context, b backend, out io.Writer, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+
+	result, err := cmd.run(ctx, b, args)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(out, result)
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	text, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(text))
+	return err
+}
@@ -0,0 +1,83 @@
+// Command schemaless is an operator CLI for ad-hoc reads and writes against
+// schemalessd datastores. It either opens the shards described by a
+// schemalessd shards.json directly, or talks to a running schemalessd.
+//
+//	schemaless -config shards.json get-latest trips trips <rowKey> BASE
+//	schemaless -addr http://localhost:4444 -config shards.json repl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: schemaless [flags] <command> [args...]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "  repl\n")
+}
+
+func main() {
+	configPtr := flag.String("config", "", "schemalessd shards.json; used to open shards directly, and for tab completion in the repl")
+	addrPtr := flag.String("addr", "", "address of a running schemalessd (e.g. http://localhost:4444); takes precedence over -config for data access")
+	timeoutPtr := flag.Duration("timeout", 30*time.Second, "timeout for each command")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *configPtr == "" && *addrPtr == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	var shardConfig *config.ShardConfig
+	if *configPtr != "" {
+		var err error
+		shardConfig, err = config.LoadConfig(*configPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var b backend
+	if *addrPtr != "" {
+		b = newRemoteBackend(strings.TrimSuffix(*addrPtr, "/"))
+	} else {
+		lb, err := newLocalBackend(shardConfig)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		b = lb
+	}
+	defer b.Close(context.Background())
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "repl" {
+		err := repl(b, shardConfig, *timeoutPtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeoutPtr)
+	defer cancel()
+
+	err := runCommand(ctx, b, os.Stdout, args[0], args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+)
+
+const historyFileName = ".schemaless_history"
+
+// completer offers command names, then store names, then the tables of
+// the chosen store. Stores and tables come from the shards.json, if any.
+func completer(shardConfig *config.ShardConfig) *readline.PrefixCompleter {
+	stores := func(line string) []string {
+		if shardConfig == nil {
+			return nil
+		}
+		var names []string
+		for _, ds := range shardConfig.Datastores {
+			names = append(names, ds.Name)
+		}
+		return names
+	}
+
+	tables := func(line string) []string {
+		if shardConfig == nil {
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil
+		}
+		for i := range shardConfig.Datastores {
+			if shardConfig.Datastores[i].Name == fields[1] {
+				return shardConfig.Datastores[i].Tables()
+			}
+		}
+		return nil
+	}
+
+	var items []readline.PrefixCompleterInterface
+	for _, name := range commandNames() {
+		items = append(items, readline.PcItem(name, readline.PcItemDynamic(stores, readline.PcItemDynamic(tables))))
+	}
+	items = append(items, readline.PcItem("help"), readline.PcItem("exit"))
+
+	return readline.NewPrefixCompleter(items...)
+}
+
+func repl(b backend, shardConfig *config.ShardConfig, timeout time.Duration) error {
+	rlConfig := &readline.Config{
+		Prompt:          "schemaless> ",
+		AutoComplete:    completer(shardConfig),
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		rlConfig.HistoryFile = filepath.Join(home, historyFileName)
+	}
+
+	rl, err := readline.NewEx(rlConfig)
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	for {
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			for _, name := range commandNames() {
+				fmt.Fprintln(rl.Stdout(), commands[name].usage)
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = runCommand(ctx, b, rl.Stdout(), fields[0], fields[1:])
+		cancel()
+		if err != nil {
+			fmt.Fprintln(rl.Stderr(), err)
+		}
+	}
+}
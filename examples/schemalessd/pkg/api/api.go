@@ -1,11 +1,30 @@
 package api
 
-import "github.com/rbastic/go-schemaless/models"
+import (
+	"time"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/models"
+)
 
 // StatusResponse contains a simplified health response.
 type StatusResponse struct {
 	Error   string `json:"error,omitempty"`
 	Success bool   `json:"success"`
+	// Shards reports the health of every shard, on /service/status only.
+	Shards []ShardHealth `json:"shards,omitempty"`
+}
+
+// ShardHealth is the state of a shard's circuit breaker: "closed" while it
+// is healthy, "open" while calls to it fail fast, and "half-open" while a
+// trial call is deciding which.
+type ShardHealth struct {
+	Store               string    `json:"store"`
+	Shard               string    `json:"shard"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	Since               time.Time `json:"since"`
 }
 
 // PutRequest is for issuing Put() calls to the Schemaless data store
@@ -16,12 +35,23 @@ type PutRequest struct {
 	ColumnKey string `json:"columnKey"`
 	RefKey    int64  `json:"refKey"`
 	Body      string `json:"body"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"` // nanoseconds since the epoch; 0 never expires
 }
 
 // PutResponse specifies the response for a Put operation
 type PutResponse struct {
 	Error   string `json:"error,omitempty"`
 	Success bool   `json:"success"`
+	// ValidationErrors is set, along with Error, when the body does not
+	// match the column's schema; the response status is then 422.
+	ValidationErrors []ValidationError `json:"validationErrors,omitempty"`
+}
+
+// ValidationError is one way a body failed to match a column's schema.
+type ValidationError struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Path          string `json:"path"` // JSON pointer into the body
+	Message       string `json:"message"`
 }
 
 type GetRequest struct {
@@ -45,6 +75,9 @@ type GetLatestRequest struct {
 	Table     string `json:"table"`
 	RowKey    string `json:"rowKey"`
 	ColumnKey string `json:"columnKey"`
+	// Consistency is "strong" (the default), read from the shard's
+	// primary, or "eventual", which may be served by a lagging replica.
+	Consistency string `json:"consistency,omitempty"`
 }
 
 type GetLatestResponse struct {
@@ -55,6 +88,57 @@ type GetLatestResponse struct {
 	Cell models.Cell `json:"cell"`
 }
 
+type HistoryRequest struct {
+	Store     string `json:"store"`
+	Table     string `json:"table"`
+	RowKey    string `json:"rowKey"`
+	ColumnKey string `json:"columnKey"`
+	Limit     int    `json:"limit"`
+}
+
+type HistoryResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+	Found   bool   `json:"found"`
+
+	Cells []models.Cell `json:"cells"`
+}
+
+// GetLatestAsOfRequest asks for the latest cell created at or before AsOf,
+// which is expressed in nanoseconds since the epoch like Cell.CreatedAt.
+type GetLatestAsOfRequest struct {
+	Store     string `json:"store"`
+	Table     string `json:"table"`
+	RowKey    string `json:"rowKey"`
+	ColumnKey string `json:"columnKey"`
+	AsOf      int64  `json:"asOf"`
+}
+
+type GetLatestAsOfResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+	Found   bool   `json:"found"`
+
+	Cell models.Cell `json:"cell"`
+}
+
+// GetRowAsOfRequest asks for the latest cell of every column in a row
+// created at or before AsOf (nanoseconds since the epoch).
+type GetRowAsOfRequest struct {
+	Store  string `json:"store"`
+	Table  string `json:"table"`
+	RowKey string `json:"rowKey"`
+	AsOf   int64  `json:"asOf"`
+}
+
+type GetRowAsOfResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+	Found   bool   `json:"found"`
+
+	Cells []models.Cell `json:"cells"`
+}
+
 type PartitionReadRequest struct {
 	Store           string `json:"store"`
 	Table           string `json:"table"`
@@ -84,3 +168,53 @@ type FindPartitionResponse struct {
 	Error   string `json:"error,omitempty"`
 	Success bool   `json:"success"`
 }
+
+// DeleteRequest writes a tombstone over a column of a row.
+type DeleteRequest struct {
+	Store     string `json:"store"`
+	Table     string `json:"table"`
+	RowKey    string `json:"rowKey"`
+	ColumnKey string `json:"columnKey"`
+}
+
+type DeleteResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+}
+
+// PurgeRequest physically deletes every cell of a row, and its index
+// entries. Reason is kept in the purge's audit record.
+type PurgeRequest struct {
+	Store  string `json:"store"`
+	Table  string `json:"table"`
+	RowKey string `json:"rowKey"`
+	Reason string `json:"reason"`
+}
+
+type PurgeResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+
+	Record schemaless.PurgeRecord `json:"record"`
+}
+
+// RotateKeyRequest rotates the data keys of an encrypted store's cell table
+// and index tables on every shard, after re-reading its master key file,
+// then re-encrypts every cell under the new data keys in the background.
+type RotateKeyRequest struct {
+	Store string `json:"store"`
+}
+
+// RotatedShard is the new data key version of one table on one shard.
+type RotatedShard struct {
+	Shard string `json:"shard"`
+	Table string `json:"table"`
+	KeyID int64  `json:"keyId"`
+}
+
+type RotateKeyResponse struct {
+	Error   string `json:"error,omitempty"`
+	Success bool   `json:"success"`
+
+	Shards []RotatedShard `json:"shards"`
+}
@@ -9,10 +9,12 @@ import (
 
 	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
 	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/tracing"
 
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 const contentTypeJSON = "application/json"
@@ -30,11 +32,22 @@ func (c *Client) WithAddress(addr string) *Client {
 	return c
 }
 
+// do sends request in a client span, carrying the trace of the request's
+// context to the server in its headers.
+func (c *Client) do(request *http.Request) (response *http.Response, err error) {
+	request, span := tracing.StartHTTPClient(request)
+	defer func() { tracing.End(span, err) }()
+
+	response, err = http.DefaultClient.Do(request)
+	if err == nil {
+		tracing.SetStatusCode(span, response.StatusCode)
+	}
+	return response, err
+}
+
 func (c *Client) Get(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
 	postURL := c.Address + "/api/get"
 
-	// TODO: make the context part of the request
-
 	var getRequest api.GetRequest
 	getRequest.Store = storeName
 	getRequest.Table = tblName
@@ -47,14 +60,13 @@ func (c *Client) Get(ctx context.Context, storeName, tblName, rowKey, columnKey
 		return models.Cell{}, false, err
 	}
 
-	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(getRequestMarshal))
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(getRequestMarshal))
 	if err != nil {
 		return models.Cell{}, false, err
 	}
 	request.Header.Set("Content-Type", contentTypeJSON)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return models.Cell{}, false, err
 	}
@@ -79,27 +91,33 @@ func (c *Client) Get(ctx context.Context, storeName, tblName, rowKey, columnKey
 }
 
 func (c *Client) GetLatest(ctx context.Context, storeName, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	return c.GetLatestWithConsistency(ctx, storeName, tblName, rowKey, columnKey, "")
+}
+
+// GetLatestWithConsistency is GetLatest with a read consistency, "strong"
+// or "eventual"; an eventual read may be served by a lagging replica.
+func (c *Client) GetLatestWithConsistency(ctx context.Context, storeName, tblName, rowKey, columnKey, consistency string) (cell models.Cell, found bool, err error) {
 	postURL := c.Address + "/api/getLatest"
 
-	var getLatestRequest api.GetRequest
+	var getLatestRequest api.GetLatestRequest
 	getLatestRequest.Store = storeName
 	getLatestRequest.Table = tblName
 	getLatestRequest.RowKey = rowKey
 	getLatestRequest.ColumnKey = columnKey
+	getLatestRequest.Consistency = consistency
 
 	getLatestRequestMarshal, err := json.Marshal(getLatestRequest)
 	if err != nil {
 		return models.Cell{}, false, err
 	}
 
-	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(getLatestRequestMarshal))
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(getLatestRequestMarshal))
 	if err != nil {
 		return models.Cell{}, false, err
 	}
 	request.Header.Set("Content-Type", contentTypeJSON)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return models.Cell{}, false, err
 	}
@@ -124,11 +142,144 @@ func (c *Client) GetLatest(ctx context.Context, storeName, tblName, rowKey, colu
 	return glr.Cell, glr.Found, nil
 }
 
+func (c *Client) GetLatestAsOf(ctx context.Context, storeName, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	postURL := c.Address + "/api/getLatestAsOf"
+
+	var getLatestAsOfRequest api.GetLatestAsOfRequest
+	getLatestAsOfRequest.Store = storeName
+	getLatestAsOfRequest.Table = tblName
+	getLatestAsOfRequest.RowKey = rowKey
+	getLatestAsOfRequest.ColumnKey = columnKey
+	getLatestAsOfRequest.AsOf = asOf.UnixNano()
+
+	getLatestAsOfRequestMarshal, err := json.Marshal(getLatestAsOfRequest)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(getLatestAsOfRequestMarshal))
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+	request.Header.Set("Content-Type", contentTypeJSON)
+
+	response, err := c.do(request)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+	defer response.Body.Close()
+
+	var responseBody []byte
+	responseBody, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+
+	var glr api.GetLatestAsOfResponse
+
+	err = json.Unmarshal(responseBody, &glr)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+	if glr.Error != "" {
+		return models.Cell{}, false, errors.New(glr.Error)
+	}
+
+	return glr.Cell, glr.Found, nil
+}
+
+func (c *Client) History(ctx context.Context, storeName, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	postURL := c.Address + "/api/history"
+
+	var historyRequest api.HistoryRequest
+	historyRequest.Store = storeName
+	historyRequest.Table = tblName
+	historyRequest.RowKey = rowKey
+	historyRequest.ColumnKey = columnKey
+	historyRequest.Limit = limit
+
+	historyRequestMarshal, err := json.Marshal(historyRequest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(historyRequestMarshal))
+	if err != nil {
+		return nil, false, err
+	}
+	request.Header.Set("Content-Type", contentTypeJSON)
+
+	response, err := c.do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	var responseBody []byte
+	responseBody, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var hr api.HistoryResponse
+	err = json.Unmarshal(responseBody, &hr)
+	if err != nil {
+		return nil, false, err
+	}
+	if hr.Error != "" {
+		return nil, false, errors.New(hr.Error)
+	}
+
+	return hr.Cells, hr.Found, nil
+}
+
+func (c *Client) GetRowAsOf(ctx context.Context, storeName, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	postURL := c.Address + "/api/getRowAsOf"
+
+	var getRowAsOfRequest api.GetRowAsOfRequest
+	getRowAsOfRequest.Store = storeName
+	getRowAsOfRequest.Table = tblName
+	getRowAsOfRequest.RowKey = rowKey
+	getRowAsOfRequest.AsOf = asOf.UnixNano()
+
+	getRowAsOfRequestMarshal, err := json.Marshal(getRowAsOfRequest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(getRowAsOfRequestMarshal))
+	if err != nil {
+		return nil, false, err
+	}
+	request.Header.Set("Content-Type", contentTypeJSON)
+
+	response, err := c.do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	var responseBody []byte
+	responseBody, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var grr api.GetRowAsOfResponse
+	err = json.Unmarshal(responseBody, &grr)
+	if err != nil {
+		return nil, false, err
+	}
+	if grr.Error != "" {
+		return nil, false, errors.New(grr.Error)
+	}
+
+	return grr.Cells, grr.Found, nil
+}
+
 func (c *Client) PartitionRead(ctx context.Context, storeName, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
 	postURL := c.Address + "/api/partitionRead"
 
-	// TODO: add context
-
 	var partitionReadRequest api.PartitionReadRequest
 	partitionReadRequest.Store = storeName
 	partitionReadRequest.Table = tblName
@@ -142,14 +293,13 @@ func (c *Client) PartitionRead(ctx context.Context, storeName, tblName string, p
 		return nil, false, err
 	}
 
-	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(partitionReadRequestMarshal))
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(partitionReadRequestMarshal))
 	if err != nil {
 		return nil, false, err
 	}
 	request.Header.Set("Content-Type", contentTypeJSON)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return nil, false, err
 	}
@@ -174,7 +324,7 @@ func (c *Client) PartitionRead(ctx context.Context, storeName, tblName string, p
 	return prr.Cells, prr.Found, nil
 }
 
-func (c *Client) FindPartition(storeName, tblName, rowKey string) (*api.FindPartitionResponse, error) {
+func (c *Client) FindPartition(ctx context.Context, storeName, tblName, rowKey string) (*api.FindPartitionResponse, error) {
 	postURL := c.Address + "/api/findPartition"
 
 	var findRequest api.FindPartitionRequest
@@ -187,14 +337,13 @@ func (c *Client) FindPartition(storeName, tblName, rowKey string) (*api.FindPart
 		return nil, err
 	}
 
-	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(findRequestMarshal))
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(findRequestMarshal))
 	if err != nil {
 		return nil, err
 	}
 	request.Header.Set("Content-Type", contentTypeJSON)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -220,9 +369,13 @@ func (c *Client) FindPartition(storeName, tblName, rowKey string) (*api.FindPart
 }
 
 func (c *Client) Put(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64, body string) (*api.PutResponse, error) {
-	postURL := c.Address + "/api/put"
+	return c.PutWithExpiry(ctx, storeName, tblName, rowKey, columnKey, refKey, body, time.Time{})
+}
 
-	// TODO: make the context part of the request
+// PutWithExpiry is Put for a cell that is no longer read back after
+// expiresAt, and is later deleted. A zero expiresAt never expires.
+func (c *Client) PutWithExpiry(ctx context.Context, storeName, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) (*api.PutResponse, error) {
+	postURL := c.Address + "/api/put"
 
 	var putRequest api.PutRequest
 	putRequest.Store = storeName
@@ -231,20 +384,22 @@ func (c *Client) Put(ctx context.Context, storeName, tblName, rowKey, columnKey
 	putRequest.ColumnKey = columnKey
 	putRequest.RefKey = refKey
 	putRequest.Body = body
+	if !expiresAt.IsZero() {
+		putRequest.ExpiresAt = expiresAt.UnixNano()
+	}
 
 	putRequestMarshal, err := json.Marshal(putRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(putRequestMarshal))
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(putRequestMarshal))
 	if err != nil {
 		return nil, err
 	}
 	request.Header.Set("Content-Type", contentTypeJSON)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := c.do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -260,3 +415,81 @@ func (c *Client) Put(ctx context.Context, storeName, tblName, rowKey, columnKey
 	err = json.Unmarshal(responseBody, pr)
 	return pr, err
 }
+
+// Delete writes a tombstone over columnKey of rowKey, hiding it from reads
+// of the latest value.
+func (c *Client) Delete(ctx context.Context, storeName, tblName, rowKey, columnKey string) (*api.DeleteResponse, error) {
+	postURL := c.Address + "/api/delete"
+
+	var deleteRequest api.DeleteRequest
+	deleteRequest.Store = storeName
+	deleteRequest.Table = tblName
+	deleteRequest.RowKey = rowKey
+	deleteRequest.ColumnKey = columnKey
+
+	deleteRequestMarshal, err := json.Marshal(deleteRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(deleteRequestMarshal))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentTypeJSON)
+
+	response, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var responseBody []byte
+	responseBody, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := new(api.DeleteResponse)
+	err = json.Unmarshal(responseBody, dr)
+	return dr, err
+}
+
+// Purge physically deletes every cell of rowKey and its index entries. The
+// response carries the purge's audit record.
+func (c *Client) Purge(ctx context.Context, storeName, tblName, rowKey, reason string) (*api.PurgeResponse, error) {
+	postURL := c.Address + "/api/purge"
+
+	var purgeRequest api.PurgeRequest
+	purgeRequest.Store = storeName
+	purgeRequest.Table = tblName
+	purgeRequest.RowKey = rowKey
+	purgeRequest.Reason = reason
+
+	purgeRequestMarshal, err := json.Marshal(purgeRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(purgeRequestMarshal))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentTypeJSON)
+
+	response, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var responseBody []byte
+	responseBody, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := new(api.PurgeResponse)
+	err = json.Unmarshal(responseBody, pr)
+	return pr, err
+}
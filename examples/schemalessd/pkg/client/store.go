@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Store is a Client bound to one datastore. It has the same read and write
+// methods as schemaless.DataStore, so it can back a table.Table or a typed
+// client generated by schemaless-gen.
+type Store struct {
+	c    *Client
+	name string
+}
+
+// Store returns the datastore storeName as served by c.
+func (c *Client) Store(storeName string) *Store {
+	return &Store{c: c, name: storeName}
+}
+
+// Get implements Storage.Get()
+func (s *Store) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error) {
+	return s.c.Get(ctx, s.name, tblName, rowKey, columnKey, refKey)
+}
+
+// GetLatest implements Storage.GetLatest()
+func (s *Store) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (models.Cell, bool, error) {
+	return s.c.GetLatest(ctx, s.name, tblName, rowKey, columnKey)
+}
+
+// History implements Storage.History()
+func (s *Store) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error) {
+	return s.c.History(ctx, s.name, tblName, rowKey, columnKey, limit)
+}
+
+// GetRowAsOf implements Storage.GetRowAsOf()
+func (s *Store) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) ([]models.Cell, bool, error) {
+	return s.c.GetRowAsOf(ctx, s.name, tblName, rowKey, asOf)
+}
+
+// Put implements Storage.Put(), turning an unsuccessful response into an
+// error.
+func (s *Store) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	resp, err := s.c.Put(ctx, s.name, tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// Delete implements DataStore.Delete(), turning an unsuccessful response
+// into an error.
+func (s *Store) Delete(ctx context.Context, tblName, rowKey, columnKey string) error {
+	resp, err := s.c.Delete(ctx, s.name, tblName, rowKey, columnKey)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
 )
 
 type Shard struct {
@@ -12,6 +17,65 @@ type Shard struct {
 	Port     string `json:"port"`
 	Username string `json:"user"`
 	Password string `json:"password"`
+	// Replicas of the shard serve reads that tolerate replication lag.
+	Replicas []Shard `json:"replicas,omitempty"`
+	// Pool sizes the shard's connection pool (mysql and postgres).
+	Pool Pool `json:"pool"`
+	// TLS encrypts the connections to the shard (mysql and postgres).
+	TLS TLS `json:"tls"`
+	// Params are added to the shard's DSN, e.g. {"charset": "utf8mb4"} for
+	// mysql or {"application_name": "schemalessd"} for postgres.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Pool sizes a shard's connection pool; see core.Pool. Empty fields keep
+// the defaults of database/sql.
+type Pool struct {
+	MaxOpen     int    `json:"max_open,omitempty"`
+	MaxIdle     int    `json:"max_idle,omitempty"`
+	MaxLifetime string `json:"max_lifetime,omitempty"` // e.g. "30m"
+	MaxIdleTime string `json:"max_idle_time,omitempty"`
+}
+
+// TLS is how the connections to a shard are encrypted; see core.TLS.
+type TLS struct {
+	Mode     string `json:"mode,omitempty"` // "disable" (the default), "require", "verify-ca" or "verify-full"
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// CorePool parses the shard's connection pool settings.
+func (s Shard) CorePool() (core.Pool, error) {
+	pool := core.Pool{MaxOpen: s.Pool.MaxOpen, MaxIdle: s.Pool.MaxIdle}
+	for _, d := range []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"pool max_lifetime", s.Pool.MaxLifetime, &pool.MaxLifetime},
+		{"pool max_idle_time", s.Pool.MaxIdleTime, &pool.MaxIdleTime},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return pool, fmt.Errorf("%s: %w", d.name, err)
+		}
+		*d.d = v
+	}
+	return pool, nil
+}
+
+// CoreTLS returns the shard's TLS settings.
+func (s Shard) CoreTLS() core.TLS {
+	return core.TLS{
+		Mode:     s.TLS.Mode,
+		CAFile:   s.TLS.CAFile,
+		CertFile: s.TLS.CertFile,
+		KeyFile:  s.TLS.KeyFile,
+	}
 }
 
 type Index struct {
@@ -29,6 +93,78 @@ type ColumnDef struct {
 	IndexData  IndexDataRecord `json:"index_data"`
 }
 
+// ColumnSchema lists the JSON Schema versions of a column, oldest first.
+// Each version must be backward compatible with the one before it.
+type ColumnSchema struct {
+	Table    string   `json:"table,omitempty"` // defaults to the datastore's cell table
+	Column   string   `json:"column"`
+	Versions []string `json:"versions"` // paths to JSON Schema files
+	// GoType names the type schemaless-gen generates for the column's body.
+	GoType string `json:"go_type,omitempty"`
+}
+
+// CircuitBreaker tunes the breaker guarding each shard; see
+// storage/breaker. Empty fields keep their defaults.
+type CircuitBreaker struct {
+	FailureThreshold int    `json:"failure_threshold,omitempty"` // default 5
+	Cooldown         string `json:"cooldown,omitempty"`          // default "30s"
+	ProbeInterval    string `json:"probe_interval,omitempty"`    // default "10s"
+}
+
+// Retry tunes the retrying of each shard's idempotent calls; see
+// storage/retry. Empty fields keep their defaults.
+type Retry struct {
+	Attempts   int    `json:"attempts,omitempty"`    // default 3, 1 disables retries
+	Backoff    string `json:"backoff,omitempty"`     // default "50ms"
+	MaxBackoff string `json:"max_backoff,omitempty"` // default "1s"
+}
+
+// Middleware chooses the middlewares every shard of a datastore is wrapped
+// in; see storage/middleware.
+type Middleware struct {
+	// LogCalls logs every call to a shard at debug level
+	LogCalls bool `json:"log_calls,omitempty"`
+	// ReadOnly rejects writes to every shard, ReadOnlyShards to those listed
+	ReadOnly       bool     `json:"read_only,omitempty"`
+	ReadOnlyShards []string `json:"read_only_shards,omitempty"`
+	// Quotas limit the calls per second to each table, by table name
+	Quotas map[string]Quota `json:"quotas,omitempty"`
+}
+
+// Quota limits the reads and writes per second to a table, across every
+// shard; zero is unlimited. See middleware.Limit.
+type Quota struct {
+	ReadsPerSecond  float64 `json:"reads_per_second,omitempty"`
+	WritesPerSecond float64 `json:"writes_per_second,omitempty"`
+	Burst           int     `json:"burst,omitempty"`
+}
+
+// SlowQueries are how long a SQL statement must take to be logged at info,
+// warn or error level; see logging.Thresholds. Empty fields are not used.
+type SlowQueries struct {
+	Info  string `json:"info,omitempty"` // e.g. "100ms"
+	Warn  string `json:"warn,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Timeouts are the default deadlines of each shard's calls to its database;
+// see core.Timeouts. Empty fields add none.
+type Timeouts struct {
+	Read  string `json:"read,omitempty"` // e.g. "2s"
+	Scan  string `json:"scan,omitempty"`
+	Write string `json:"write,omitempty"`
+	Sweep string `json:"sweep,omitempty"`
+}
+
+// RetentionPolicy limits the versions kept of a column; see
+// compaction.Policy.
+type RetentionPolicy struct {
+	Table    string `json:"table,omitempty"` // defaults to the datastore's cell table
+	Column   string `json:"column"`
+	KeepLast int    `json:"keep_last,omitempty"`
+	MaxAge   string `json:"max_age,omitempty"` // e.g. "720h"
+}
+
 type ShardConfig struct {
 	Driver     string            `json:"driver"`
 	Datastores []DatastoreConfig `json:"datastores"`
@@ -38,6 +174,58 @@ type DatastoreConfig struct {
 	Name    string  `json:"name"`
 	Shards  []Shard `json:"shards"`
 	Indexes []Index `json:"indexes"`
+	// Compression names the codec ("lz4", "zstd" or "snappy") used for
+	// new cells in the datastore's cell table. Empty leaves bodies as-is.
+	Compression string `json:"compression,omitempty"`
+	// EncryptionKeyFile names a master key file (see
+	// storage/encryption.FileKeyProvider). When set, new cells in the
+	// datastore's cell table are encrypted at rest.
+	EncryptionKeyFile string `json:"encryption_key_file,omitempty"`
+	// Schemas validate the bodies of Puts to the listed columns.
+	Schemas []ColumnSchema `json:"schemas,omitempty"`
+	// PurgeAuditLog names a file that every purge of a row is recorded in,
+	// one JSON line each.
+	PurgeAuditLog string `json:"purge_audit_log,omitempty"`
+	// Retention policies are enforced by a background compactor on each
+	// shard, every CompactionInterval ("1h" if empty).
+	Retention          []RetentionPolicy `json:"retention,omitempty"`
+	CompactionInterval string            `json:"compaction_interval,omitempty"`
+	// Expired cells are deleted from each shard every ExpirySweepInterval
+	// ("1m" if empty).
+	ExpirySweepInterval string `json:"expiry_sweep_interval,omitempty"`
+	// Buffer takes the writes of shards that can't be reached, and is
+	// replayed to them every BufferReplayInterval ("10s" if empty). With
+	// sqlite the buffer is a local file, and only its presence matters.
+	Buffer               *Shard `json:"buffer,omitempty"`
+	BufferReplayInterval string `json:"buffer_replay_interval,omitempty"`
+	// CircuitBreaker tunes the breakers that guard every shard and replica.
+	CircuitBreaker CircuitBreaker `json:"circuit_breaker"`
+	// Retry tunes the retries of every shard's and replica's reads, which
+	// happen inside the circuit breaker: a call counts as one failure
+	// however many times it was tried.
+	Retry Retry `json:"retry"`
+	// Middleware wraps every shard in logging, read-only mode or quotas.
+	Middleware Middleware `json:"middleware"`
+	// SlowQueries log the statements of every shard that pass a threshold.
+	SlowQueries SlowQueries `json:"slow_queries"`
+	// Timeouts bound the calls of every shard whose request has no earlier
+	// deadline.
+	Timeouts Timeouts `json:"timeouts"`
+}
+
+// Tables returns the cell table and every secondary index table that
+// schemalessd creates for a datastore.
+func (d *DatastoreConfig) Tables() []string {
+	tables := []string{d.Name}
+	for _, idx := range d.Indexes {
+		if len(idx.ColumnDefs) == 0 {
+			continue
+		}
+		indexColumn := strings.ToLower(idx.ColumnDefs[0].ColumnName)
+		sourceField := idx.ColumnDefs[0].IndexData.SourceField
+		tables = append(tables, d.Name+"_"+indexColumn+"_"+sourceField)
+	}
+	return tables
 }
 
 func LoadConfig(file string) (*ShardConfig, error) {
@@ -0,0 +1,134 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	stsqlite "github.com/rbastic/go-schemaless/storage/sqlite"
+)
+
+// replayBatchSize is how many buffered cells are replayed at a time.
+const replayBatchSize = 1000
+
+// openBuffer opens the write buffer of a datastore, wrapped for
+// compression and encryption the same way as its shards.
+func (hs *HTTPAPI) openBuffer(driver, prefix string, datastore *config.DatastoreConfig) (core.Storage, error) {
+	label := prefix + "_buffer"
+
+	opts, err := newBackendOptions(datastore)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer core.Storage
+	switch driver {
+	case "mysql":
+		store, err := hs.openMysqlShard(label, *datastore.Buffer, opts)
+		if err != nil {
+			return nil, err
+		}
+		buffer = store
+	case "postgres":
+		store, err := hs.openPostgresShard(label, *datastore.Buffer, opts)
+		if err != nil {
+			return nil, err
+		}
+		buffer = store
+	case "sqlite3":
+		store, err := stsqlite.New(prefix, label)
+		if err != nil {
+			return nil, err
+		}
+		store.WithLogger(hs.l).WithSlowQueries(opts.slow).WithTimeouts(opts.timeouts)
+		err = hs.metrics.InstrumentPool(opts.tblName, label, store.Stats)
+		if err != nil {
+			return nil, err
+		}
+		for _, tblName := range datastore.Tables()[1:] {
+			err = stsqlite.CreateTable(context.TODO(), store.GetDB(), tblName)
+			if err != nil {
+				return nil, err
+			}
+			err = stsqlite.CreateIndex(context.TODO(), store.GetDB(), tblName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buffer = store
+	default:
+		return nil, fmt.Errorf("unrecognized driver: '%s'", driver)
+	}
+
+	shards := []core.Shard{{Name: label, Backend: buffer}}
+	if encrypted, ok := hs.encrypted[datastore.Name]; ok {
+		// the buffer's data key is rotated along with the shards'
+		shards = encryptShards(encrypted, shards)
+	}
+	if datastore.Compression != "" {
+		shards, err = compressShards(datastore.Name, datastore.Compression, shards)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shards[0].Backend, nil
+}
+
+// startReplayers moves the cells each datastore buffered while a shard was
+// unreachable back to their shards in the background, until ctx is done.
+func (hs *HTTPAPI) startReplayers(ctx context.Context) error {
+	for _, datastore := range hs.shardConfig.Datastores {
+		if datastore.Buffer == nil {
+			continue
+		}
+
+		interval := 10 * time.Second
+		if datastore.BufferReplayInterval != "" {
+			var err error
+			interval, err = time.ParseDuration(datastore.BufferReplayInterval)
+			if err != nil {
+				return fmt.Errorf("%s: buffer_replay_interval: %w", datastore.Name, err)
+			}
+		}
+
+		store := hs.Stores[datastore.Name]
+		tables := datastore.Tables()
+		go func() {
+			for {
+				for _, tblName := range tables {
+					hs.replay(ctx, store, tblName)
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// replay drains the buffered cells of tblName a batch at a time, stopping
+// at a batch that comes up short because some shard is still unreachable.
+func (hs *HTTPAPI) replay(ctx context.Context, store *schemaless.DataStore, tblName string) {
+	for ctx.Err() == nil {
+		n, err := store.ReplayBuffer(ctx, tblName, replayBatchSize)
+		if err != nil {
+			hs.l.Error("buffer replay failed", zap.String("table", tblName), zap.Error(err))
+			return
+		}
+		if n > 0 {
+			hs.l.Info("replayed buffered cells", zap.String("table", tblName), zap.Int64("replayed", n))
+		}
+		if n < replayBatchSize {
+			return
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package httpapi
 
 import (
+	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"github.com/rbastic/go-schemaless/storage/middleware"
 
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.uber.org/zap"
 
@@ -31,7 +34,27 @@ func (hs *HTTPAPI) writeError(l *zap.Logger, w http.ResponseWriter, callerErr er
 		return
 	}
 
-	// write server error header + write error as JSON
-	w.WriteHeader(http.StatusInternalServerError)
+	// write the error's status + write error as JSON
+	w.WriteHeader(errorStatus(callerErr))
 	fmt.Fprintf(w, string(marshaledError))
 }
+
+// errorStatus maps the class of a storage error to an HTTP status.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, core.ErrInvalidTableName), errors.Is(err, ErrUnknownTable):
+		return http.StatusBadRequest
+	case errors.Is(err, middleware.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, middleware.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, core.ErrTransient), errors.Is(err, core.ErrShardUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
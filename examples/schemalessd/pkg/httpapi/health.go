@@ -0,0 +1,126 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	"github.com/rbastic/go-schemaless/storage/breaker"
+	"github.com/rbastic/go-schemaless/storage/retry"
+)
+
+// guardShards wraps every shard and replica of a datastore in a circuit
+// breaker, and keeps the breakers for health reporting.
+func (hs *HTTPAPI) guardShards(datastore *config.DatastoreConfig, shards []core.Shard) ([]core.Shard, error) {
+	cb := datastore.CircuitBreaker
+
+	var cooldown time.Duration
+	if cb.Cooldown != "" {
+		var err error
+		cooldown, err = time.ParseDuration(cb.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("%s: circuit_breaker cooldown: %w", datastore.Name, err)
+		}
+	}
+
+	retrier, err := newRetrier(datastore)
+	if err != nil {
+		return nil, err
+	}
+
+	guard := func(name string, backend core.Storage) core.Storage {
+		b := breaker.New(name, retrier(backend))
+		if cb.FailureThreshold > 0 {
+			b = b.WithThreshold(cb.FailureThreshold)
+		}
+		if cooldown > 0 {
+			b = b.WithCooldown(cooldown)
+		}
+		hs.breakers[datastore.Name] = append(hs.breakers[datastore.Name], b)
+		return b
+	}
+
+	for i := range shards {
+		shards[i].Backend = guard(shards[i].Name, shards[i].Backend)
+		for j := range shards[i].Replicas {
+			shards[i].Replicas[j] = guard(shards[i].Name+"_replica"+strconv.Itoa(j), shards[i].Replicas[j])
+		}
+	}
+	return shards, nil
+}
+
+// newRetrier returns a function that wraps a backend in the datastore's
+// retry policy.
+func newRetrier(datastore *config.DatastoreConfig) (func(core.Storage) core.Storage, error) {
+	rc := datastore.Retry
+	base, max := 50*time.Millisecond, time.Second
+	var err error
+	if rc.Backoff != "" {
+		base, err = time.ParseDuration(rc.Backoff)
+		if err != nil {
+			return nil, fmt.Errorf("%s: retry backoff: %w", datastore.Name, err)
+		}
+	}
+	if rc.MaxBackoff != "" {
+		max, err = time.ParseDuration(rc.MaxBackoff)
+		if err != nil {
+			return nil, fmt.Errorf("%s: retry max_backoff: %w", datastore.Name, err)
+		}
+	}
+
+	return func(backend core.Storage) core.Storage {
+		r := retry.New(backend).WithBackoff(base, max)
+		if rc.Attempts > 0 {
+			r = r.WithAttempts(rc.Attempts)
+		}
+		return r
+	}, nil
+}
+
+// startProbes checks the connection of every shard and replica in the
+// background, until ctx is done.
+func (hs *HTTPAPI) startProbes(ctx context.Context) error {
+	for _, datastore := range hs.shardConfig.Datastores {
+		interval := 10 * time.Second
+		if datastore.CircuitBreaker.ProbeInterval != "" {
+			var err error
+			interval, err = time.ParseDuration(datastore.CircuitBreaker.ProbeInterval)
+			if err != nil {
+				return fmt.Errorf("%s: circuit_breaker probe_interval: %w", datastore.Name, err)
+			}
+		}
+
+		for _, b := range hs.breakers[datastore.Name] {
+			b.StartProbing(ctx, interval)
+		}
+	}
+	return nil
+}
+
+// shardHealth reports the breaker of every shard and replica, and whether
+// all of them are closed.
+func (hs *HTTPAPI) shardHealth() ([]api.ShardHealth, bool) {
+	var health []api.ShardHealth
+	healthy := true
+	for _, datastore := range hs.shardConfig.Datastores {
+		for _, b := range hs.breakers[datastore.Name] {
+			h := b.Health()
+			if h.State != breaker.Closed {
+				healthy = false
+			}
+			health = append(health, api.ShardHealth{
+				Store:               datastore.Name,
+				Shard:               h.Shard,
+				State:               h.State.String(),
+				ConsecutiveFailures: h.Failures,
+				LastError:           h.LastError,
+				Since:               h.Since,
+			})
+		}
+	}
+	return health, healthy
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -14,12 +17,26 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"go.uber.org/zap"
 
 	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/compaction"
 	"github.com/rbastic/go-schemaless/core"
-
+	"github.com/rbastic/go-schemaless/expiry"
+	"github.com/rbastic/go-schemaless/metrics"
+	"github.com/rbastic/go-schemaless/registry"
+	"github.com/rbastic/go-schemaless/storage/breaker"
+	"github.com/rbastic/go-schemaless/storage/compression"
+	"github.com/rbastic/go-schemaless/storage/encryption"
+	"github.com/rbastic/go-schemaless/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	tracingMiddleware "github.com/rbastic/go-schemaless/examples/schemalessd/pkg/middleware/tracing"
 	loggerMiddleware "github.com/rbastic/go-schemaless/examples/schemalessd/pkg/middleware/zap"
 
 	"net/http"
@@ -35,6 +52,8 @@ type Specification struct {
 	Site     string
 
 	ShardConfigFile string
+	// TraceFile, if set, is where spans are written, as JSON
+	TraceFile string
 }
 
 type AsyncIndex struct {
@@ -58,6 +77,25 @@ type HTTPAPI struct {
 	shardConfig *config.ShardConfig
 
 	indexMap map[string]*AsyncIndex
+
+	// shards of each datastore, for background jobs
+	shards map[string][]core.Shard
+	// write buffer of each datastore that has one, swept along with its shards
+	buffers map[string]core.Shard
+	// tables of each datastore that requests may name
+	tables map[string]map[string]bool
+	// circuit breakers of each datastore's shards and replicas
+	breakers map[string][]*breaker.Storage
+	// encryption wrappers of each encrypted datastore's shards
+	encrypted map[string]*encryptedShards
+	// metrics of every datastore, served on /metrics
+	registry *prometheus.Registry
+	metrics  *metrics.Metrics
+	// writes spans to the trace file, if one is set
+	tracerProvider *sdktrace.TracerProvider
+	// background jobs run until jobs is done; cancel stops them
+	jobs   context.Context
+	cancel context.CancelFunc
 }
 
 // New requires a zap logger (see pkg/log, and/or
@@ -99,6 +137,18 @@ func New(l *zap.Logger) (*HTTPAPI, error) {
 
 	hs.indexMap = make(map[string]*AsyncIndex)
 
+	hs.registry = prometheus.NewRegistry()
+	hs.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	hs.metrics = metrics.New(hs.registry)
+
+	if s.TraceFile != "" {
+		hs.tracerProvider, err = tracing.NewFileProvider(s.TraceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		otel.SetTracerProvider(hs.tracerProvider)
+	}
+
 	hs.shardConfig, err = config.LoadConfig(s.ShardConfigFile)
 	if err != nil {
 		log.Fatal(err)
@@ -109,14 +159,35 @@ func New(l *zap.Logger) (*HTTPAPI, error) {
 		log.Fatal(err)
 	}
 
+	hs.jobs, hs.cancel = context.WithCancel(context.Background())
+	err = hs.startCompactors(hs.jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = hs.startSweepers(hs.jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = hs.startReplayers(hs.jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = hs.startProbes(hs.jobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := chi.NewRouter()
 	mux.NotFound(hs.notFoundHandler)
 
 	mux.Use(middleware.RequestID)
 	mux.Use(middleware.RealIP)
 	mux.Use(loggerMiddleware.Logger(hs.l))
+	mux.Use(tracingMiddleware.Tracer)
 	mux.Use(middleware.Recoverer)
 
+	mux.Handle("/metrics", promhttp.HandlerFor(hs.registry, promhttp.HandlerOpts{}))
+
 	mux.Route("/service", func(r chi.Router) {
 		render.SetContentType(render.ContentTypeJSON)
 
@@ -129,8 +200,19 @@ func New(l *zap.Logger) (*HTTPAPI, error) {
 		r.Post("/put", hs.jsonPutHandler)
 		r.Post("/get", hs.jsonGetHandler)
 		r.Post("/getLatest", hs.jsonGetLatestHandler)
+		r.Post("/history", hs.jsonHistoryHandler)
+		r.Post("/getLatestAsOf", hs.jsonGetLatestAsOfHandler)
+		r.Post("/getRowAsOf", hs.jsonGetRowAsOfHandler)
 		r.Post("/partitionRead", hs.jsonPartitionReadHandler)
 		r.Post("/findPartition", hs.jsonFindPartitionHandler)
+		r.Post("/delete", hs.jsonDeleteHandler)
+		r.Post("/purge", hs.jsonPurgeHandler)
+	})
+
+	mux.Route("/admin", func(r chi.Router) {
+		render.SetContentType(render.ContentTypeJSON)
+
+		r.Post("/rotateKey", hs.jsonRotateKeyHandler)
 	})
 
 	server := &http.Server{
@@ -162,7 +244,16 @@ func (hs *HTTPAPI) Start() error {
 // Stop attempts to shut down a webserver.  An error will be returned if the
 // shutdown is unsuccessful or the timeout exceeded.
 func (hs *HTTPAPI) Stop(ctx context.Context) error {
-	return hs.hs.Shutdown(ctx)
+	if hs.cancel != nil {
+		hs.cancel()
+	}
+	err := hs.hs.Shutdown(ctx)
+	if hs.tracerProvider != nil {
+		if terr := hs.tracerProvider.Shutdown(ctx); err == nil {
+			err = terr
+		}
+	}
+	return err
 }
 
 func (hs *HTTPAPI) notFoundHandler(w http.ResponseWriter, r *http.Request) {
@@ -174,10 +265,25 @@ func (hs *HTTPAPI) loadShards() error {
 	driver := hs.shardConfig.Driver
 
 	hs.Stores = make(map[string]*schemaless.DataStore)
+	hs.shards = make(map[string][]core.Shard)
+	hs.buffers = make(map[string]core.Shard)
+	hs.tables = make(map[string]map[string]bool)
+	hs.breakers = make(map[string][]*breaker.Storage)
+	hs.encrypted = make(map[string]*encryptedShards)
 
 	for _, datastore := range hs.shardConfig.Datastores {
 		label := datastore.Name
 
+		tables := make(map[string]bool)
+		for _, tblName := range datastore.Tables() {
+			err := core.ValidateTableName(tblName)
+			if err != nil {
+				return fmt.Errorf("datastore %s: %w", datastore.Name, err)
+			}
+			tables[tblName] = true
+		}
+		hs.tables[datastore.Name] = tables
+
 		var shards []core.Shard
 		var err error
 		switch driver {
@@ -201,24 +307,286 @@ func (hs *HTTPAPI) loadShards() error {
 
 		}
 
+		shards, err = hs.guardShards(&datastore, shards)
+		if err != nil {
+			return err
+		}
+
+		if datastore.EncryptionKeyFile != "" {
+			provider, err := encryption.NewFileKeyProvider(datastore.EncryptionKeyFile)
+			if err != nil {
+				return err
+			}
+			hs.encrypted[datastore.Name] = &encryptedShards{provider: provider, tables: datastore.Tables()}
+			shards = encryptShards(hs.encrypted[datastore.Name], shards)
+		}
+
+		// compress before encrypting, so wrap the encrypted shards
+		if datastore.Compression != "" {
+			shards, err = compressShards(datastore.Name, datastore.Compression, shards)
+			if err != nil {
+				return err
+			}
+		}
+
+		hs.shards[datastore.Name] = shards
+
+		var buffer core.Storage
+		if datastore.Buffer != nil {
+			buffer, err = hs.openBuffer(driver, label, &datastore)
+			if err != nil {
+				return err
+			}
+			hs.buffers[datastore.Name] = core.Shard{Name: label + "_buffer", Backend: buffer}
+		}
+
 		if _, ok := hs.Stores[datastore.Name]; !ok {
 			store, ok := hs.Stores[datastore.Name]
 			if !ok {
-				store = schemaless.New()
+				store = schemaless.New().WithLogger(hs.l)
+			}
+			if hs.l != nil {
+				hs.l.Info("with sources", zap.String("name", label), zap.String("datastore", datastore.Name))
+			}
+			if len(datastore.Schemas) > 0 {
+				schemas, err := loadSchemas(&datastore)
+				if err != nil {
+					return err
+				}
+				store = store.WithSchemaRegistry(schemas)
+			}
+			for _, idx := range datastore.Indexes {
+				if len(idx.ColumnDefs) == 0 {
+					continue
+				}
+				columnName := idx.ColumnDefs[0].ColumnName
+				sourceField := idx.ColumnDefs[0].IndexData.SourceField
+				store = store.WithIndex(datastore.Name, schemaless.Index{
+					Table:       datastore.Name + "_" + strings.ToLower(columnName) + "_" + sourceField,
+					Column:      columnName,
+					SourceField: sourceField,
+				})
+			}
+			if datastore.PurgeAuditLog != "" {
+				auditLog, err := os.OpenFile(datastore.PurgeAuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+				if err != nil {
+					return err
+				}
+				store = store.WithPurgeAuditor(schemaless.NewPurgeLog(auditLog))
+			}
+			store = store.WithSources(datastore.Name, shards).WithName(label, label)
+			if buffer != nil {
+				store = store.WithBuffer(datastore.Name, buffer)
 			}
-			fmt.Printf("with sources name: %s datastore.Name %s\n", label, datastore.Name)
-			hs.Stores[datastore.Name] = store.WithSources(datastore.Name, shards).WithName(label, label)
+			hs.useMiddleware(store, &datastore)
+			err = hs.metrics.Instrument(store, datastore.Name)
+			if err != nil {
+				return err
+			}
+			hs.Stores[datastore.Name] = store
 		}
 	}
 
 	return nil
 }
 
-func (hs *HTTPAPI) getStore(storeName string) (*schemaless.DataStore, error) {
+// compressShards wraps every shard so that new cells in tblName are
+// compressed with the named codec.
+func compressShards(tblName, codecName string, shards []core.Shard) ([]core.Shard, error) {
+	codec, ok := compression.Lookup(codecName)
+	if !ok {
+		return nil, fmt.Errorf("%s: unrecognized compression codec: '%s'", tblName, codecName)
+	}
+
+	for i := range shards {
+		shards[i].Backend = compression.New(shards[i].Backend).WithTableCodec(tblName, codec)
+		for j := range shards[i].Replicas {
+			shards[i].Replicas[j] = compression.New(shards[i].Replicas[j]).WithTableCodec(tblName, codec)
+		}
+	}
+	return shards, nil
+}
+
+// encryptedShards are the encryption wrappers of a datastore's shards and
+// buffer, kept for key rotation. Replicas are left out: they find a rotated
+// data key in the replicated key ring the first time they read a cell sealed
+// with it.
+type encryptedShards struct {
+	provider *encryption.FileKeyProvider
+	// the cell table and index tables, whose bodies copy indexed fields
+	tables []string
+	names  []string
+	shards []*encryption.Storage
+}
+
+// encryptShards wraps every shard so that new cells in each of encrypted's
+// tables are encrypted under master keys from its provider, and adds the
+// wrappers to encrypted.
+func encryptShards(encrypted *encryptedShards, shards []core.Shard) []core.Shard {
+	for i := range shards {
+		primary := encryptStorage(shards[i].Backend, encrypted)
+		encrypted.names = append(encrypted.names, shards[i].Name)
+		encrypted.shards = append(encrypted.shards, primary)
+
+		shards[i].Backend = primary
+		for j := range shards[i].Replicas {
+			shards[i].Replicas[j] = encryptStorage(shards[i].Replicas[j], encrypted)
+		}
+	}
+	return shards
+}
+
+func encryptStorage(backend core.Storage, encrypted *encryptedShards) *encryption.Storage {
+	s := encryption.New(backend, encrypted.provider)
+	for _, tblName := range encrypted.tables {
+		s.WithTable(tblName)
+	}
+	return s
+}
+
+// compactionThrottle is the pause between batches of a compaction pass.
+const compactionThrottle = 100 * time.Millisecond
+
+// startCompactors enforces each datastore's retention policies on its
+// writable shards in the background, until ctx is done.
+func (hs *HTTPAPI) startCompactors(ctx context.Context) error {
+	for _, datastore := range hs.shardConfig.Datastores {
+		if len(datastore.Retention) == 0 {
+			continue
+		}
+
+		var policies []compaction.Policy
+		for _, rp := range datastore.Retention {
+			p := compaction.Policy{Table: rp.Table, Column: rp.Column, KeepLast: rp.KeepLast}
+			if p.Table == "" {
+				p.Table = datastore.Name
+			}
+			if rp.MaxAge != "" {
+				var err error
+				p.MaxAge, err = time.ParseDuration(rp.MaxAge)
+				if err != nil {
+					return fmt.Errorf("%s: retention of %s: %w", datastore.Name, rp.Column, err)
+				}
+			}
+			policies = append(policies, p)
+		}
+
+		interval := time.Hour
+		if datastore.CompactionInterval != "" {
+			var err error
+			interval, err = time.ParseDuration(datastore.CompactionInterval)
+			if err != nil {
+				return fmt.Errorf("%s: compaction_interval: %w", datastore.Name, err)
+			}
+		}
+
+		compactor, err := compaction.New(policies...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", datastore.Name, err)
+		}
+		results := compactor.WithThrottle(compactionThrottle).Start(ctx, writableShards(&datastore, hs.shards[datastore.Name]), interval)
+
+		go func() {
+			for r := range results {
+				if r.Err != nil {
+					hs.l.Error("compaction failed", zap.String("shard", r.Shard), zap.String("table", r.Table), zap.String("column", r.Column), zap.Error(r.Err))
+					continue
+				}
+				hs.l.Info("compacted", zap.String("shard", r.Shard), zap.String("table", r.Table), zap.String("column", r.Column), zap.Int64("rows", r.Rows), zap.Int64("reclaimed", r.Reclaimed))
+			}
+		}()
+	}
+	return nil
+}
+
+// sweepThrottle is the pause between batches of an expiry sweep.
+const sweepThrottle = 100 * time.Millisecond
+
+// startSweepers deletes expired cells from the tables of each datastore's
+// writable shards and write buffer in the background, until ctx is done.
+// Replay skips expired buffered cells, so the sweep is what removes them.
+func (hs *HTTPAPI) startSweepers(ctx context.Context) error {
+	for _, datastore := range hs.shardConfig.Datastores {
+		interval := time.Minute
+		if datastore.ExpirySweepInterval != "" {
+			var err error
+			interval, err = time.ParseDuration(datastore.ExpirySweepInterval)
+			if err != nil {
+				return fmt.Errorf("%s: expiry_sweep_interval: %w", datastore.Name, err)
+			}
+		}
+
+		shards := writableShards(&datastore, hs.shards[datastore.Name])
+		if buffer, ok := hs.buffers[datastore.Name]; ok {
+			shards = append(shards, buffer)
+		}
+		results := expiry.New(datastore.Tables()...).WithThrottle(sweepThrottle).Start(ctx, shards, interval)
+
+		go func() {
+			for r := range results {
+				if r.Err != nil {
+					hs.l.Error("expiry sweep failed", zap.String("shard", r.Shard), zap.String("table", r.Table), zap.Error(r.Err))
+					continue
+				}
+				if r.Removed > 0 {
+					hs.l.Info("swept expired cells", zap.String("shard", r.Shard), zap.String("table", r.Table), zap.Int64("removed", r.Removed))
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// loadSchemas registers every schema version listed for a datastore, in
+// order, so that incompatible changes are caught at startup.
+func loadSchemas(datastore *config.DatastoreConfig) (*registry.Registry, error) {
+	schemas := registry.New()
+	for _, cs := range datastore.Schemas {
+		tblName := cs.Table
+		if tblName == "" {
+			tblName = datastore.Name
+		}
+		for _, file := range cs.Versions {
+			source, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			_, err = schemas.Register(tblName, cs.Column, string(source))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return schemas, nil
+}
+
+// LoadStores opens every datastore described by shardConfig without
+// starting a webserver, for tools that want to talk to the shards directly.
+func LoadStores(shardConfig *config.ShardConfig) (map[string]*schemaless.DataStore, error) {
+	hs := HTTPAPI{
+		shardConfig: shardConfig,
+		indexMap:    make(map[string]*AsyncIndex),
+		metrics:     metrics.New(prometheus.NewRegistry()),
+	}
+
+	err := hs.loadShards()
+	if err != nil {
+		return nil, err
+	}
+
+	return hs.Stores, nil
+}
+
+// getStore returns the named store, if tblName is one of the tables
+// configured for it: its cell table or an index table.
+func (hs *HTTPAPI) getStore(storeName, tblName string) (*schemaless.DataStore, error) {
 	store, ok := hs.Stores[storeName]
 	if !ok {
 		return nil, errors.New("store not found")
 	}
+	if !hs.tables[storeName][tblName] {
+		return nil, ErrUnknownTable
+	}
 
 	return store, nil
 }
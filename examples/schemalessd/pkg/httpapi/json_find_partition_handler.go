@@ -37,7 +37,7 @@ func (hs *HTTPAPI) jsonFindPartitionHandler(w http.ResponseWriter, r *http.Reque
 		resp.Error = ErrMissingStore.Error()
 	}
 
-	store, err := hs.getStore(request.Store)
+	store, err := hs.getStore(request.Store, request.Table)
 	if err != nil {
 		resp.Success = false
 		resp.Error = err.Error()
@@ -1,7 +1,6 @@
 package httpapi
 
 import (
-	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -42,14 +41,14 @@ func (hs *HTTPAPI) jsonGetHandler(w http.ResponseWriter, r *http.Request) {
 		resp.Error = ErrMissingStore.Error()
 	}
 
-	store, err := hs.getStore(request.Store)
+	store, err := hs.getStore(request.Store, request.Table)
 	if err != nil {
 		resp.Success = false
 		resp.Error = err.Error()
 	}
 
 	if resp.Error == "" {
-		cell, found, err = store.Get(context.TODO(), request.Table, request.RowKey, request.ColumnKey, request.RefKey)
+		cell, found, err = store.Get(r.Context(), request.Table, request.RowKey, request.ColumnKey, request.RefKey)
 		if err != nil {
 			resp.Success = false
 			resp.Error = err.Error()
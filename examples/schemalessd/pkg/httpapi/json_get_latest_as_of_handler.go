@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+func (hs *HTTPAPI) jsonGetLatestAsOfHandler(w http.ResponseWriter, r *http.Request) {
+
+	var request api.GetLatestAsOfRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+	if err := r.Body.Close(); err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	var resp api.GetLatestAsOfResponse
+	resp.Success = true
+
+	var cell models.Cell
+	var found bool
+
+	if request.Store == "" {
+		resp.Error = ErrMissingStore.Error()
+	}
+
+	store, err := hs.getStore(request.Store, request.Table)
+	if err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+	}
+
+	if resp.Error == "" {
+		cell, found, err = store.GetLatestAsOf(r.Context(), request.Table, request.RowKey, request.ColumnKey, time.Unix(0, request.AsOf))
+		if err != nil {
+			resp.Success = false
+			resp.Error = err.Error()
+		}
+
+		resp.Cell = cell
+		resp.Found = found
+	}
+
+	respText, err := json.Marshal(resp)
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte(respText))
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+}
@@ -1,14 +1,15 @@
 package httpapi
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/tidwall/sjson"
 
+	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
 	"github.com/rbastic/go-schemaless/models"
 )
@@ -41,7 +42,7 @@ func (hs *HTTPAPI) jsonGetLatestHandler(w http.ResponseWriter, r *http.Request)
 		resp.Error = ErrMissingStore.Error()
 	}
 
-	store, err := hs.getStore(request.Store)
+	store, err := hs.getStore(request.Store, request.Table)
 	if err != nil {
 		resp.Success = false
 		resp.Error = err.Error()
@@ -50,9 +51,18 @@ func (hs *HTTPAPI) jsonGetLatestHandler(w http.ResponseWriter, r *http.Request)
 	var cell models.Cell
 	var found bool
 
+	ctx := r.Context()
+	switch request.Consistency {
+	case "", "strong":
+	case "eventual":
+		ctx = core.WithConsistency(ctx, core.Eventual)
+	default:
+		resp.Error = fmt.Sprintf("unrecognized consistency: '%s'", request.Consistency)
+	}
+
 	if resp.Error == "" {
 
-		cell, found, err = store.GetLatest(context.TODO(), request.Table, request.RowKey, request.ColumnKey)
+		cell, found, err = store.GetLatest(ctx, request.Table, request.RowKey, request.ColumnKey)
 		if err != nil {
 			resp.Success = false
 			resp.Error = err.Error()
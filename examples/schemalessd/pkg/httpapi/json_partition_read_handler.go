@@ -1,7 +1,6 @@
 package httpapi
 
 import (
-	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -46,14 +45,14 @@ func (hs *HTTPAPI) jsonPartitionReadHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	store, err := hs.getStore(request.Store)
+	store, err := hs.getStore(request.Store, request.Table)
 	if err != nil {
 		resp.Success = false
 		resp.Error = err.Error()
 	} else {
 		resp.Success = true
 
-		cells, found, err = store.PartitionRead(context.TODO(), request.Table, request.PartitionNumber, request.Location, intValue, request.Limit)
+		cells, found, err = store.PartitionRead(r.Context(), request.Table, request.PartitionNumber, request.Location, intValue, request.Limit)
 		if err != nil {
 			resp.Success = false
 			resp.Error = err.Error()
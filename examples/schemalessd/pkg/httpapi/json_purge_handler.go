@@ -0,0 +1,73 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"go.uber.org/zap"
+)
+
+func (hs *HTTPAPI) jsonPurgeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var request api.PurgeRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+	if err := r.Body.Close(); err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(err); err != nil {
+			hs.writeError(hs.l, w, err)
+			return
+		}
+	}
+
+	var resp api.PurgeResponse
+
+	if request.Store == "" {
+		resp.Error = ErrMissingStore.Error()
+	}
+
+	store, err := hs.getStore(request.Store, request.Table)
+	if err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+	}
+
+	if resp.Error == "" {
+		// the record is returned even on failure, to show what was purged
+		resp.Record, err = store.Purge(r.Context(), request.Table, request.RowKey, request.Reason)
+		if err != nil {
+			resp.Success = false
+			resp.Error = err.Error()
+			hs.l.Error("purge failed", zap.String("table", request.Table), zap.String("rowKey", request.RowKey), zap.Error(err))
+		} else {
+			resp.Success = true
+		}
+	}
+
+	respText, err := json.Marshal(resp)
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(respText)
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+}
@@ -13,13 +13,20 @@ import (
 	"strings"
 
 	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"github.com/rbastic/go-schemaless/registry"
+	"github.com/rbastic/go-schemaless/tracing"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 var ErrMissingStore = errors.New("store not specified in request")
 
+// ErrUnknownTable is returned for a request naming a table that isn't
+// configured for its store.
+var ErrUnknownTable = errors.New("table not found in store")
+
 func (hs *HTTPAPI) jsonPutHandler(w http.ResponseWriter, r *http.Request) {
 
 	var request api.PutRequest
@@ -44,22 +51,39 @@ func (hs *HTTPAPI) jsonPutHandler(w http.ResponseWriter, r *http.Request) {
 
 	var resp api.PutResponse
 	resp.Success = true
+	status := http.StatusOK
 
 	if request.Store == "" {
 		resp.Error = ErrMissingStore.Error()
 	}
 
-	store, err := hs.getStore(request.Store)
+	store, err := hs.getStore(request.Store, request.Table)
 	if err != nil {
 		resp.Success = false
 		resp.Error = err.Error()
 	}
 
 	if resp.Error == "" {
-		err = store.Put(context.TODO(), request.Table, request.RowKey, request.ColumnKey, request.RefKey, request.Body)
+		if request.ExpiresAt != 0 {
+			err = store.PutWithExpiry(r.Context(), request.Table, request.RowKey, request.ColumnKey, request.RefKey, request.Body, time.Unix(0, request.ExpiresAt))
+		} else {
+			err = store.Put(r.Context(), request.Table, request.RowKey, request.ColumnKey, request.RefKey, request.Body)
+		}
 		if err != nil {
 			resp.Success = false
 			resp.Error = err.Error()
+
+			var verr *registry.ValidationError
+			if errors.As(err, &verr) {
+				status = http.StatusUnprocessableEntity
+				for _, p := range verr.Problems {
+					resp.ValidationErrors = append(resp.ValidationErrors, api.ValidationError{
+						SchemaVersion: verr.Version,
+						Path:          p.Path,
+						Message:       p.Message,
+					})
+				}
+			}
 		}
 
 		asyncIndex, err := hs.getIndexIfExists(request.Store, request.Table, request.ColumnKey)
@@ -67,19 +91,27 @@ func (hs *HTTPAPI) jsonPutHandler(w http.ResponseWriter, r *http.Request) {
 			hs.l.Error("error getting index", zap.Error(err))
 			return
 		}
-		if asyncIndex != nil {
+		// don't index a cell that wasn't written
+		if asyncIndex != nil && resp.Success {
 			indexTableName := asyncIndex.IndexTableName
 			jsonIndexField := asyncIndex.SourceField
 
+			hs.metrics.IndexWriteStarted(indexTableName)
 			go func() {
+				defer hs.metrics.IndexWriteDone(indexTableName)
+
 				rowKey := gjson.Get(string(request.Body), jsonIndexField).String() // jsonIndexValue
 				if rowKey == "" {
 					hs.l.Error(fmt.Sprintf("error with async index write: %s missing", jsonIndexField))
 					return
 				}
 
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+				// the index write outlives the request, but stays in its trace
+				ctx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(r.Context()))
+				ctx, cancel := context.WithTimeout(ctx, time.Second*15)
 				defer cancel()
+				ctx, span := tracing.Start(ctx, "async index write", tracing.TableKey.String(indexTableName))
+				defer span.End()
 
 				// copy all index fields to body
 				var indexBody string
@@ -101,9 +133,16 @@ func (hs *HTTPAPI) jsonPutHandler(w http.ResponseWriter, r *http.Request) {
 				}
 
 
-				err := store.Put(ctx, indexTableName, rowKey, request.ColumnKey, request.RefKey, indexBody)
+				// an index entry expires with the cell it points at
+				var err error
+				if request.ExpiresAt != 0 {
+					err = store.PutWithExpiry(ctx, indexTableName, rowKey, request.ColumnKey, request.RefKey, indexBody, time.Unix(0, request.ExpiresAt))
+				} else {
+					err = store.Put(ctx, indexTableName, rowKey, request.ColumnKey, request.RefKey, indexBody)
+				}
 				if err != nil {
 					hs.l.Error("error with async index write: Put()", zap.Error(err))
+					span.RecordError(err)
 					return
 				}
 
@@ -117,7 +156,7 @@ func (hs *HTTPAPI) jsonPutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 
 	_, err = w.Write([]byte(respText))
 	if err != nil {
@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/api"
+	"github.com/rbastic/go-schemaless/storage/encryption"
+	"go.uber.org/zap"
+)
+
+// reencryptBatchSize is the number of cells re-encryption reads at a time.
+const reencryptBatchSize = 1000
+
+// ErrNotEncrypted is returned when rotating the key of a store that has no
+// encryption_key_file.
+var ErrNotEncrypted = errors.New("store is not encrypted")
+
+func (hs *HTTPAPI) jsonRotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+
+	var request api.RotateKeyRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+	if err := r.Body.Close(); err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := json.NewEncoder(w).Encode(err); err != nil {
+			hs.writeError(hs.l, w, err)
+			return
+		}
+	}
+
+	var resp api.RotateKeyResponse
+
+	if request.Store == "" {
+		resp.Error = ErrMissingStore.Error()
+	}
+
+	encrypted, ok := hs.encrypted[request.Store]
+	if resp.Error == "" && !ok {
+		resp.Error = ErrNotEncrypted.Error()
+	}
+
+	if resp.Error == "" {
+		// rotation writes to every shard, so none may be read-only
+		err = hs.checkWritable(request.Store, encrypted.names)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+
+	if resp.Error == "" {
+		// pick up a master key added to the key file since startup
+		err = encrypted.provider.Reload()
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	}
+
+	if resp.Error == "" {
+		resp.Shards, err = hs.rotate(r.Context(), encrypted)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Success = true
+		}
+	}
+
+	respText, err := json.Marshal(resp)
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(respText)
+	if err != nil {
+		hs.writeError(hs.l, w, err)
+		return
+	}
+}
+
+// rotate rotates the data key of each of encrypted's tables on every shard,
+// and starts re-encrypting its cells under the new key.
+func (hs *HTTPAPI) rotate(ctx context.Context, encrypted *encryptedShards) ([]api.RotatedShard, error) {
+	var rotated []api.RotatedShard
+	for _, tblName := range encrypted.tables {
+		for i, shard := range encrypted.shards {
+			id, err := shard.Rotate(ctx, tblName)
+			if err != nil {
+				hs.l.Error("data key rotation failed", zap.String("table", tblName), zap.String("shard", encrypted.names[i]), zap.Error(err))
+				return rotated, err
+			}
+			rotated = append(rotated, api.RotatedShard{Shard: encrypted.names[i], Table: tblName, KeyID: id})
+			hs.reencrypt(tblName, encrypted.names[i], shard)
+		}
+	}
+	return rotated, nil
+}
+
+// reencrypt moves every cell of tblName on a shard to its current data key
+// in the background, logging the outcome.
+func (hs *HTTPAPI) reencrypt(tblName, shardName string, shard *encryption.Storage) {
+	done := shard.StartReencrypt(hs.jobs, tblName, reencryptBatchSize)
+	go func() {
+		res := <-done
+		if res.Err != nil {
+			hs.l.Error("re-encryption failed", zap.String("shard", shardName), zap.String("table", tblName), zap.Int("rewritten", res.Rewritten), zap.Error(res.Err))
+			return
+		}
+		hs.l.Info("re-encrypted", zap.String("shard", shardName), zap.String("table", tblName), zap.Int("rewritten", res.Rewritten))
+	}()
+}
@@ -6,9 +6,14 @@ import (
 	"net/http"
 )
 
+// jsonServiceStatusHandler reports the health of every shard, answering
+// 503 Service Unavailable while any shard's circuit breaker is not closed.
 func (hs *HTTPAPI) jsonServiceStatusHandler(w http.ResponseWriter, r *http.Request) {
 	var resp api.StatusResponse
-	resp.Success = true
+	resp.Shards, resp.Success = hs.shardHealth()
+	if !resp.Success {
+		resp.Error = "one or more shards are unavailable"
+	}
 
 	respText, err := json.Marshal(resp)
 	if err != nil {
@@ -16,6 +21,9 @@ func (hs *HTTPAPI) jsonServiceStatusHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !resp.Success {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	_, err = w.Write([]byte(respText))
 	if err != nil {
 		hs.writeError(hs.l, w, err)
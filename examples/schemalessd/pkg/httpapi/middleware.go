@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"fmt"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	"github.com/rbastic/go-schemaless/storage/middleware"
+	"go.uber.org/zap"
+)
+
+// useMiddleware wraps the shards of a datastore in the middlewares its
+// config asks for. Quotas and read-only mode are checked before a call is
+// logged, so rejected calls are logged as failures.
+func (hs *HTTPAPI) useMiddleware(store *schemaless.DataStore, datastore *config.DatastoreConfig) {
+	mc := datastore.Middleware
+
+	for _, shard := range mc.ReadOnlyShards {
+		store.UseShard(datastore.Name, shard, middleware.ReadOnly())
+	}
+
+	var mws []core.Middleware
+	if mc.ReadOnly {
+		mws = append(mws, middleware.ReadOnly())
+	}
+	if len(mc.Quotas) > 0 {
+		limits := make(map[string]middleware.Limit, len(mc.Quotas))
+		for tblName, q := range mc.Quotas {
+			limits[tblName] = middleware.Limit{Reads: q.ReadsPerSecond, Writes: q.WritesPerSecond, Burst: q.Burst}
+		}
+		mws = append(mws, middleware.NewQuota(limits).Middleware())
+	}
+	if len(mws) > 0 {
+		store.Use(datastore.Name, mws...)
+	}
+
+	if mc.LogCalls && hs.l != nil {
+		store.Use(datastore.Name, middleware.Logging(hs.l.With(zap.String("datastore", datastore.Name))))
+	}
+}
+
+// readOnly reports whether datastore's middleware config makes the named
+// shard read-only.
+func readOnly(datastore *config.DatastoreConfig, shard string) bool {
+	if datastore.Middleware.ReadOnly {
+		return true
+	}
+	for _, name := range datastore.Middleware.ReadOnlyShards {
+		if name == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// writableShards returns the shards of datastore that are not read-only.
+//
+// Compaction, expiry sweeps and re-encryption work on the shards beneath
+// the middleware, so that they are neither logged call by call nor counted
+// against quotas; they have throttles of their own. They are only given
+// writable shards: a read-only shard is left as it is until the config
+// makes it writable again.
+func writableShards(datastore *config.DatastoreConfig, shards []core.Shard) []core.Shard {
+	var writable []core.Shard
+	for _, shard := range shards {
+		if !readOnly(datastore, shard.Name) {
+			writable = append(writable, shard)
+		}
+	}
+	return writable
+}
+
+// checkWritable returns an error wrapping middleware.ErrReadOnly if any of
+// the named shards of the datastore storeName is read-only.
+func (hs *HTTPAPI) checkWritable(storeName string, shards []string) error {
+	for i := range hs.shardConfig.Datastores {
+		datastore := &hs.shardConfig.Datastores[i]
+		if datastore.Name != storeName {
+			continue
+		}
+		for _, shard := range shards {
+			if readOnly(datastore, shard) {
+				return fmt.Errorf("shard %s: %w", shard, middleware.ErrReadOnly)
+			}
+		}
+	}
+	return nil
+}
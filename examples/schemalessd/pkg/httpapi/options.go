@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	"github.com/rbastic/go-schemaless/logging"
+)
+
+// backendOptions are the settings of a datastore that every one of its
+// backends is opened with.
+type backendOptions struct {
+	// tblName is the datastore's cell table, for labelling metrics
+	tblName  string
+	slow     logging.Thresholds
+	timeouts core.Timeouts
+}
+
+// newBackendOptions parses the slow query thresholds and timeouts of a
+// datastore's shards.
+func newBackendOptions(datastore *config.DatastoreConfig) (backendOptions, error) {
+	opts := backendOptions{tblName: datastore.Name}
+	for _, d := range []struct {
+		name  string
+		value string
+		d     *time.Duration
+	}{
+		{"slow_queries info", datastore.SlowQueries.Info, &opts.slow.Info},
+		{"slow_queries warn", datastore.SlowQueries.Warn, &opts.slow.Warn},
+		{"slow_queries error", datastore.SlowQueries.Error, &opts.slow.Error},
+		{"timeouts read", datastore.Timeouts.Read, &opts.timeouts.Read},
+		{"timeouts scan", datastore.Timeouts.Scan, &opts.timeouts.Scan},
+		{"timeouts write", datastore.Timeouts.Write, &opts.timeouts.Write},
+		{"timeouts sweep", datastore.Timeouts.Sweep, &opts.timeouts.Sweep},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return opts, fmt.Errorf("%s: %s: %w", datastore.Name, d.name, err)
+		}
+		*d.d = v
+	}
+	return opts, nil
+}
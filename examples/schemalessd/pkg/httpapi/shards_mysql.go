@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"fmt"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -20,30 +21,27 @@ func (hs *HTTPAPI) getMysqlShards(prefix string, datastore *config.DatastoreConf
 	var shards []core.Shard
 	nShards := len(datastore.Shards)
 
+	opts, err := newBackendOptions(datastore)
+	if err != nil {
+		return nil, err
+	}
+
 	// Iterate every shard (represented as a 'store')
 	for i := 0; i < nShards; i++ {
 		label := prefix + strconv.Itoa(i)
 
-		host := datastore.Shards[i].Host
-		port := datastore.Shards[i].Port
-		user := datastore.Shards[i].Username
-		pass := datastore.Shards[i].Password
-		dbname := datastore.Shards[i].Database
-
-		store := stmysql.New().
-			WithHost(host).
-			WithPort(port).
-			WithUser(user).
-			WithPass(pass).
-			WithDatabase(dbname)
-
-		err := store.WithZap()
+		store, err := hs.openMysqlShard(label, datastore.Shards[i], opts)
 		if err != nil {
 			return nil, err
 		}
-		err = store.Open()
-		if err != nil {
-			return nil, err
+
+		var replicas []core.Storage
+		for j, replicaConfig := range datastore.Shards[i].Replicas {
+			replica, err := hs.openMysqlShard(label+"_replica"+strconv.Itoa(j), replicaConfig, opts)
+			if err != nil {
+				return nil, err
+			}
+			replicas = append(replicas, replica)
 		}
 
 		// Create any necessary secondary index tables on each individual shard
@@ -71,10 +69,40 @@ func (hs *HTTPAPI) getMysqlShards(prefix string, datastore *config.DatastoreConf
 				})
 			}
 		}
-		shards = append(shards, core.Shard{Name: label, Backend: store})
+		shards = append(shards, core.Shard{Name: label, Backend: store, Replicas: replicas})
 	}
 
 	return shards, nil
 }
 
+// openMysqlShard opens the named shard or replica, and registers the
+// metrics of its connection pool.
+func (hs *HTTPAPI) openMysqlShard(name string, shard config.Shard, opts backendOptions) (*stmysql.Storage, error) {
+	pool, err := shard.CorePool()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
 
+	store := stmysql.New().
+		WithHost(shard.Host).
+		WithPort(shard.Port).
+		WithUser(shard.Username).
+		WithPass(shard.Password).
+		WithDatabase(shard.Database).
+		WithPool(pool).
+		WithTLS(shard.CoreTLS()).
+		WithParams(shard.Params).
+		WithLogger(hs.l).
+		WithSlowQueries(opts.slow).
+		WithTimeouts(opts.timeouts)
+
+	err = store.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	err = hs.metrics.InstrumentPool(opts.tblName, name, store.Stats)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
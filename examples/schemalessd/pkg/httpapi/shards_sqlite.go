@@ -2,6 +2,7 @@ package httpapi
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -21,14 +22,28 @@ func (hs *HTTPAPI) getSqliteShards(prefix string, datastore *config.DatastoreCon
 	var shards []core.Shard
 	nShards := len(datastore.Shards)
 
+	opts, err := newBackendOptions(datastore)
+	if err != nil {
+		return nil, err
+	}
+
 	// Iterate every shard (represented as a 'store')
 	for i := 0; i < nShards; i++ {
 		label := prefix + strconv.Itoa(i)
 
+		if len(datastore.Shards[i].Replicas) > 0 {
+			return nil, fmt.Errorf("%s: sqlite shards cannot have read replicas", label)
+		}
+
 		store, err := stsqlite.New(prefix, label)
 		if err != nil {
 			return nil, err
 		}
+		store.WithLogger(hs.l).WithSlowQueries(opts.slow).WithTimeouts(opts.timeouts)
+		err = hs.metrics.InstrumentPool(opts.tblName, label, store.Stats)
+		if err != nil {
+			return nil, err
+		}
 
 		// Create any necessary secondary index tables on each individual shard
 		for j := 0; j < len(datastore.Indexes); j++ {
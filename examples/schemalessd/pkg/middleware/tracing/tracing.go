@@ -0,0 +1,28 @@
+// Package tracing is a middleware that runs each request in a server span,
+// continuing the trace of the client that sent it.
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/rbastic/go-schemaless/tracing"
+)
+
+// Tracer is a middleware that starts a span for each request, as a child of
+// any trace in its headers, and records the response status on it.
+func Tracer(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		r, span := tracing.StartHTTPServer(r)
+		defer func() {
+			tracing.SetStatusCode(span, ww.Status())
+			span.End()
+		}()
+
+		next.ServeHTTP(ww, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,5 @@
+// Package trips is a typed client for the trips table of schemalessd,
+// generated by tools/schemaless-gen from tables.json.
+package trips
+
+//go:generate go run github.com/rbastic/go-schemaless/tools/schemaless-gen -config tables.json -package trips -out trips_gen.go
@@ -0,0 +1,137 @@
+// Package expiry actively deletes expired cells. Backends already hide a
+// cell once its expiry has passed; a Sweeper reclaims the space, shard by
+// shard and a batch at a time.
+package expiry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// Result reports one sweep of one table on one shard.
+type Result struct {
+	Shard   string
+	Table   string
+	Removed int64 // expired cells deleted
+	Err     error
+}
+
+// Sweeper deletes the expired cells of a set of tables. The shards'
+// backends must implement core.Expirer.
+type Sweeper struct {
+	tables    []string
+	batchSize int
+	throttle  time.Duration
+	now       func() time.Time
+}
+
+// New returns a Sweeper for tables that deletes 1000 cells per batch and
+// does not pause between batches.
+func New(tables ...string) *Sweeper {
+	return &Sweeper{tables: tables, batchSize: 1000, now: time.Now}
+}
+
+// WithBatchSize sets how many cells are deleted from a shard at a time.
+func (s *Sweeper) WithBatchSize(n int) *Sweeper {
+	s.batchSize = n
+	return s
+}
+
+// WithThrottle pauses for d after each batch, to limit the load sweeping
+// puts on a shard.
+func (s *Sweeper) WithThrottle(d time.Duration) *Sweeper {
+	s.throttle = d
+	return s
+}
+
+// WithClock sets the clock that decides which cells have expired.
+func (s *Sweeper) WithClock(now func() time.Time) *Sweeper {
+	s.now = now
+	return s
+}
+
+// SweepShard deletes the expired cells of every table on shard, and returns
+// how many each lost. It stops at the first error, which is also set on the
+// result of the table that failed.
+func (s *Sweeper) SweepShard(ctx context.Context, shard core.Shard) ([]Result, error) {
+	expirer, ok := shard.Backend.(core.Expirer)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: %w: %T", shard.Name, core.ErrExpiryNotSupported, shard.Backend)
+	}
+
+	var results []Result
+	for _, tblName := range s.tables {
+		res := Result{Shard: shard.Name, Table: tblName}
+		res.Err = s.sweep(ctx, expirer, tblName, &res)
+		results = append(results, res)
+		if res.Err != nil {
+			return results, res.Err
+		}
+	}
+	return results, nil
+}
+
+// sweep deletes batches of expired cells from tblName until a batch comes
+// up short.
+func (s *Sweeper) sweep(ctx context.Context, expirer core.Expirer, tblName string, res *Result) error {
+	now := s.now()
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		n, err := expirer.SweepExpired(ctx, tblName, now, s.batchSize)
+		if err != nil {
+			return fmt.Errorf("sweeping %s: %w", tblName, err)
+		}
+		res.Removed += n
+		if n < int64(s.batchSize) {
+			return nil
+		}
+
+		if s.throttle > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.throttle):
+			}
+		}
+	}
+}
+
+// Start sweeps every shard, then again every interval, until ctx is done.
+// The result of each table on each shard is sent on the returned channel,
+// which is closed when sweeping stops; the caller must keep receiving from
+// it.
+func (s *Sweeper) Start(ctx context.Context, shards []core.Shard, interval time.Duration) <-chan Result {
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+		for {
+			for _, shard := range shards {
+				rs, err := s.SweepShard(ctx, shard)
+				if len(rs) == 0 && err != nil {
+					rs = []Result{{Shard: shard.Name, Err: err}}
+				}
+				for _, r := range rs {
+					select {
+					case results <- r:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return results
+}
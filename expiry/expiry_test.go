@@ -0,0 +1,74 @@
+package expiry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+func TestSweepShard(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+	backend = backend.WithClock(clock)
+
+	ctx := context.TODO()
+	for _, rowKey := range []string{"session1", "session2", "session3"} {
+		err := backend.PutWithExpiry(ctx, "cell", rowKey, "TOKEN", 1, `{}`, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := backend.Put(ctx, "cell", "session1", "BASE", 1, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cell, found, err := backend.GetLatest(ctx, "cell", "session1", "TOKEN")
+	if err != nil || !found || cell.ExpiresAt != now.Add(time.Minute).UnixNano() {
+		t.Fatalf("expected an unexpired cell, got %v found=%v err=%v", cell, found, err)
+	}
+
+	// expired cells are hidden before they are swept
+	now = now.Add(2 * time.Minute)
+
+	_, found, err = backend.Get(ctx, "cell", "session1", "TOKEN", 1)
+	if err != nil || found {
+		t.Errorf("expected Get to hide the expired cell, found=%v err=%v", found, err)
+	}
+	_, found, err = backend.GetLatest(ctx, "cell", "session1", "TOKEN")
+	if err != nil || found {
+		t.Errorf("expected GetLatest to hide the expired cell, found=%v err=%v", found, err)
+	}
+	cells, _, err := backend.PartitionRead(ctx, "cell", 0, "added_at", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 1 || cells[0].ColumnName != "BASE" {
+		t.Errorf("expected PartitionRead to return only BASE, got %v", cells)
+	}
+
+	sweeper := New("cell").WithBatchSize(2).WithClock(clock)
+	results, err := sweeper.SweepShard(ctx, core.Shard{Name: "shard0", Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Removed != 3 {
+		t.Fatalf("expected 3 cells removed, got %+v", results)
+	}
+
+	// the cells are gone even to a clock that hasn't reached their expiry
+	now = now.Add(-2 * time.Minute)
+	_, found, err = backend.GetLatest(ctx, "cell", "session1", "TOKEN")
+	if err != nil || found {
+		t.Errorf("expected the swept cell to be deleted, found=%v err=%v", found, err)
+	}
+	_, found, err = backend.GetLatest(ctx, "cell", "session1", "BASE")
+	if err != nil || !found {
+		t.Errorf("expected BASE to be untouched, found=%v err=%v", found, err)
+	}
+}
@@ -1,31 +1,54 @@
 module github.com/rbastic/go-schemaless
 
-go 1.16
+go 1.21
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/chzyer/readline v1.5.1
 	github.com/corpix/uarand v0.1.1 // indirect
 	github.com/dgryski/go-jump v0.0.0-20170409065014-e1f439676b57 // indirect
 	github.com/dgryski/go-metro v0.0.0-20200812162917-85c65e2d0165
 	github.com/dgryski/go-shardedkv v0.0.0-20201105204302-dca5b6c7ae7e
 	github.com/go-chi/chi v1.5.4 // indirect
 	github.com/go-chi/render v1.0.1 // indirect
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gofrs/uuid v4.0.0+incompatible
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/google/uuid v1.2.0
 	github.com/icrowley/fake v0.0.0-20180203215853-4178557ae428
 	github.com/jordan-wright/unindexed v0.0.0-20181209214434-78fa79113c0f // indirect
 	github.com/kelseyhightower/envconfig v1.4.0 // indirect
+	github.com/klauspost/compress v1.18.0
 	github.com/lib/pq v1.10.1
 	github.com/mattn/go-sqlite3 v1.14.7
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/oklog/run v1.1.0 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
+	github.com/pierrec/lz4/v4 v4.1.18
+	github.com/prometheus/client_golang v1.14.0
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
 	github.com/rbastic/go-dao v0.0.0 // indirect
 	github.com/rbastic/go-entity v0.0.0 // indirect
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/tidwall/gjson v1.7.5
 	github.com/tidwall/sjson v1.1.6
 	github.com/tus/tusd v1.6.0 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/xiam/dig v0.0.0-20191116195832-893b5fb5093b // indirect
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/sys v0.10.0 // indirect
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
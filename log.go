@@ -0,0 +1,27 @@
+package schemaless
+
+import (
+	"log/slog"
+
+	"github.com/rbastic/go-schemaless/logging"
+	"go.uber.org/zap"
+)
+
+// WithLogger sets the logger of every table's KVStore (see
+// core.KVStore.WithLogger), including those added later, and of purges. The
+// DataStore logs nothing without one. Backends take loggers of their own.
+func (ds *DataStore) WithLogger(l *zap.Logger) *DataStore {
+	if l == nil {
+		l = zap.NewNop()
+	}
+	ds.log = l
+	for _, source := range ds.sources {
+		source.WithLogger(l)
+	}
+	return ds
+}
+
+// WithSlog is WithLogger for a slog.Handler.
+func (ds *DataStore) WithSlog(h slog.Handler) *DataStore {
+	return ds.WithLogger(logging.FromSlog(h))
+}
@@ -0,0 +1,62 @@
+// Package logging has the loggers of schemaless. Library code logs nothing
+// unless it is given a logger, with WithLogger or WithSlog:
+//
+//	store := sqlite.New(tblName, path).
+//		WithLogger(logger).
+//		WithSlowQueries(logging.Thresholds{Warn: 100 * time.Millisecond})
+//
+// Every SQL statement a backend runs is logged at debug level, and slow ones
+// at the level of the highest threshold they passed.
+package logging
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Thresholds are how long a SQL statement must take to be logged at info,
+// warn or error level. A zero threshold is not used.
+type Thresholds struct {
+	Info  time.Duration
+	Warn  time.Duration
+	Error time.Duration
+}
+
+// Level returns the level of a statement that took d.
+func (t Thresholds) Level(d time.Duration) zapcore.Level {
+	switch {
+	case t.Error > 0 && d >= t.Error:
+		return zapcore.ErrorLevel
+	case t.Warn > 0 && d >= t.Warn:
+		return zapcore.WarnLevel
+	case t.Info > 0 && d >= t.Info:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
+
+// Statement logs a SQL statement run against tblName that took d, at the
+// level t gives it.
+func Statement(l *zap.Logger, t Thresholds, op, tblName, statement string, d time.Duration, err error) {
+	level := t.Level(d)
+	msg := "statement"
+	if level > zapcore.DebugLevel {
+		msg = "slow statement"
+	}
+	ce := l.Check(level, msg)
+	if ce == nil {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("op", op),
+		zap.String("table", tblName),
+		zap.String("statement", statement),
+		zap.Duration("duration", d),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	ce.Write(fields...)
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestThresholds(t *testing.T) {
+	th := Thresholds{Info: 10 * time.Millisecond, Error: time.Second}
+	for _, c := range []struct {
+		d    time.Duration
+		want zapcore.Level
+	}{
+		{time.Millisecond, zapcore.DebugLevel},
+		{10 * time.Millisecond, zapcore.InfoLevel},
+		{500 * time.Millisecond, zapcore.InfoLevel}, // no warn threshold
+		{2 * time.Second, zapcore.ErrorLevel},
+	} {
+		if got := th.Level(c.d); got != c.want {
+			t.Errorf("Level(%s) = %s, want %s", c.d, got, c.want)
+		}
+	}
+	if got := (Thresholds{}).Level(time.Hour); got != zapcore.DebugLevel {
+		t.Errorf("expected no thresholds to log at debug level, got %s", got)
+	}
+}
+
+func TestStatement(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := zap.New(core)
+	th := Thresholds{Warn: 100 * time.Millisecond}
+
+	Statement(l, th, "SELECT", "cell", "SELECT 1", time.Millisecond, nil)
+	if logs.Len() != 0 {
+		t.Fatalf("expected a fast statement to be logged at debug level, got %v", logs.All())
+	}
+
+	Statement(l, th, "INSERT", "cell", "INSERT ...", time.Second, errors.New("locked"))
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Level != zapcore.WarnLevel || entries[0].Message != "slow statement" {
+		t.Fatalf("expected a slow statement warning, got %v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if fields["table"] != "cell" || fields["op"] != "INSERT" || fields["error"] != "locked" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestFromSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := FromSlog(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	l.Debug("dropped")
+	l.With(zap.String("shard", "shard0")).Warn("buffering write", zap.Int64("cells", 2))
+
+	var rec map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["level"] != "WARN" || rec["msg"] != "buffering write" || rec["shard"] != "shard0" || rec["cells"] != float64(2) {
+		t.Errorf("unexpected record %v", rec)
+	}
+}
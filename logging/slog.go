@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// FromSlog returns a zap.Logger that writes to h, for the WithSlog options
+// of backends and DataStore.
+func FromSlog(h slog.Handler) *zap.Logger {
+	return zap.New(slogCore{h: h})
+}
+
+// slogCore is a zapcore.Core that hands entries to a slog.Handler.
+type slogCore struct {
+	h slog.Handler
+}
+
+func (c slogCore) Enabled(level zapcore.Level) bool {
+	return c.h.Enabled(context.Background(), slogLevel(level))
+}
+
+func (c slogCore) With(fields []zapcore.Field) zapcore.Core {
+	return slogCore{h: c.h.WithAttrs(attrs(fields))}
+}
+
+func (c slogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c slogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	r := slog.NewRecord(e.Time, slogLevel(e.Level), e.Message, 0)
+	r.AddAttrs(attrs(fields)...)
+	return c.h.Handle(context.Background(), r)
+}
+
+func (c slogCore) Sync() error {
+	return nil
+}
+
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level < zapcore.InfoLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// attrs converts zap fields to slog attributes, in the same order.
+func attrs(fields []zapcore.Field) []slog.Attr {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	as := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		if v, ok := enc.Fields[f.Key]; ok {
+			as = append(as, slog.Any(f.Key, v))
+		}
+	}
+	return as
+}
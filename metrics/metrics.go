@@ -0,0 +1,159 @@
+// Package metrics records Prometheus metrics for a DataStore: the latency
+// and outcome of every call to a shard, the reads of continuum
+// migrations, the backlog of asynchronous index writes, and the connection
+// pools of SQL shards.
+//
+//	m := metrics.New(prometheus.DefaultRegisterer)
+//	err := m.Instrument(store, "trips")
+//	http.Handle("/metrics", promhttp.Handler())
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storage/middleware"
+)
+
+const namespace = "schemaless"
+
+// Metrics holds the collectors shared by every instrumented table.
+type Metrics struct {
+	reg      prometheus.Registerer
+	calls    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	backlog  *prometheus.GaugeVec
+}
+
+// New registers the call and index backlog metrics with reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		reg: reg,
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_calls_total",
+			Help:      "Calls to a shard, by table, shard, operation and outcome.",
+		}, []string{"table", "shard", "op", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_call_duration_seconds",
+			Help:      "Latency of calls to a shard, by table, shard, operation and outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16), // 0.5ms to 16s
+		}, []string{"table", "shard", "op", "outcome"}),
+		backlog: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "index_backlog",
+			Help:      "Asynchronous index writes not yet completed, by index table.",
+		}, []string{"table"}),
+	}
+	reg.MustRegister(m.calls, m.duration, m.backlog)
+	return m
+}
+
+// Outcome names the class of a call's error, for the outcome label.
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, core.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, core.ErrConflict):
+		return "conflict"
+	case errors.Is(err, core.ErrTransient):
+		return "transient"
+	case errors.Is(err, core.ErrShardUnavailable):
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
+
+// Middleware returns a middleware that records the calls to the named
+// shard.
+func (m *Metrics) Middleware(shard string) core.Middleware {
+	return middleware.Timing(func(call middleware.Call, d time.Duration, err error) {
+		if call.Op == "Ping" {
+			return
+		}
+		outcome := Outcome(err)
+		m.calls.WithLabelValues(call.Table, shard, call.Op, outcome).Inc()
+		m.duration.WithLabelValues(call.Table, shard, call.Op, outcome).Observe(d.Seconds())
+	})
+}
+
+// Instrument records the calls to every shard of tblName, and registers
+// gauges and counters for the reads of its continuum migrations.
+func (m *Metrics) Instrument(ds *schemaless.DataStore, tblName string) error {
+	_, err := ds.MigrationStats(tblName)
+	if err != nil {
+		return err
+	}
+	ds.UseEach(tblName, m.Middleware)
+
+	stats := func() core.MigrationStats {
+		ms, _ := ds.MigrationStats(tblName)
+		return ms
+	}
+	labels := prometheus.Labels{"table": tblName}
+	return registerAll(m.reg,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "migration_in_progress",
+			Help:        "Whether a continuum migration is in progress.",
+			ConstLabels: labels,
+		}, func() float64 {
+			if stats().Migrating {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "migration_read_ratio",
+			Help:        "Share of reads during the migration that were served by the new shards. It depends on which rows are read, and is not the share of cells migrated.",
+			ConstLabels: labels,
+		}, func() float64 {
+			return stats().ReadRatio()
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "migration_migrated_reads_total",
+			Help:        "Reads during the migration that were served by the new shards.",
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(stats().MigratedReads)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "migration_fallback_reads_total",
+			Help:        "Reads during the migration that fell back to the old shards.",
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(stats().FallbackReads)
+		}),
+	)
+}
+
+func registerAll(reg prometheus.Registerer, cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		err := reg.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexWriteStarted counts an asynchronous write to an index table into
+// the backlog; IndexWriteDone counts it out again.
+func (m *Metrics) IndexWriteStarted(indexTable string) {
+	m.backlog.WithLabelValues(indexTable).Inc()
+}
+
+// IndexWriteDone counts a finished index write out of the backlog.
+func (m *Metrics) IndexWriteDone(indexTable string) {
+	m.backlog.WithLabelValues(indexTable).Dec()
+}
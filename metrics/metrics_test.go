@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+const tblName = "cell"
+
+func TestMetrics(t *testing.T) {
+	ds := schemaless.New().WithSources(tblName, []core.Shard{{Name: "shard0", Backend: storagetest.NewSQLiteTable(t, tblName)}})
+	defer ds.Destroy(context.TODO())
+
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+	err := m.Instrument(ds, tblName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	err = ds.Put(ctx, tblName, "trip1", "BASE", 1, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.Put(ctx, tblName, "trip1", "BASE", 1, `{}`)
+	if err == nil {
+		t.Fatal("expected a conflict")
+	}
+	_, _, err = ds.GetLatest(ctx, tblName, "trip1", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		op, outcome string
+		want        float64
+	}{
+		{"Put", "ok", 1},
+		{"Put", "conflict", 1},
+		{"GetLatest", "ok", 1},
+		{"Get", "ok", 0},
+	} {
+		got := testutil.ToFloat64(m.calls.WithLabelValues(tblName, "shard0", c.op, c.outcome))
+		if got != c.want {
+			t.Errorf("expected %v %s calls with outcome %s, got %v", c.want, c.op, c.outcome, got)
+		}
+	}
+
+	m.IndexWriteStarted("cell_base_driver")
+	m.IndexWriteStarted("cell_base_driver")
+	m.IndexWriteDone("cell_base_driver")
+	if got := testutil.ToFloat64(m.backlog.WithLabelValues("cell_base_driver")); got != 1 {
+		t.Errorf("expected an index backlog of 1, got %v", got)
+	}
+
+	expected := `
+# HELP schemaless_migration_in_progress Whether a continuum migration is in progress.
+# TYPE schemaless_migration_in_progress gauge
+schemaless_migration_in_progress{table="cell"} 0
+# HELP schemaless_migration_read_ratio Share of reads during the migration that were served by the new shards. It depends on which rows are read, and is not the share of cells migrated.
+# TYPE schemaless_migration_read_ratio gauge
+schemaless_migration_read_ratio{table="cell"} 0
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "schemaless_migration_in_progress", "schemaless_migration_read_ratio")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInstrumentPool(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+	stats := sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 5}
+	err := m.InstrumentPool(tblName, "shard0", func() sql.DBStats { return stats })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP schemaless_db_in_use_connections Connections to the shard in use.
+# TYPE schemaless_db_in_use_connections gauge
+schemaless_db_in_use_connections{shard="shard0",table="cell"} 1
+# HELP schemaless_db_wait_count_total Calls that waited for a connection to the shard.
+# TYPE schemaless_db_wait_count_total counter
+schemaless_db_wait_count_total{shard="shard0",table="cell"} 5
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "schemaless_db_in_use_connections", "schemaless_db_wait_count_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// each shard is registered once
+	err = m.InstrumentPool(tblName, "shard0", func() sql.DBStats { return stats })
+	if err == nil {
+		t.Error("expected registering a shard twice to fail")
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentPool registers the statistics of a shard's connection pool,
+// which stats is called for at every scrape, e.g. the Stats method of a
+// SQL backend. tblName is the table the shard serves.
+func (m *Metrics) InstrumentPool(tblName, shard string, stats func() sql.DBStats) error {
+	labels := prometheus.Labels{"table": tblName, "shard": shard}
+	gauge := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return value(stats()) })
+	}
+	counter := func(name, help string, value func(sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return value(stats()) })
+	}
+
+	return registerAll(m.reg,
+		gauge("db_max_open_connections", "Limit on the shard's open connections, 0 if unlimited.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }),
+		gauge("db_open_connections", "Connections open to the shard, in use or idle.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }),
+		gauge("db_in_use_connections", "Connections to the shard in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }),
+		gauge("db_idle_connections", "Idle connections to the shard.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }),
+		counter("db_wait_count_total", "Calls that waited for a connection to the shard.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }),
+		counter("db_wait_duration_seconds_total", "Time spent waiting for connections to the shard.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }),
+		counter("db_max_idle_closed_total", "Connections to the shard closed because of the idle connection limit.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }),
+		counter("db_max_idle_time_closed_total", "Connections to the shard closed for being idle too long.",
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }),
+		counter("db_max_lifetime_closed_total", "Connections to the shard closed for reaching their lifetime.",
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }),
+	)
+}
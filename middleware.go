@@ -0,0 +1,47 @@
+package schemaless
+
+import "github.com/rbastic/go-schemaless/core"
+
+// Use wraps every shard of tblName, and so of any index table that shares
+// its shards, in mws. See core.KVStore.Use.
+func (ds *DataStore) Use(tblName string, mws ...core.Middleware) *DataStore {
+	tbl, err := ds.getTable(tblName)
+	if err != nil {
+		panic(err)
+	}
+
+	tbl.Use(mws...)
+	return ds
+}
+
+// UseShard wraps the named shard of tblName in mws.
+func (ds *DataStore) UseShard(tblName, shard string, mws ...core.Middleware) *DataStore {
+	tbl, err := ds.getTable(tblName)
+	if err != nil {
+		panic(err)
+	}
+
+	tbl.UseShard(shard, mws...)
+	return ds
+}
+
+// UseEach wraps every shard of tblName in a middleware made for it. See
+// core.KVStore.UseEach.
+func (ds *DataStore) UseEach(tblName string, mw func(shard string) core.Middleware) *DataStore {
+	tbl, err := ds.getTable(tblName)
+	if err != nil {
+		panic(err)
+	}
+
+	tbl.UseEach(mw)
+	return ds
+}
+
+// MigrationStats reports on the continuum migration of tblName's shards.
+func (ds *DataStore) MigrationStats(tblName string) (core.MigrationStats, error) {
+	tbl, err := ds.getTable(tblName)
+	if err != nil {
+		return core.MigrationStats{}, err
+	}
+	return tbl.MigrationStats(), nil
+}
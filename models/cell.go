@@ -24,8 +24,9 @@ type Cell struct {
 	RowKey     string `json:",omitempty"` // UUID, typically, but could be anything
 	ColumnName string `json:",omitempty"` // The actual column name for the individual Body blob
 	RefKey     int64  `json:",omitempty"` // for versioning or sorting cells in a list
-	Body       string `json:",omitempty"` // Uber chose JSON inside MessagePack'd LZ4 blobs, we store raw JSON
+	Body       string `json:",omitempty"` // Uber chose JSON inside MessagePack'd LZ4 blobs, we store raw JSON (see storage/compression)
 	CreatedAt  int64  `json:",omitempty"`
+	ExpiresAt  int64  `json:",omitempty"` // nanoseconds since the epoch; 0 never expires
 }
 
 // NewCell constructs a Cell structure with the minimum parameters necessary:
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// TombstoneBody is the body of the cell written by a delete. It is JSON so
+// that it can be stored in the JSON body columns of mysql and postgres.
+const TombstoneBody = `{"_schemaless_tombstone":true}`
+
+const tombstoneField = "_schemaless_tombstone"
+
+// IsTombstone reports whether the cell marks its column as deleted. A
+// tombstone hides the earlier versions of the column from reads of the
+// latest value; writing a cell with a higher ref key brings it back.
+func (c Cell) IsTombstone() bool {
+	if c.Body == TombstoneBody {
+		return true
+	}
+	// mysql normalizes JSON, e.g. adding a space after the colon
+	if !strings.Contains(c.Body, tombstoneField) {
+		return false
+	}
+	var body map[string]interface{}
+	if json.Unmarshal([]byte(c.Body), &body) != nil || len(body) != 1 {
+		return false
+	}
+	return body[tombstoneField] == true
+}
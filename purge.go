@@ -0,0 +1,170 @@
+package schemaless
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+)
+
+// Index describes a secondary index of a table, so that Purge can find the
+// entries that point at a row. Entries live in Table, keyed by the value of
+// SourceField in the body of the indexed Column, under that same column
+// name and ref key, and carry the indexed row's key in their "row_key"
+// field (as schemalessd writes them).
+type Index struct {
+	Table       string
+	Column      string
+	SourceField string
+}
+
+// PurgeRecord is the audit record of a purge. It names the purged row but
+// holds none of its data.
+type PurgeRecord struct {
+	Table      string    `json:"table"`
+	RowKey     string    `json:"row_key"`
+	Reason     string    `json:"reason,omitempty"`
+	Cells      int64     `json:"cells"`       // cells deleted from the table
+	IndexCells int64     `json:"index_cells"` // entries deleted from index tables
+	PurgedAt   time.Time `json:"purged_at"`
+}
+
+// PurgeAuditor records every purge.
+type PurgeAuditor interface {
+	RecordPurge(ctx context.Context, rec PurgeRecord) error
+}
+
+type purgeLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPurgeLog returns a PurgeAuditor that appends each record to w as a
+// line of JSON.
+func NewPurgeLog(w io.Writer) PurgeAuditor {
+	return &purgeLog{w: w}
+}
+
+func (l *purgeLog) RecordPurge(ctx context.Context, rec PurgeRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(line)
+	return err
+}
+
+// WithIndex tells Purge about a secondary index of tblName.
+func (ds *DataStore) WithIndex(tblName string, idx Index) *DataStore {
+	ds.indexes[tblName] = append(ds.indexes[tblName], idx)
+	return ds
+}
+
+// WithPurgeAuditor records every purge with a.
+func (ds *DataStore) WithPurgeAuditor(a PurgeAuditor) *DataStore {
+	ds.auditor = a
+	return ds
+}
+
+// Purge physically deletes every version of every column of rowKey, along
+// with the entries of tblName's indexes (see WithIndex) that point at it,
+// and records the purge with the datastore's PurgeAuditor. Unlike Delete,
+// it cannot be undone. Every shard backend must implement core.Purger.
+//
+// Index entries are purged first, since they are found through the row's
+// cells. If Purge fails part way, calling it again finishes the job.
+func (ds *DataStore) Purge(ctx context.Context, tblName, rowKey, reason string) (PurgeRecord, error) {
+	rec := PurgeRecord{Table: tblName, RowKey: rowKey, Reason: reason}
+
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return rec, err
+	}
+
+	for _, idx := range ds.indexes[tblName] {
+		n, err := ds.purgeIndex(ctx, tblName, rowKey, idx)
+		rec.IndexCells += n
+		if err != nil {
+			return rec, fmt.Errorf("purging %s (%s) from index %s: %w", tblName, rowKey, idx.Table, err)
+		}
+	}
+
+	rec.Cells, err = source.Purge(ctx, tblName, rowKey)
+	if err != nil {
+		return rec, fmt.Errorf("purging %s (%s): %w", tblName, rowKey, err)
+	}
+	rec.PurgedAt = time.Now().UTC()
+
+	if ds.auditor != nil {
+		err = ds.auditor.RecordPurge(ctx, rec)
+		if err != nil {
+			return rec, fmt.Errorf("recording purge of %s (%s): %w", tblName, rowKey, err)
+		}
+	}
+	ds.log.Info("purged row",
+		zap.String("table", tblName),
+		zap.String("row_key", rowKey),
+		zap.Int64("cells", rec.Cells),
+		zap.Int64("index_cells", rec.IndexCells),
+		zap.String("reason", reason))
+	return rec, nil
+}
+
+// purgeIndex deletes the entries of idx that were written for any version
+// of rowKey's indexed column.
+func (ds *DataStore) purgeIndex(ctx context.Context, tblName, rowKey string, idx Index) (int64, error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return 0, err
+	}
+	// expired versions and those left on the old shards of a migration were
+	// indexed too
+	versions, _, err := source.HistoryAll(ctx, tblName, rowKey, idx.Column, math.MaxInt32)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make(map[string]bool)
+	for _, cell := range versions {
+		if cell.IsTombstone() {
+			continue
+		}
+		if value := gjson.Get(cell.Body, idx.SourceField).String(); value != "" {
+			values[value] = true
+		}
+	}
+
+	index, err := ds.getTable(idx.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for value := range values {
+		entries, _, err := index.HistoryAll(ctx, idx.Table, value, idx.Column, math.MaxInt32)
+		if err != nil {
+			return n, err
+		}
+		for _, entry := range entries {
+			if gjson.Get(entry.Body, "row_key").String() != rowKey {
+				continue
+			}
+			err = index.PurgeCell(ctx, idx.Table, value, idx.Column, entry.RefKey)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
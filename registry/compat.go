@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// checkBackward reports the changes from old to new that could reject a
+// body old accepts. It inspects the keywords applications commonly change
+// (type, required, properties, additionalProperties, enum, items and the
+// numeric and length bounds) rather than deciding schema inclusion in
+// general, and treats a schema it cannot read as compatible.
+func checkBackward(old, new interface{}, path string) []string {
+	o, ok := old.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	n, ok := new.(map[string]interface{})
+	if !ok {
+		// 'true' accepts everything, 'false' nothing
+		if b, isBool := new.(bool); isBool && !b {
+			return []string{at(path, "now rejects every value")}
+		}
+		return nil
+	}
+
+	var problems []string
+
+	if oldTypes, newTypes := types(o["type"]), types(n["type"]); newTypes != nil {
+		for _, t := range sortedKeys(oldTypes) {
+			if !newTypes[t] && !(t == "integer" && newTypes["number"]) {
+				problems = append(problems, at(path, fmt.Sprintf("type %s is no longer accepted", t)))
+			}
+		}
+		if oldTypes == nil {
+			problems = append(problems, at(path, "type is now restricted"))
+		}
+	}
+
+	oldRequired := stringSet(o["required"])
+	for _, name := range sortedKeys(stringSet(n["required"])) {
+		if !oldRequired[name] {
+			problems = append(problems, at(path, fmt.Sprintf("property %s is now required", name)))
+		}
+	}
+
+	if closed(n) && !closed(o) {
+		problems = append(problems, at(path, "additional properties are no longer allowed"))
+	}
+
+	oldProps, _ := o["properties"].(map[string]interface{})
+	newProps, _ := n["properties"].(map[string]interface{})
+	for _, name := range sortedKeys(oldProps) {
+		newProp, ok := newProps[name]
+		if !ok {
+			if closed(n) {
+				problems = append(problems, at(path, fmt.Sprintf("property %s was removed", name)))
+			}
+			continue
+		}
+		problems = append(problems, checkBackward(oldProps[name], newProp, path+"/"+name)...)
+	}
+
+	if newEnum, ok := n["enum"].([]interface{}); ok {
+		oldEnum, ok := o["enum"].([]interface{})
+		if !ok {
+			problems = append(problems, at(path, "values are now restricted to an enum"))
+		}
+		for _, v := range oldEnum {
+			if !contains(newEnum, v) {
+				problems = append(problems, at(path, fmt.Sprintf("enum value %v was removed", v)))
+			}
+		}
+	}
+
+	if oldItems, ok := o["items"]; ok {
+		if newItems, ok := n["items"]; ok {
+			problems = append(problems, checkBackward(oldItems, newItems, path+"/items")...)
+		}
+	} else if _, ok := n["items"]; ok {
+		problems = append(problems, at(path, "items are now restricted"))
+	}
+
+	for _, kw := range []string{"minimum", "exclusiveMinimum", "minLength", "minItems", "minProperties"} {
+		if tightened(o[kw], n[kw], 1) {
+			problems = append(problems, at(path, kw+" was raised"))
+		}
+	}
+	for _, kw := range []string{"maximum", "exclusiveMaximum", "maxLength", "maxItems", "maxProperties"} {
+		if tightened(o[kw], n[kw], -1) {
+			problems = append(problems, at(path, kw+" was lowered"))
+		}
+	}
+
+	if n["pattern"] != nil && n["pattern"] != o["pattern"] {
+		problems = append(problems, at(path, "pattern changed"))
+	}
+
+	return problems
+}
+
+func at(path, problem string) string {
+	if path == "" {
+		return problem
+	}
+	return path + ": " + problem
+}
+
+func types(v interface{}) map[string]bool {
+	switch t := v.(type) {
+	case string:
+		return map[string]bool{t: true}
+	case []interface{}:
+		return stringSet(t)
+	default:
+		return nil
+	}
+}
+
+func stringSet(v interface{}) map[string]bool {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	set := make(map[string]bool, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func closed(schema map[string]interface{}) bool {
+	b, ok := schema["additionalProperties"].(bool)
+	return ok && !b
+}
+
+func contains(list []interface{}, v interface{}) bool {
+	want, _ := json.Marshal(v)
+	for _, item := range list {
+		got, _ := json.Marshal(item)
+		if string(got) == string(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// tightened reports whether a bound moved in direction dir (1 for up, -1
+// for down), including being newly introduced.
+func tightened(old, new interface{}, dir int) bool {
+	n, ok := number(new)
+	if !ok {
+		return false
+	}
+	o, ok := number(old)
+	if !ok {
+		return true
+	}
+	return (n-o)*float64(dir) > 0
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
@@ -0,0 +1,223 @@
+// Package registry attaches JSON Schemas to (table, column) pairs so that
+// cell bodies can be validated before they are written.
+//
+// "Applications typically group related data into the same column, and
+// then all cells in each column have roughly the same application-side
+// schema." Since cells are immutable, a bad write is permanent; the
+// registry lets an application say what a column's cells look like and
+// reject anything else up front.
+//
+// Schemas are versioned. Registering a new version for a column checks it
+// against the previous one (see Compatibility), so that cells already
+// written remain valid under the schema in force.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Compatibility controls which schema changes Register accepts.
+type Compatibility int
+
+const (
+	// Backward only accepts a new version that still accepts the cells
+	// valid under the previous version.
+	Backward Compatibility = iota
+	// None accepts any new version.
+	None
+)
+
+// Schema is one version of the JSON Schema of a column.
+type Schema struct {
+	Version int
+	Source  string
+
+	doc      interface{}
+	compiled *jsonschema.Schema
+}
+
+// Problem is one reason a body does not match a schema. Path is a JSON
+// pointer into the body.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError is returned when a body does not match the latest
+// schema of its column.
+type ValidationError struct {
+	Table    string
+	Column   string
+	Version  int
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var problems []string
+	for _, p := range e.Problems {
+		problems = append(problems, p.String())
+	}
+	return fmt.Sprintf("body does not match schema version %d of %s.%s: %s", e.Version, e.Table, e.Column, strings.Join(problems, "; "))
+}
+
+// IncompatibleError is returned when a new schema version would reject
+// cells that the previous version accepts.
+type IncompatibleError struct {
+	Table    string
+	Column   string
+	Version  int
+	Problems []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema version %d of %s.%s is incompatible with version %d: %s", e.Version, e.Table, e.Column, e.Version-1, strings.Join(e.Problems, "; "))
+}
+
+// Registry holds the schema versions of every registered column.
+type Registry struct {
+	compatibility Compatibility
+
+	mu      sync.RWMutex
+	schemas map[string][]*Schema
+}
+
+// New is an empty constructor for Registry, checking Backward compatibility.
+func New() *Registry {
+	return &Registry{schemas: make(map[string][]*Schema)}
+}
+
+// WithCompatibility sets the compatibility rule for new schema versions.
+func (r *Registry) WithCompatibility(c Compatibility) *Registry {
+	r.compatibility = c
+	return r
+}
+
+// decode parses JSON the way the validator expects, keeping numbers exact.
+func decode(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+func key(tblName, columnKey string) string {
+	return tblName + "." + columnKey
+}
+
+// Register adds source as the next schema version of (tblName, columnKey)
+// and returns its version number, starting from 1.
+func (r *Registry) Register(tblName, columnKey, source string) (int, error) {
+	doc, err := decode(source)
+	if err != nil {
+		return 0, fmt.Errorf("schema for %s.%s: %w", tblName, columnKey, err)
+	}
+
+	url := "schemaless://" + key(tblName, columnKey)
+	compiler := jsonschema.NewCompiler()
+	err = compiler.AddResource(url, strings.NewReader(source))
+	if err != nil {
+		return 0, fmt.Errorf("schema for %s.%s: %w", tblName, columnKey, err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return 0, fmt.Errorf("schema for %s.%s: %w", tblName, columnKey, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(tblName, columnKey)
+	versions := r.schemas[k]
+	s := &Schema{Version: len(versions) + 1, Source: source, doc: doc, compiled: compiled}
+
+	if len(versions) > 0 && r.compatibility == Backward {
+		problems := checkBackward(versions[len(versions)-1].doc, doc, "")
+		if len(problems) > 0 {
+			return 0, &IncompatibleError{Table: tblName, Column: columnKey, Version: s.Version, Problems: problems}
+		}
+	}
+
+	r.schemas[k] = append(versions, s)
+	return s.Version, nil
+}
+
+// Latest returns the schema currently in force for (tblName, columnKey).
+func (r *Registry) Latest(tblName, columnKey string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.schemas[key(tblName, columnKey)]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// Versions returns every schema version of (tblName, columnKey), oldest first.
+func (r *Registry) Versions(tblName, columnKey string) []*Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*Schema(nil), r.schemas[key(tblName, columnKey)]...)
+}
+
+// Validate checks body against the latest schema of (tblName, columnKey),
+// returning a *ValidationError if it does not match. Columns without a
+// schema accept any body.
+func (r *Registry) Validate(tblName, columnKey, body string) error {
+	s, ok := r.Latest(tblName, columnKey)
+	if !ok {
+		return nil
+	}
+
+	verr := &ValidationError{Table: tblName, Column: columnKey, Version: s.Version}
+
+	v, err := decode(body)
+	if err != nil {
+		verr.Problems = []Problem{{Message: "body is not valid JSON: " + err.Error()}}
+		return verr
+	}
+
+	err = s.compiled.Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	// report the leaves, which say what is actually wrong
+	var flatten func(*jsonschema.ValidationError)
+	flatten = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			verr.Problems = append(verr.Problems, Problem{Path: ve.InstanceLocation, Message: ve.Message})
+			return
+		}
+		for _, cause := range ve.Causes {
+			flatten(cause)
+		}
+	}
+	flatten(ve)
+	return verr
+}
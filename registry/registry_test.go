@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+const tripSchemaV1 = `{
+	"type": "object",
+	"required": ["driver_partner_uuid"],
+	"properties": {
+		"driver_partner_uuid": {"type": "string"},
+		"fare": {"type": "integer", "minimum": 0},
+		"status": {"enum": ["requested", "completed"]}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	r := New()
+
+	err := r.Validate("trips", "BASE", "not even JSON")
+	if err != nil {
+		t.Errorf("expected a column without a schema to accept anything, got %s", err)
+	}
+
+	version, err := r.Register("trips", "BASE", tripSchemaV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+
+	err = r.Validate("trips", "BASE", `{"driver_partner_uuid": "d1", "fare": 12, "status": "requested"}`)
+	if err != nil {
+		t.Errorf("expected a valid body, got %s", err)
+	}
+
+	err = r.Validate("trips", "BASE", `{"fare": "twelve"}`)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if verr.Version != 1 || len(verr.Problems) != 2 {
+		t.Errorf("expected two problems against version 1, got %s", verr)
+	}
+
+	err = r.Validate("trips", "BASE", `{"driver_partner_uuid": "d1"`)
+	if !errors.As(err, &verr) {
+		t.Errorf("expected a ValidationError for malformed JSON, got %v", err)
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	r := New()
+
+	_, err := r.Register("trips", "BASE", tripSchemaV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	incompatible := []string{
+		// a new required property
+		`{"type": "object", "required": ["driver_partner_uuid", "city_uuid"]}`,
+		// a narrowed type
+		`{"type": "object", "required": ["driver_partner_uuid"], "properties": {"fare": {"type": "string"}}}`,
+		// a removed enum value
+		`{"type": "object", "required": ["driver_partner_uuid"], "properties": {"status": {"enum": ["completed"]}}}`,
+		// closed to additional properties
+		`{"type": "object", "required": ["driver_partner_uuid"], "additionalProperties": false}`,
+		// a raised minimum
+		`{"type": "object", "required": ["driver_partner_uuid"], "properties": {"fare": {"minimum": 5}}}`,
+	}
+	for _, source := range incompatible {
+		_, err = r.Register("trips", "BASE", source)
+		var ierr *IncompatibleError
+		if !errors.As(err, &ierr) {
+			t.Errorf("expected %s to be incompatible, got %v", source, err)
+		}
+	}
+
+	// widening is fine
+	version, err := r.Register("trips", "BASE", `{
+		"type": "object",
+		"required": ["driver_partner_uuid"],
+		"properties": {
+			"driver_partner_uuid": {"type": "string"},
+			"fare": {"type": "number"},
+			"status": {"enum": ["requested", "completed", "cancelled"]},
+			"city_uuid": {"type": "string"}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || len(r.Versions("trips", "BASE")) != 2 {
+		t.Errorf("expected version 2, got %d", version)
+	}
+
+	err = r.Validate("trips", "BASE", `{"driver_partner_uuid": "d1", "fare": 12.5, "status": "cancelled"}`)
+	if err != nil {
+		t.Errorf("expected a body valid under version 2, got %s", err)
+	}
+
+	none := New().WithCompatibility(None)
+	_, err = none.Register("trips", "BASE", tripSchemaV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = none.Register("trips", "BASE", incompatible[0])
+	if err != nil {
+		t.Errorf("expected None to accept any change, got %s", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Package schema creates and migrates the cell tables on a shard, tracking
+// the schema version applied to each table.
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storage/mysql"
+	"github.com/rbastic/go-schemaless/storage/postgres"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+)
+
+const (
+	// VersionTable records the schema version applied to each cell table on a shard.
+	VersionTable = "schemaless_schema_version"
+
+	createVersionTableSQL = "CREATE TABLE IF NOT EXISTS " + VersionTable + " ( table_name VARCHAR(128) NOT NULL PRIMARY KEY, version INTEGER NOT NULL )"
+	getVersionSQL         = "SELECT version FROM " + VersionTable + " WHERE table_name = %s"
+	insertVersionSQL      = "INSERT INTO " + VersionTable + " ( table_name, version ) VALUES( %s, %s )"
+	updateVersionSQL      = "UPDATE " + VersionTable + " SET version = %s WHERE table_name = %s"
+)
+
+// Migrations returns the ordered, forward-only schema changes for a cell
+// table named tblName using the given driver ("sqlite3", "mysql" or
+// "postgres"). Applying the first n of them brings a table to version n.
+func Migrations(driver, tblName string) ([]string, error) {
+	err := core.ValidateTableName(tblName)
+	if err != nil {
+		return nil, err
+	}
+	switch driver {
+	case "sqlite3":
+		return sqlite.Migrations(tblName), nil
+	case "mysql":
+		return mysql.Migrations(tblName), nil
+	case "postgres":
+		return postgres.Migrations(tblName), nil
+	default:
+		return nil, fmt.Errorf("unrecognized driver: '%s'", driver)
+	}
+}
+
+// placeholders returns n bind parameters in the driver's syntax.
+func placeholders(driver string, n int) []interface{} {
+	var ph []interface{}
+	for i := 1; i <= n; i++ {
+		if driver == "postgres" {
+			ph = append(ph, fmt.Sprintf("$%d", i))
+		} else {
+			ph = append(ph, "?")
+		}
+	}
+	return ph
+}
+
+// Version returns the schema version applied to tblName, or 0 if it has
+// never been migrated.
+func Version(ctx context.Context, db *sql.DB, driver, tblName string) (int, error) {
+	// don't create the version table just to read from it
+	columns, _, err := Describe(ctx, db, driver, VersionTable)
+	if err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, fmt.Sprintf(getVersionSQL, placeholders(driver, 1)...), tblName).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Pending returns the migrations not yet applied to tblName, and the
+// version the table is currently at.
+func Pending(ctx context.Context, db *sql.DB, driver, tblName string) (pending []string, version int, err error) {
+	migrations, err := Migrations(driver, tblName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err = Version(ctx, db, driver, tblName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version > len(migrations) {
+		return nil, version, fmt.Errorf("table %s is at schema version %d, newer than this build knows about (%d)", tblName, version, len(migrations))
+	}
+
+	return migrations[version:], version, nil
+}
+
+// Migrate applies every pending migration to tblName, recording the new
+// version after each step. Migrations only ever add tables, columns and
+// indexes; existing cells are left untouched.
+func Migrate(ctx context.Context, db *sql.DB, driver, tblName string) (from int, to int, err error) {
+	pending, from, err := Pending(ctx, db, driver, tblName)
+	if err != nil {
+		return from, from, err
+	}
+
+	to = from
+	if len(pending) == 0 {
+		return from, to, nil
+	}
+
+	_, err = db.ExecContext(ctx, createVersionTableSQL)
+	if err != nil {
+		return from, to, err
+	}
+
+	for _, stmt := range pending {
+		var applied bool
+		applied, err = alreadyApplied(ctx, db, driver, tblName, stmt)
+		if err != nil {
+			return from, to, err
+		}
+		if !applied {
+			_, err = db.ExecContext(ctx, stmt)
+			if err != nil {
+				return from, to, fmt.Errorf("migrating %s to version %d: %w", tblName, to+1, err)
+			}
+		}
+
+		err = setVersion(ctx, db, driver, tblName, to+1)
+		if err != nil {
+			return from, to, err
+		}
+		to++
+	}
+
+	return from, to, nil
+}
+
+var (
+	addColumnRE = regexp.MustCompile(`(?i)ADD COLUMN (?:IF NOT EXISTS )?(\w+)`)
+	addIndexRE  = regexp.MustCompile("(?i)ADD INDEX (?:IF NOT EXISTS )?`?(\\w+)`?")
+)
+
+// alreadyApplied reports whether stmt adds a column, or failing that an
+// index, that tblName already has. Not every database can ADD COLUMN IF NOT
+// EXISTS or ADD INDEX IF NOT EXISTS, and a backend may add a column itself
+// when it opens a table (as sqlite.New does), or a table may have been
+// created with an index a later step adds to older tables, so such a step
+// can find its work already done.
+func alreadyApplied(ctx context.Context, db *sql.DB, driver, tblName, stmt string) (bool, error) {
+	if m := addColumnRE.FindStringSubmatch(stmt); m != nil {
+		columns, _, err := Describe(ctx, db, driver, tblName)
+		if err != nil {
+			return false, err
+		}
+		for _, col := range columns {
+			if strings.EqualFold(col.Name, m[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if m := addIndexRE.FindStringSubmatch(stmt); m != nil {
+		_, indexes, err := Describe(ctx, db, driver, tblName)
+		if err != nil {
+			return false, err
+		}
+		for _, idx := range indexes {
+			if strings.EqualFold(idx.Name, m[1]) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func setVersion(ctx context.Context, db *sql.DB, driver, tblName string, version int) error {
+	res, err := db.ExecContext(ctx, fmt.Sprintf(updateVersionSQL, placeholders(driver, 2)...), version, tblName)
+	if err != nil {
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCnt > 0 {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(insertVersionSQL, placeholders(driver, 2)...), tblName, version)
+	return err
+}
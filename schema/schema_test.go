@@ -0,0 +1,127 @@
+package schema
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rbastic/go-schemaless/storage/mysql"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+)
+
+func TestMigrateSQLite(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := context.TODO()
+
+	db, err := sqlite.Open("cell", dir+"/shard0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// a table that predates versioning, holding data we must keep
+	_, err = db.Exec("CREATE TABLE cell ( added_at INTEGER PRIMARY KEY AUTOINCREMENT, row_key VARCHAR(36) NOT NULL, column_name VARCHAR(64) NOT NULL, ref_key INTEGER NOT NULL, body TEXT, created_at INTEGER DEFAULT 0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO cell ( row_key, column_name, ref_key, body ) VALUES ( 'a', 'BASE', 1, '{}' )")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := len(sqlite.Migrations("cell"))
+
+	from, to, err := Migrate(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 || to != latest {
+		t.Errorf("expected migration from 0 to %d, got %d to %d", latest, from, to)
+	}
+
+	from, to, err = Migrate(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != latest || to != latest {
+		t.Errorf("expected no-op migration at %d, got %d to %d", latest, from, to)
+	}
+
+	var n int
+	err = db.QueryRow("SELECT COUNT(*) FROM cell").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("migration lost data: %d rows left", n)
+	}
+
+	_, err = db.Exec("INSERT INTO cell ( row_key, column_name, ref_key, body ) VALUES ( 'a', 'BASE', 1, '{}' )")
+	if err == nil {
+		t.Error("expected the unique cell index to reject a duplicate ref_key")
+	}
+}
+
+func TestVerifySQLite(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := context.TODO()
+
+	db, err := sqlite.Open("cell", dir+"/shard0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	drift, err := Verify(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 || drift[0].Problem != "table is missing" {
+		t.Errorf("expected a missing table, got %v", drift)
+	}
+
+	_, _, err = Migrate(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err = Verify(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 0 {
+		t.Errorf("expected no drift after migrating, got %v", drift)
+	}
+
+	_, err = db.Exec("DROP INDEX asofcell_idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift, err = Verify(ctx, db, "sqlite3", "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift) != 1 || drift[0].Fix != "CREATE INDEX asofcell_idx ON cell ( row_key, column_name, created_at )" {
+		t.Errorf("expected a missing as-of index, got %v", drift)
+	}
+}
+
+// TestMySQLIndexSteps checks that Migrate can recognise the MySQL steps that
+// add an index, which tables created with it already have.
+func TestMySQLIndexSteps(t *testing.T) {
+	var names []string
+	for _, stmt := range mysql.Migrations("cell")[1:] {
+		if addColumnRE.MatchString(stmt) {
+			continue
+		}
+		m := addIndexRE.FindStringSubmatch(stmt)
+		if m != nil {
+			names = append(names, m[1])
+		}
+	}
+	if len(names) != 1 || names[0] != "asof_idx" {
+		t.Errorf("expected the asof_idx step to be recognised, got %v", names)
+	}
+}
@@ -0,0 +1,364 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+)
+
+// Drift describes one way a shard's table differs from the expected cell
+// schema, along with a suggested fix.
+type Drift struct {
+	Table   string
+	Problem string
+	Fix     string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: %s (fix: %s)", d.Table, d.Problem, d.Fix)
+}
+
+// Column is a column as reported by the database.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Index is an index as reported by the database, with its columns in order.
+type Index struct {
+	Name    string
+	Unique  bool
+	Columns []string
+}
+
+type expectedColumn struct {
+	name   string
+	family string
+	// ddl is the column definition used when suggesting ADD COLUMN, keyed by driver
+	ddl map[string]string
+}
+
+type expectedIndex struct {
+	unique  bool
+	columns []string
+	// create is the suggested CREATE INDEX statement, keyed by driver
+	create map[string]string
+}
+
+// cellColumns and cellIndexes mirror the createTableSQL of each backend.
+var cellColumns = []expectedColumn{
+	{"added_at", "integer", map[string]string{"sqlite3": "INTEGER", "mysql": "INTEGER PRIMARY KEY AUTO_INCREMENT", "postgres": "BIGSERIAL PRIMARY KEY"}},
+	{"row_key", "text", map[string]string{"sqlite3": "VARCHAR(36) NOT NULL DEFAULT ''", "mysql": "VARCHAR(36) NOT NULL", "postgres": "VARCHAR(36) NOT NULL"}},
+	{"column_name", "text", map[string]string{"sqlite3": "VARCHAR(64) NOT NULL DEFAULT ''", "mysql": "VARCHAR(64) NOT NULL", "postgres": "VARCHAR(64) NOT NULL"}},
+	{"ref_key", "integer", map[string]string{"sqlite3": "INTEGER NOT NULL DEFAULT 0", "mysql": "INTEGER NOT NULL", "postgres": "INTEGER NOT NULL"}},
+	{"body", "text", map[string]string{"sqlite3": "TEXT", "mysql": "JSON", "postgres": "JSON"}},
+	{"created_at", "timestamp", map[string]string{"sqlite3": "INTEGER DEFAULT 0", "mysql": "DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6)", "postgres": "TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP"}},
+	{"expires_at", "timestamp", map[string]string{"sqlite3": "INTEGER", "mysql": "DATETIME(6) NULL", "postgres": "TIMESTAMP WITH TIME ZONE"}},
+}
+
+var cellIndexes = []expectedIndex{
+	{true, []string{"row_key", "column_name", "ref_key"}, map[string]string{
+		"sqlite3":  "CREATE UNIQUE INDEX uniq%[1]s_idx ON %[1]s ( row_key, column_name, ref_key )",
+		"mysql":    "CREATE UNIQUE INDEX cell_idx ON %[1]s ( row_key, column_name, ref_key )",
+		"postgres": "CREATE UNIQUE INDEX %[1]s_idx ON %[1]s ( row_key, column_name, ref_key )",
+	}},
+	{false, []string{"row_key", "column_name", "created_at"}, map[string]string{
+		"sqlite3":  "CREATE INDEX asof%[1]s_idx ON %[1]s ( row_key, column_name, created_at )",
+		"mysql":    "CREATE INDEX asof_idx ON %[1]s ( row_key, column_name, created_at )",
+		"postgres": "CREATE INDEX %[1]s_asof_idx ON %[1]s ( row_key, column_name, created_at )",
+	}},
+	{false, []string{"expires_at"}, map[string]string{
+		"sqlite3":  "CREATE INDEX exp%[1]s_idx ON %[1]s ( expires_at )",
+		"mysql":    "CREATE INDEX expires_idx ON %[1]s ( expires_at )",
+		"postgres": "CREATE INDEX %[1]s_expires_idx ON %[1]s ( expires_at )",
+	}},
+}
+
+const (
+	mysqlColumnsSQL = "SELECT COLUMN_NAME, DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION"
+	mysqlIndexesSQL = "SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX"
+
+	postgresColumnsSQL = "SELECT column_name, data_type FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position"
+	// information_schema has no view of indexes in postgres, so use the catalog
+	postgresIndexesSQL = "SELECT i.relname, ix.indisunique, a.attname FROM pg_class t JOIN pg_index ix ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey) WHERE t.relname = $1 AND t.relnamespace = current_schema()::regnamespace ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)"
+)
+
+// typeFamily loosely classifies a database column type so that equivalent
+// spellings across drivers and versions (e.g. MariaDB's JSON as LONGTEXT)
+// are not reported as drift.
+func typeFamily(driver, colType string) string {
+	t := strings.ToLower(colType)
+	switch {
+	case strings.Contains(t, "int") || t == "bigserial" || t == "serial":
+		if driver == "sqlite3" {
+			// sqlite stores created_at as nanoseconds
+			return "integer|timestamp"
+		}
+		return "integer"
+	case strings.Contains(t, "char") || strings.Contains(t, "text") || strings.Contains(t, "json"):
+		return "text"
+	case strings.Contains(t, "time") || strings.Contains(t, "date"):
+		return "timestamp"
+	default:
+		return t
+	}
+}
+
+func familyMatches(actual, expected string) bool {
+	for _, f := range strings.Split(actual, "|") {
+		if f == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe returns the columns and indexes of tblName. A table that does
+// not exist has no columns.
+func Describe(ctx context.Context, db *sql.DB, driver, tblName string) ([]Column, []Index, error) {
+	err := core.ValidateTableName(tblName)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch driver {
+	case "sqlite3":
+		return describeSQLite(ctx, db, tblName)
+	case "mysql":
+		return describeInformationSchema(ctx, db, tblName, mysqlColumnsSQL, mysqlIndexesSQL, true)
+	case "postgres":
+		return describeInformationSchema(ctx, db, tblName, postgresColumnsSQL, postgresIndexesSQL, false)
+	default:
+		return nil, nil, fmt.Errorf("unrecognized driver: '%s'", driver)
+	}
+}
+
+func describeSQLite(ctx context.Context, db *sql.DB, tblName string) ([]Column, []Index, error) {
+	var columns []Column
+
+	rows, err := db.QueryContext(ctx, "PRAGMA table_info("+sqlite.Quote(tblName)+")")
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var (
+			cid     int
+			col     Column
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		err = rows.Scan(&cid, &col.Name, &col.Type, &notNull, &dflt, &pk)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		columns = append(columns, col)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var indexes []Index
+
+	rows, err = db.QueryContext(ctx, "PRAGMA index_list("+sqlite.Quote(tblName)+")")
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var (
+			seq     int
+			idx     Index
+			unique  int
+			origin  string
+			partial int
+		)
+		err = rows.Scan(&seq, &idx.Name, &unique, &origin, &partial)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		idx.Unique = unique == 1
+		indexes = append(indexes, idx)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for i := range indexes {
+		rows, err = db.QueryContext(ctx, "PRAGMA index_info("+sqlite.Quote(indexes[i].Name)+")")
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var (
+				seqno int
+				cid   int
+				name  string
+			)
+			err = rows.Scan(&seqno, &cid, &name)
+			if err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			indexes[i].Columns = append(indexes[i].Columns, name)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return columns, indexes, nil
+}
+
+func describeInformationSchema(ctx context.Context, db *sql.DB, tblName, columnsSQL, indexesSQL string, nonUnique bool) ([]Column, []Index, error) {
+	var columns []Column
+
+	rows, err := db.QueryContext(ctx, columnsSQL, tblName)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var col Column
+		err = rows.Scan(&col.Name, &col.Type)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		columns = append(columns, col)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var indexes []Index
+
+	rows, err = db.QueryContext(ctx, indexesSQL, tblName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name   string
+			flag   bool
+			column string
+		)
+		err = rows.Scan(&name, &flag, &column)
+		if err != nil {
+			return nil, nil, err
+		}
+		// mysql reports NON_UNIQUE, postgres reports indisunique
+		unique := flag
+		if nonUnique {
+			unique = !flag
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, Index{Name: name, Unique: unique})
+		}
+		last := &indexes[len(indexes)-1]
+		last.Columns = append(last.Columns, column)
+	}
+
+	return columns, indexes, rows.Err()
+}
+
+func hasIndex(indexes []Index, want expectedIndex) bool {
+	for _, idx := range indexes {
+		if want.unique && !idx.Unique {
+			continue
+		}
+		if len(idx.Columns) < len(want.columns) {
+			continue
+		}
+		match := true
+		for i, col := range want.columns {
+			if !strings.EqualFold(idx.Columns[i], col) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify compares tblName on db with the expected cell schema for driver
+// and reports every difference found. A nil result means no drift.
+func Verify(ctx context.Context, db *sql.DB, driver, tblName string) ([]Drift, error) {
+	columns, indexes, err := Describe(ctx, db, driver, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		return []Drift{{
+			Table:   tblName,
+			Problem: "table is missing",
+			Fix:     "run tools/create_shard_schemas to create it",
+		}}, nil
+	}
+
+	var drift []Drift
+
+	actual := make(map[string]Column)
+	for _, col := range columns {
+		actual[strings.ToLower(col.Name)] = col
+	}
+
+	for _, want := range cellColumns {
+		col, ok := actual[want.name]
+		if !ok {
+			drift = append(drift, Drift{
+				Table:   tblName,
+				Problem: fmt.Sprintf("column %s is missing", want.name),
+				Fix:     fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tblName, want.name, want.ddl[driver]),
+			})
+			continue
+		}
+		if !familyMatches(typeFamily(driver, col.Type), want.family) {
+			drift = append(drift, Drift{
+				Table:   tblName,
+				Problem: fmt.Sprintf("column %s has type %s, expected %s", want.name, col.Type, want.ddl[driver]),
+				Fix:     fmt.Sprintf("migrate column %s to %s", want.name, want.ddl[driver]),
+			})
+		}
+	}
+
+	for _, want := range cellIndexes {
+		if !hasIndex(indexes, want) {
+			kind := "index"
+			if want.unique {
+				kind = "unique index"
+			}
+			drift = append(drift, Drift{
+				Table:   tblName,
+				Problem: fmt.Sprintf("%s on ( %s ) is missing", kind, strings.Join(want.columns, ", ")),
+				Fix:     fmt.Sprintf(want.create[driver], tblName),
+			})
+		}
+	}
+
+	pending, version, err := Pending(ctx, db, driver, tblName)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		drift = append(drift, Drift{
+			Table:   tblName,
+			Problem: fmt.Sprintf("schema version %d, %d migration(s) pending", version, len(pending)),
+			Fix:     "run tools/create_shard_schemas to apply them",
+		})
+	}
+
+	return drift, nil
+}
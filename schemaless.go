@@ -3,11 +3,14 @@ package schemaless
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/dgryski/go-metro"
 	jh "github.com/dgryski/go-shardedkv/choosers/jump"
 	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/registry"
+	"go.uber.org/zap"
 )
 
 // Storage is a key-value storage backend
@@ -18,6 +21,15 @@ type Storage interface {
 	// GetLatest returns the latest value for a given rowKey and columnKey, and a bool indicating if the key was present
 	GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error)
 
+	// History returns up to 'limit' versions of a given rowKey and columnKey, highest ref key first
+	History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error)
+
+	// GetLatestAsOf returns the latest value for a given rowKey and columnKey that was created at or before asOf
+	GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error)
+
+	// GetRowAsOf returns the latest value of every column of rowKey that was created at or before asOf
+	GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error)
+
 	// PartitionRead returns 'limit' cells after 'location' from shard 'shard_no'
 	PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error)
 
@@ -38,6 +50,10 @@ type Storage interface {
 // KVStore.
 type DataStore struct {
 	sources map[string]*core.KVStore
+	schemas *registry.Registry
+	indexes map[string][]Index
+	auditor PurgeAuditor
+	log     *zap.Logger
 	// no mutex is required at this level -- only in core
 	// mu sync.Mutex
 }
@@ -62,11 +78,18 @@ func hash64(b []byte) uint64 { return metro.Hash64(b, 0) }
 
 func (ds *DataStore) WithSources(tblName string, shards []core.Shard) *DataStore {
 	chooser := jh.New(hash64)
-	kv := core.New(chooser, shards)
+	kv := core.New(chooser, shards).WithLogger(ds.log)
 	ds.sources[tblName] = kv
 	return ds
 }
 
+// WithSchemaRegistry validates every Put against the schema registered
+// for its (table, column), if any.
+func (ds *DataStore) WithSchemaRegistry(r *registry.Registry) *DataStore {
+	ds.schemas = r
+	return ds
+}
+
 func (ds *DataStore) WithName(tblName string, bucketName string) *DataStore {
 	tbl, err := ds.getTable(tblName)
 	if err != nil {
@@ -79,7 +102,7 @@ func (ds *DataStore) WithName(tblName string, bucketName string) *DataStore {
 
 // New is an empty constructor for DataStore.
 func New() *DataStore {
-	return &DataStore{sources: make(map[string]*core.KVStore)}
+	return &DataStore{sources: make(map[string]*core.KVStore), indexes: make(map[string][]Index), log: zap.NewNop()}
 }
 
 func (ds *DataStore) getTable(tblName string) (*core.KVStore, error) {
@@ -103,20 +126,86 @@ func (ds *DataStore) Get(ctx context.Context, tblName, rowKey, columnKey string,
 		return models.Cell{}, false, err
 	}
 
-	return source.Get(ctx, tblName, rowKey, columnKey, refKey)
+	cell, found, err = source.Get(ctx, tblName, rowKey, columnKey, refKey)
+	if err != nil || !found || cell.IsTombstone() {
+		return models.Cell{}, false, err
+	}
+	return cell, true, nil
 }
 
-// GetLatest implements Storage.GetLatest()
+// GetLatest implements Storage.GetLatest(). A deleted column is not found.
 func (ds *DataStore) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
 	source, err := ds.getTable(tblName)
 	if err != nil {
 		return models.Cell{}, false, err
 	}
 
-	return source.GetLatest(ctx, tblName, rowKey, columnKey)
+	cell, found, err = source.GetLatest(ctx, tblName, rowKey, columnKey)
+	if err != nil || !found || cell.IsTombstone() {
+		return models.Cell{}, false, err
+	}
+	return cell, true, nil
+}
+
+// History implements Storage.History()
+func (ds *DataStore) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cells, _, err = source.History(ctx, tblName, rowKey, columnKey, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	cells = withoutTombstones(cells)
+	return cells, len(cells) > 0, nil
+}
+
+// HistoryAll returns up to limit versions of a column, highest ref key
+// first, from every shard that may hold them. Unlike History it includes
+// tombstones and versions that have expired but not yet been swept, which
+// still hold their ref keys.
+func (ds *DataStore) HistoryAll(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return source.HistoryAll(ctx, tblName, rowKey, columnKey, limit)
 }
 
-// PartitionRead implements Storage.PartitionRead()
+// GetLatestAsOf implements Storage.GetLatestAsOf()
+func (ds *DataStore) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return models.Cell{}, false, err
+	}
+
+	cell, found, err = source.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	if err != nil || !found || cell.IsTombstone() {
+		return models.Cell{}, false, err
+	}
+	return cell, true, nil
+}
+
+// GetRowAsOf implements Storage.GetRowAsOf()
+func (ds *DataStore) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cells, _, err = source.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	if err != nil {
+		return nil, false, err
+	}
+	cells = withoutTombstones(cells)
+	return cells, len(cells) > 0, nil
+}
+
+// PartitionRead implements Storage.PartitionRead(). Tombstones are
+// returned, so that readers following a partition see deletes.
 func (ds *DataStore) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
 
 	source, err := ds.getTable(tblName)
@@ -129,6 +218,13 @@ func (ds *DataStore) PartitionRead(ctx context.Context, tblName string, partitio
 
 // Put implements Storage.Put()
 func (ds *DataStore) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	if ds.schemas != nil {
+		err := ds.schemas.Validate(tblName, columnKey, body)
+		if err != nil {
+			return err
+		}
+	}
+
 	source, err := ds.getTable(tblName)
 	if err != nil {
 		return err
@@ -137,6 +233,25 @@ func (ds *DataStore) Put(ctx context.Context, tblName, rowKey, columnKey string,
 	return source.Put(ctx, tblName, rowKey, columnKey, refKey, body)
 }
 
+// PutWithExpiry is Put for a cell that expires at expiresAt: from then on
+// it is not read back, and an expiry.Sweeper deletes it. Every shard backend
+// must implement core.Expirer.
+func (ds *DataStore) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	if ds.schemas != nil {
+		err := ds.schemas.Validate(tblName, columnKey, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	source, err := ds.getTable(tblName)
+	if err != nil {
+		return err
+	}
+
+	return source.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
 // FindPartition implements Storage.FindPartition()
 func (ds *DataStore) FindPartition(tblName, rowKey string) (int, error) {
 	source, err := ds.getTable(tblName)
@@ -1,13 +1,24 @@
 package schemaless
 
 import (
+	"bytes"
 	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/registry"
+	"github.com/rbastic/go-schemaless/storage/middleware"
 	st "github.com/rbastic/go-schemaless/storage/sqlite"
 )
 
@@ -95,3 +106,480 @@ func TestSchemaless(t *testing.T) {
 	}
 
 }
+
+func TestSchemaRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	stor, err := st.New(tblName, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := registry.New()
+	_, err = schemas.Register(tblName, "BASE", `{"type": "object", "required": ["value"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := New().WithSources(tblName, []core.Shard{{Name: "shard0", Backend: stor}}).WithSchemaRegistry(schemas)
+	defer kv.Destroy(context.TODO())
+
+	err = kv.Put(context.TODO(), tblName, "test1", "BASE", 1, `{"value": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kv.Put(context.TODO(), tblName, "test1", "BASE", 2, `{"other": 1}`)
+	var verr *registry.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+
+	_, found, err := kv.Get(context.TODO(), tblName, "test1", "BASE", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected the invalid cell not to be written")
+	}
+
+	// columns without a schema are not validated
+	err = kv.Put(context.TODO(), tblName, "test1", "NOTES", 1, "free text")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteAndPurge(t *testing.T) {
+	ctx := context.TODO()
+	const indexTblName = "cell_base_driver"
+
+	var shards []core.Shard
+	for i := 0; i < 2; i++ {
+		label := "test_purge" + strconv.Itoa(i)
+		dir := t.TempDir()
+
+		stor, err := st.New(tblName, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = st.CreateTable(ctx, stor.GetDB(), indexTblName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, core.Shard{Name: label, Backend: stor})
+	}
+
+	var audit bytes.Buffer
+	kv := New().WithSources(tblName, shards).
+		WithIndex(tblName, Index{Table: indexTblName, Column: "BASE", SourceField: "driver"}).
+		WithPurgeAuditor(NewPurgeLog(&audit))
+	defer kv.Destroy(ctx)
+
+	put := func(tbl, rowKey string, refKey int64, body string) {
+		err := kv.Put(ctx, tbl, rowKey, "BASE", refKey, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(tblName, "trip1", 1, `{"driver": "d1"}`)
+	put(tblName, "trip1", 2, `{"driver": "d2"}`)
+	put(tblName, "trip2", 1, `{"driver": "d1"}`)
+	put(indexTblName, "d1", 1, `{"row_key": "trip1"}`)
+	put(indexTblName, "d2", 2, `{"row_key": "trip1"}`)
+	put(indexTblName, "d1", 2, `{"row_key": "trip2"}`)
+
+	err := kv.Delete(ctx, tblName, "trip1", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := kv.GetLatest(ctx, tblName, "trip1", "BASE")
+	if err != nil || found {
+		t.Errorf("expected a deleted column not to be found, got found=%v err=%v", found, err)
+	}
+	cells, _, err := kv.History(ctx, tblName, "trip1", "BASE", 10)
+	if err != nil || len(cells) != 2 {
+		t.Errorf("expected the earlier versions in history, got %v err=%v", cells, err)
+	}
+
+	// a later write undeletes the column
+	put(tblName, "trip2", 2, `{"driver": "d1"}`)
+	err = kv.Delete(ctx, tblName, "trip2", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(tblName, "trip2", 4, `{"driver": "d1"}`)
+	cell, found, err := kv.GetLatest(ctx, tblName, "trip2", "BASE")
+	if err != nil || !found || cell.RefKey != 4 {
+		t.Errorf("expected trip2 to be readable again, got %v found=%v err=%v", cell, found, err)
+	}
+
+	rec, err := kv.Purge(ctx, tblName, "trip1", "erasure request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Cells != 3 || rec.IndexCells != 2 {
+		t.Errorf("unexpected purge record %+v", rec)
+	}
+	cells, found, err = kv.History(ctx, tblName, "trip1", "BASE", 10)
+	if err != nil || found {
+		t.Errorf("expected no cells left, got %v err=%v", cells, err)
+	}
+
+	// trip2's index entry survives
+	cells, _, err = kv.History(ctx, indexTblName, "d1", "BASE", 10)
+	if err != nil || len(cells) != 1 || cells[0].RefKey != 2 {
+		t.Errorf("expected only trip2's index entry, got %v err=%v", cells, err)
+	}
+
+	var logged PurgeRecord
+	err = json.Unmarshal(audit.Bytes(), &logged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged.RowKey != "trip1" || logged.Reason != "erasure request" || logged.Cells != 3 {
+		t.Errorf("unexpected audit record %s", audit.String())
+	}
+
+	// the index entries of an expired version that hasn't been swept go too
+	err = kv.PutWithExpiry(ctx, tblName, "trip3", "BASE", 1, `{"driver": "d3"}`, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(tblName, "trip3", 2, `{"driver": "d4"}`)
+	put(indexTblName, "d3", 1, `{"row_key": "trip3"}`)
+	put(indexTblName, "d4", 2, `{"row_key": "trip3"}`)
+
+	rec, err = kv.Purge(ctx, tblName, "trip3", "erasure request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Cells != 2 || rec.IndexCells != 2 {
+		t.Errorf("expected the expired version's index entry purged, got %+v", rec)
+	}
+	cells, found, err = kv.History(ctx, indexTblName, "d3", "BASE", 10)
+	if err != nil || found {
+		t.Errorf("expected no index entry for d3 left, got %v err=%v", cells, err)
+	}
+}
+
+func TestReadReplicas(t *testing.T) {
+	dir := t.TempDir()
+
+	primary, err := st.New(tblName, dir+"/primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := st.New(tblName, dir+"/replica")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := New().WithSources(tblName, []core.Shard{{Name: "shard0", Backend: primary, Replicas: []core.Storage{replica}}})
+	defer kv.Destroy(context.TODO())
+
+	// the replica lags: it has version 1, the primary also has version 2
+	ctx := context.TODO()
+	for _, stor := range []*st.Storage{primary, replica} {
+		err = stor.Put(ctx, tblName, "trip1", "BASE", 1, `{"version": 1}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = kv.Put(ctx, tblName, "trip1", "BASE", 2, `{"version": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := replica.Get(ctx, tblName, "trip1", "BASE", 2)
+	if err != nil || found {
+		t.Fatalf("expected the write to go to the primary only, found=%v err=%v", found, err)
+	}
+
+	// a cell the replica is missing is read from the primary
+	cell, found, err := kv.Get(ctx, tblName, "trip1", "BASE", 2)
+	if err != nil || !found || cell.RefKey != 2 {
+		t.Errorf("expected Get to fall back to the primary, got %v found=%v err=%v", cell, found, err)
+	}
+
+	cell, _, err = kv.GetLatest(ctx, tblName, "trip1", "BASE")
+	if err != nil || cell.RefKey != 2 {
+		t.Errorf("expected a strong GetLatest to read the primary, got %v err=%v", cell, err)
+	}
+	cell, _, err = kv.GetLatest(core.WithConsistency(ctx, core.Eventual), tblName, "trip1", "BASE")
+	if err != nil || cell.RefKey != 1 {
+		t.Errorf("expected an eventual GetLatest to read the replica, got %v err=%v", cell, err)
+	}
+
+	cells, _, err := kv.PartitionRead(ctx, tblName, 0, "added_at", 0, 10)
+	if err != nil || len(cells) != 1 {
+		t.Errorf("expected PartitionRead to read the replica, got %v err=%v", cells, err)
+	}
+
+	// a failed replica falls back to the primary
+	replica.Destroy(ctx)
+	cells, _, err = kv.PartitionRead(ctx, tblName, 0, "added_at", 0, 10)
+	if err != nil || len(cells) != 2 {
+		t.Errorf("expected PartitionRead to fall back to the primary, got %v err=%v", cells, err)
+	}
+	cell, _, err = kv.GetLatest(core.WithConsistency(ctx, core.Eventual), tblName, "trip1", "BASE")
+	if err != nil || cell.RefKey != 2 {
+		t.Errorf("expected GetLatest to fall back to the primary, got %v err=%v", cell, err)
+	}
+}
+
+// unreachable is a shard that fails with a connection error while down.
+type unreachable struct {
+	*st.Storage
+	down bool
+}
+
+func (u *unreachable) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error) {
+	if u.down {
+		return models.Cell{}, false, driver.ErrBadConn
+	}
+	return u.Storage.Get(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+func (u *unreachable) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (models.Cell, bool, error) {
+	if u.down {
+		return models.Cell{}, false, driver.ErrBadConn
+	}
+	return u.Storage.GetLatest(ctx, tblName, rowKey, columnKey)
+}
+
+func (u *unreachable) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	if u.down {
+		return driver.ErrBadConn
+	}
+	return u.Storage.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	dir := t.TempDir()
+
+	stor, err := st.New(tblName, dir+"/shard0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer, err := st.New(tblName, dir+"/buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.Destroy(context.TODO())
+
+	shard := &unreachable{Storage: stor}
+	kv := New().WithSources(tblName, []core.Shard{{Name: "shard0", Backend: shard}}).WithBuffer(tblName, buffer)
+	defer kv.Destroy(context.TODO())
+
+	ctx := context.TODO()
+	err = kv.Put(ctx, tblName, "trip1", "BASE", 1, `{"version": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes to an unreachable shard are buffered, and read back
+	shard.down = true
+	err = kv.Put(ctx, tblName, "trip1", "BASE", 2, `{"version": 2}`)
+	if err != nil {
+		t.Fatalf("expected the write to be buffered, got %v", err)
+	}
+	cell, found, err := kv.GetLatest(ctx, tblName, "trip1", "BASE")
+	if err != nil || !found || cell.RefKey != 2 {
+		t.Errorf("expected GetLatest to read the buffer, got %v found=%v err=%v", cell, found, err)
+	}
+
+	// once the shard is back, reads merge it with the buffer
+	shard.down = false
+	cell, _, err = kv.GetLatest(ctx, tblName, "trip1", "BASE")
+	if err != nil || cell.RefKey != 2 {
+		t.Errorf("expected the buffered cell to be latest, got %v err=%v", cell, err)
+	}
+	cells, _, err := kv.History(ctx, tblName, "trip1", "BASE", 10)
+	if err != nil || len(cells) != 2 || cells[0].RefKey != 2 {
+		t.Errorf("expected History to merge both versions, got %v err=%v", cells, err)
+	}
+
+	// a replay already half done is finished, not duplicated
+	err = stor.Put(ctx, tblName, "trip1", "BASE", 2, `{"version": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = buffer.Put(ctx, tblName, "trip2", "BASE", 1, `{"version": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := kv.ReplayBuffer(ctx, tblName, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 cells replayed, got %d", n)
+	}
+	cells, _, err = buffer.PartitionRead(ctx, tblName, 0, "added_at", 0, 100)
+	if err != nil || len(cells) != 0 {
+		t.Errorf("expected the buffer to be drained, got %v err=%v", cells, err)
+	}
+	cell, found, err = stor.GetLatest(ctx, tblName, "trip2", "BASE")
+	if err != nil || !found || cell.RefKey != 1 {
+		t.Errorf("expected trip2 to be replayed to its shard, got %v found=%v err=%v", cell, found, err)
+	}
+
+	n, err = kv.ReplayBuffer(ctx, tblName, 100)
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing left to replay, got %d err=%v", n, err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.DeadlineExceeded, false},
+		{context.Canceled, false},
+		{fmt.Errorf("put: %w", context.DeadlineExceeded), false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, false},
+		{errors.New("syntax error"), false},
+		{refused, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{driver.ErrBadConn, true},
+		{&core.UnavailableError{Shard: "shard0"}, true},
+	} {
+		if got := core.IsConnectionError(tc.err); got != tc.want {
+			t.Errorf("IsConnectionError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+	if core.IsRetryable(context.DeadlineExceeded) {
+		t.Errorf("expected an expired deadline not to be retryable")
+	}
+}
+
+func TestReplayBufferSkipsUnreachableShards(t *testing.T) {
+	dir := t.TempDir()
+
+	var shards []core.Shard
+	var stors []*unreachable
+	for _, name := range []string{"shard0", "shard1"} {
+		stor, err := st.New(tblName, dir+"/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stors = append(stors, &unreachable{Storage: stor})
+		shards = append(shards, core.Shard{Name: name, Backend: stors[len(stors)-1]})
+	}
+	buffer, err := st.New(tblName, dir+"/buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.Destroy(context.TODO())
+
+	kv := New().WithSources(tblName, shards).WithBuffer(tblName, buffer)
+	defer kv.Destroy(context.TODO())
+
+	// buffer the rows of the unreachable shard0 first, then rows of shard1
+	ctx := context.TODO()
+	stors[0].down = true
+	var downRows, upRows []string
+	for i := 0; i < 20; i++ {
+		rowKey := "trip" + strconv.Itoa(i)
+		err = kv.Put(ctx, tblName, rowKey, "BASE", 1, `{"version": 1}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, buffered, err := buffer.GetLatest(ctx, tblName, rowKey, "BASE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buffered {
+			downRows = append(downRows, rowKey)
+		} else {
+			upRows = append(upRows, rowKey)
+		}
+	}
+	if len(downRows) < 3 || len(upRows) < 2 {
+		t.Skipf("rows not spread over both shards: %v %v", downRows, upRows)
+	}
+	for _, rowKey := range upRows {
+		err = buffer.Put(ctx, tblName, rowKey, "BASE", 2, `{"version": 2}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the cells of shard0 at the head of the buffer don't hold up shard1's
+	n, err := kv.ReplayBuffer(ctx, tblName, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 cells of the reachable shard replayed, got %d", n)
+	}
+
+	for n > 0 {
+		n, err = kv.ReplayBuffer(ctx, tblName, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cells, _, err := buffer.PartitionRead(ctx, tblName, 0, "added_at", 0, 100)
+	if err != nil || len(cells) != len(downRows) {
+		t.Errorf("expected only the %d cells of shard0 left buffered, got %v err=%v", len(downRows), cells, err)
+	}
+	for _, cell := range cells {
+		if cell.RefKey != 1 {
+			t.Errorf("expected shard1's cells replayed, found %v buffered", cell)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	dir := t.TempDir()
+
+	var shards []core.Shard
+	for _, name := range []string{"shard0", "shard1"} {
+		stor, err := st.New(tblName, dir+"/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, core.Shard{Name: name, Backend: stor})
+	}
+
+	var calls []string
+	record := func(name string) core.Middleware {
+		return middleware.Intercept(func(ctx context.Context, call middleware.Call, next func(ctx context.Context) error) error {
+			calls = append(calls, name+" "+call.Op)
+			return next(ctx)
+		})
+	}
+
+	// later middlewares wrap earlier ones, so the recorders see every call
+	kv := New().WithSources(tblName, shards).
+		UseShard(tblName, "shard0", middleware.ReadOnly()).
+		Use(tblName, record("outer"), record("inner"))
+	defer kv.Destroy(context.TODO())
+
+	ctx := context.TODO()
+	written, rejected := 0, 0
+	for i := 0; i < 20; i++ {
+		calls = nil
+		err := kv.Put(ctx, tblName, "trip"+strconv.Itoa(i), "BASE", 1, `{}`)
+		switch {
+		case err == nil:
+			written++
+		case errors.Is(err, middleware.ErrReadOnly):
+			rejected++
+		default:
+			t.Fatal(err)
+		}
+		if len(calls) != 2 || calls[0] != "outer Put" || calls[1] != "inner Put" {
+			t.Fatalf("expected both middlewares to see the Put in order, got %v", calls)
+		}
+	}
+	if written == 0 || rejected == 0 {
+		t.Errorf("expected only shard0 to be read-only, got %d written and %d rejected", written, rejected)
+	}
+}
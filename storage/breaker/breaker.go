@@ -0,0 +1,396 @@
+// Package breaker wraps a storage backend in a circuit breaker, so that a
+// shard that can't be reached fails fast instead of making every call wait
+// for the driver to time out.
+//
+// The breaker is closed while the shard is healthy. After a number of
+// consecutive connection failures (see core.IsConnectionError) or
+// timeouts it opens, and every call fails at once with a
+// core.UnavailableError naming the shard. Once the cooldown has passed it
+// is half-open: a single call, or health probe, is let through as a trial,
+// and closes the breaker again if it succeeds.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open fails every call without trying the shard.
+	Open
+	// HalfOpen lets a single trial call through.
+	HalfOpen
+)
+
+func (st State) String() string {
+	switch st {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(st))
+	}
+}
+
+// MarshalText encodes a State by name.
+func (st State) MarshalText() ([]byte, error) {
+	return []byte(st.String()), nil
+}
+
+// Health is a snapshot of a shard's circuit breaker.
+type Health struct {
+	Shard     string    `json:"shard"`
+	State     State     `json:"state"`
+	Failures  int       `json:"consecutive_failures"`
+	LastError string    `json:"last_error,omitempty"`
+	Since     time.Time `json:"since"` // when the breaker entered State
+}
+
+// Storage guards a backend with a circuit breaker.
+type Storage struct {
+	name      string
+	backend   core.Storage
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	lastErr  error
+	since    time.Time
+	trial    bool // a half-open trial call is in flight
+}
+
+// New wraps the backend of the shard called name in a breaker that opens
+// after 5 consecutive failures and tries the shard again after 30 seconds.
+func New(name string, backend core.Storage) *Storage {
+	return &Storage{
+		name:      name,
+		backend:   backend,
+		threshold: 5,
+		cooldown:  30 * time.Second,
+		now:       time.Now,
+		since:     time.Now(),
+	}
+}
+
+// WithThreshold sets how many consecutive failures open the breaker.
+func (s *Storage) WithThreshold(n int) *Storage {
+	s.threshold = n
+	return s
+}
+
+// WithCooldown sets how long the breaker stays open before a trial call.
+func (s *Storage) WithCooldown(d time.Duration) *Storage {
+	s.cooldown = d
+	return s
+}
+
+// WithClock sets the clock the cooldown is measured against.
+func (s *Storage) WithClock(now func() time.Time) *Storage {
+	s.now = now
+	s.since = now()
+	return s
+}
+
+// Health returns the current state of the breaker.
+func (s *Storage) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := Health{Shard: s.name, State: s.state, Failures: s.failures, Since: s.since}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
+	}
+	return h
+}
+
+// setState must be called with s.mu held.
+func (s *Storage) setState(st State) {
+	s.state = st
+	s.since = s.now()
+}
+
+// allow returns an UnavailableError if a call may not be tried now. A call
+// whose context is already done is not tried either, and does not count as
+// a failure: the shard never saw it.
+func (s *Storage) allow(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case Open:
+		if s.now().Sub(s.since) < s.cooldown {
+			return &core.UnavailableError{Shard: s.name, Err: s.lastErr}
+		}
+		s.setState(HalfOpen)
+		s.trial = true
+	case HalfOpen:
+		if s.trial {
+			return &core.UnavailableError{Shard: s.name, Err: s.lastErr}
+		}
+		s.trial = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a call made with ctx, and
+// returns err, as an UnavailableError if it was a connection failure.
+//
+// A deadline missed while the caller's ctx is still live, such as the
+// backend's per-operation timeout, counts as a failure too, but is returned
+// as it is: the shard may still have done what was asked. A call the caller
+// gave up on, with its own deadline or by canceling, counts for nothing.
+func (s *Storage) record(ctx context.Context, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trial = false
+	if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return err
+	}
+	timeout := errors.Is(err, context.DeadlineExceeded)
+	if !timeout && !core.IsConnectionError(err) {
+		s.failures = 0
+		if s.state != Closed {
+			s.setState(Closed)
+		}
+		return err
+	}
+
+	s.failures++
+	s.lastErr = err
+	if s.state == HalfOpen || (s.state == Closed && s.failures >= s.threshold) {
+		s.setState(Open)
+	}
+	if timeout {
+		return err
+	}
+	var uerr *core.UnavailableError
+	if errors.As(err, &uerr) {
+		return err
+	}
+	return &core.UnavailableError{Shard: s.name, Err: err}
+}
+
+// Probe checks the shard's connection, if its backend implements
+// core.Pinger, and counts the result like any other call. While the breaker
+// is open, the first probe after the cooldown is its trial call.
+func (s *Storage) Probe(ctx context.Context) error {
+	return s.probe(ctx, 0)
+}
+
+// probe is Probe with the ping allowed at most timeout, which counts as the
+// shard's failure if it runs out.
+func (s *Storage) probe(ctx context.Context, timeout time.Duration) error {
+	pinger, ok := s.backend.(core.Pinger)
+	if !ok {
+		return nil
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return err
+	}
+	pingCtx, cancel := core.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s.record(ctx, pinger.Ping(pingCtx))
+}
+
+// StartProbing probes the shard every interval, each probe allowed at most
+// an interval to complete, until ctx is done.
+func (s *Storage) StartProbing(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			s.probe(ctx, interval)
+		}
+	}()
+}
+
+func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cell, found, err = s.backend.Get(ctx, tblName, rowKey, columnKey, refKey)
+	return cell, found, s.record(ctx, err)
+}
+
+func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cell, found, err = s.backend.GetLatest(ctx, tblName, rowKey, columnKey)
+	return cell, found, s.record(ctx, err)
+}
+
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cells, found, err = s.backend.History(ctx, tblName, rowKey, columnKey, limit)
+	return cells, found, s.record(ctx, err)
+}
+
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cell, found, err = s.backend.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	return cell, found, s.record(ctx, err)
+}
+
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cells, found, err = s.backend.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	return cells, found, s.record(ctx, err)
+}
+
+func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cells, found, err = s.backend.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+	return cells, found, s.record(ctx, err)
+}
+
+func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	err := s.allow(ctx)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, s.backend.Put(ctx, tblName, rowKey, columnKey, refKey, body))
+}
+
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt))
+}
+
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error) {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := expirer.SweepExpired(ctx, tblName, now, limit)
+	return n, s.record(ctx, err)
+}
+
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (int64, error) {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := purger.Purge(ctx, tblName, rowKey)
+	return n, s.record(ctx, err)
+}
+
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey))
+}
+
+// Rewrite forwards to the backend's Rewrite, so that an encryption wrapper
+// around the breaker can re-encrypt cells.
+func (s *Storage) Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	rw, ok := s.backend.(interface {
+		Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error
+	})
+	if !ok {
+		return fmt.Errorf("backend does not support rewriting cells: %T", s.backend)
+	}
+	err := s.allow(ctx)
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, rw.Rewrite(ctx, tblName, rowKey, columnKey, refKey, body))
+}
+
+// Scan forwards to the backend's Scan, so that an encryption wrapper around
+// the breaker can re-encrypt cells.
+func (s *Storage) Scan(ctx context.Context, tblName string, addedAt int64, limit int) (cells []models.Cell, found bool, err error) {
+	sc, ok := s.backend.(interface {
+		Scan(ctx context.Context, tblName string, addedAt int64, limit int) ([]models.Cell, bool, error)
+	})
+	if !ok {
+		return nil, false, fmt.Errorf("backend does not support scanning cells: %T", s.backend)
+	}
+	err = s.allow(ctx)
+	if err != nil {
+		return
+	}
+	cells, found, err = sc.Scan(ctx, tblName, addedAt, limit)
+	return cells, found, s.record(ctx, err)
+}
+
+// Ping checks the shard like Probe, so that breakers can be stacked.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.Probe(ctx)
+}
+
+func (s *Storage) FindPartition(tblName, rowKey string) int {
+	return s.backend.FindPartition(tblName, rowKey)
+}
+
+// ResetConnection is always passed through, since it may be what brings
+// the shard back.
+func (s *Storage) ResetConnection(ctx context.Context, key string) error {
+	return s.backend.ResetConnection(ctx, key)
+}
+
+func (s *Storage) Destroy(ctx context.Context) error {
+	return s.backend.Destroy(ctx)
+}
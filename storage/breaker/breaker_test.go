@@ -0,0 +1,141 @@
+package breaker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+// flaky fails every read and ping with a connection error while down. A
+// slow read blocks until its deadline, or the timeout it sets itself.
+type flaky struct {
+	*sqlite.Storage
+	down    bool
+	slow    bool
+	timeout time.Duration
+	calls   int
+}
+
+func (f *flaky) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	f.calls++
+	if f.down {
+		return cell, false, driver.ErrBadConn
+	}
+	if f.slow {
+		ctx, cancel := core.WithTimeout(ctx, f.timeout)
+		defer cancel()
+		<-ctx.Done()
+		return cell, false, ctx.Err()
+	}
+	return f.Storage.GetLatest(ctx, tblName, rowKey, columnKey)
+}
+
+func (f *flaky) Ping(ctx context.Context) error {
+	f.calls++
+	if f.down {
+		return driver.ErrBadConn
+	}
+	return f.Storage.Ping(ctx)
+}
+
+func TestBreakerSQLite(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	storagetest.StorageTest(t, New("shard0", backend))
+}
+
+func TestBreaker(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	now := time.Now()
+	shard := &flaky{Storage: backend}
+	s := New("shard0", shard).WithThreshold(3).WithCooldown(time.Minute).WithClock(func() time.Time { return now })
+
+	ctx := context.TODO()
+	shard.down = true
+	for i := 0; i < 3; i++ {
+		_, _, err := s.GetLatest(ctx, "cell", "trip1", "BASE")
+		if !errors.Is(err, core.ErrShardUnavailable) || !errors.Is(err, driver.ErrBadConn) {
+			t.Fatalf("expected an unavailable shard, got %v", err)
+		}
+	}
+	if h := s.Health(); h.State != Open || h.Failures != 3 {
+		t.Fatalf("expected the breaker to open, got %+v", h)
+	}
+
+	// an open breaker fails fast, naming the shard
+	_, _, err := s.GetLatest(ctx, "cell", "trip1", "BASE")
+	var uerr *core.UnavailableError
+	if !errors.As(err, &uerr) || uerr.Shard != "shard0" || !strings.Contains(err.Error(), "shard0") {
+		t.Errorf("expected an UnavailableError naming shard0, got %v", err)
+	}
+	if shard.calls != 3 {
+		t.Errorf("expected the open breaker not to call the shard, got %d calls", shard.calls)
+	}
+
+	// after the cooldown a failed trial opens it again
+	now = now.Add(2 * time.Minute)
+	err = s.Probe(ctx)
+	if !errors.Is(err, core.ErrShardUnavailable) || s.Health().State != Open {
+		t.Errorf("expected a failed probe to reopen the breaker, got %v %+v", err, s.Health())
+	}
+
+	// and a successful one closes it
+	now = now.Add(2 * time.Minute)
+	shard.down = false
+	err = s.Probe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := s.Health(); h.State != Closed || h.Failures != 0 {
+		t.Errorf("expected the breaker to close, got %+v", h)
+	}
+
+	// errors that aren't the shard's fault don't count
+	_, _, err = s.PartitionRead(ctx, "cell", 0, "nowhere", 0, 10)
+	if err == nil || errors.Is(err, core.ErrShardUnavailable) || s.Health().Failures != 0 {
+		t.Errorf("expected a plain error that leaves the breaker alone, got %v %+v", err, s.Health())
+	}
+	// nor do calls whose context was done before they were made
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	shard.down, shard.calls = true, 0
+	_, _, err = s.GetLatest(canceled, "cell", "trip1", "BASE")
+	if !errors.Is(err, context.Canceled) || shard.calls != 0 || s.Health().Failures != 0 {
+		t.Errorf("expected a canceled call not to be made, got %v after %d calls %+v", err, shard.calls, s.Health())
+	}
+
+	// a caller's own deadline running out isn't the shard's fault
+	shard.down, shard.slow = false, true
+	for i := 0; i < 3; i++ {
+		short, cancel := context.WithTimeout(ctx, time.Millisecond)
+		_, _, err = s.GetLatest(short, "cell", "trip1", "BASE")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, core.ErrShardUnavailable) {
+			t.Errorf("expected the caller's deadline, got %v", err)
+		}
+	}
+	if h := s.Health(); h.State != Closed || h.Failures != 0 {
+		t.Errorf("expected the caller's deadlines not to count, got %+v", h)
+	}
+
+	// but a timeout beneath the breaker is, while the caller still waits
+	shard.timeout = time.Millisecond
+	for i := 0; i < 3; i++ {
+		_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+		if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, core.ErrShardUnavailable) {
+			t.Errorf("expected the shard's timeout, got %v", err)
+		}
+	}
+	if h := s.Health(); h.State != Open || h.Failures != 3 {
+		t.Errorf("expected the shard's timeouts to open the breaker, got %+v", h)
+	}
+}
@@ -0,0 +1,229 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codec compresses and decompresses cell bodies. Every codec has a unique
+// name that is recorded with each body it compresses, so that reads can
+// pick the right codec without consulting any configuration.
+type Codec interface {
+	// Name identifies the codec in compressed bodies and in configuration
+	// files, e.g. "lz4".
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	// ErrUnknownCodec is returned when a body names a codec that is not
+	// registered.
+	ErrUnknownCodec = errors.New("unknown compression codec")
+
+	// LZ4 compresses bodies in the LZ4 frame format.
+	LZ4 Codec = lz4Codec{}
+	// Zstd compresses bodies with Zstandard.
+	Zstd Codec = zstdCodec{}
+	// Snappy compresses bodies in the Snappy block format.
+	Snappy Codec = snappyCodec{}
+
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{LZ4, Zstd, Snappy} {
+		if err := Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register makes a codec available for decoding bodies, and by name for
+// configuration.
+func Register(c Codec) error {
+	if c.Name() == "" {
+		return errors.New("codec name is empty")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[c.Name()]; ok {
+		return fmt.Errorf("codec %s already registered", c.Name())
+	}
+	registry[c.Name()] = c
+	return nil
+}
+
+// Lookup returns the registered codec with the given name.
+func Lookup(name string) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	c, ok := registry[name]
+	return c, ok
+}
+
+// CodecKey is the reserved key naming the codec of a compressed body.
+// Bodies written through the compressing Storage must not use it.
+const CodecKey = "_schemaless_z"
+
+// envelope is a compressed body: the codec name and the base64 encoded
+// payload.
+type envelope struct {
+	Codec string `json:"_schemaless_z"`
+	Data  string `json:"d"`
+}
+
+// Encode compresses body with c and returns a JSON envelope
+//
+//	{"_schemaless_z":"lz4","d":"<base64 encoded payload>"}
+//
+// which can be stored in text columns and in the JSON body columns of the
+// mysql and postgres schemas alike.
+func Encode(c Codec, body string) (string, error) {
+	compressed, err := c.Compress([]byte(body))
+	if err != nil {
+		return "", err
+	}
+	env, err := json.Marshal(envelope{Codec: c.Name(), Data: base64.StdEncoding.EncodeToString(compressed)})
+	if err != nil {
+		return "", err
+	}
+	return string(env), nil
+}
+
+// parseEnvelope reports whether body is a compressed envelope: a JSON
+// object with exactly the keys CodecKey and "d", both strings, the latter
+// valid base64. The JSON columns of mysql may reorder keys and add whitespace, so
+// it is parsed rather than matched.
+func parseEnvelope(body string) (envelope, []byte, bool) {
+	var env envelope
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") || !strings.Contains(body, "\""+CodecKey+"\"") {
+		return env, nil, false
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal([]byte(body), &fields)
+	if err != nil || len(fields) != 2 || fields[CodecKey] == nil || fields["d"] == nil {
+		return env, nil, false
+	}
+	err = json.Unmarshal([]byte(body), &env)
+	if err != nil || env.Codec == "" {
+		return env, nil, false
+	}
+	compressed, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return env, nil, false
+	}
+	return env, compressed, true
+}
+
+// IsEncoded reports whether body is a compressed envelope.
+func IsEncoded(body string) bool {
+	_, _, ok := parseEnvelope(body)
+	return ok
+}
+
+// Decode reverses Encode. Bodies that are not envelopes were written before
+// compression was enabled and are returned unchanged.
+func Decode(body string) (string, error) {
+	env, compressed, ok := parseEnvelope(body)
+	if !ok {
+		return body, nil
+	}
+
+	c, ok := Lookup(env.Codec)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownCodec, env.Codec)
+	}
+
+	decompressed, err := c.Decompress(compressed)
+	if err != nil {
+		return "", err
+	}
+	return string(decompressed), nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return "lz4" }
+
+func (lz4Codec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := lz4.NewWriter(&buf)
+	_, err := zw.Write(src)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lz4Codec) Decompress(src []byte) ([]byte, error) {
+	return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(src)))
+}
+
+// zstd encoders and decoders are safe for concurrent use with EncodeAll and
+// DecodeAll, and expensive to create, so share one of each.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() {
+	zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	if zstdErr != nil {
+		return
+	}
+	zstdDecoder, zstdErr = zstd.NewReader(nil)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) Compress(src []byte) ([]byte, error) {
+	zstdOnce.Do(initZstd)
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdEncoder.EncodeAll(src, nil), nil
+}
+
+func (zstdCodec) Decompress(src []byte) ([]byte, error) {
+	zstdOnce.Do(initZstd)
+	if zstdErr != nil {
+		return nil, zstdErr
+	}
+	return zstdDecoder.DecodeAll(src, nil)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCodec) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
@@ -0,0 +1,219 @@
+// Package compression wraps a storage backend so that cell bodies are
+// compressed on the way in and transparently decompressed on every read.
+//
+// Uber's Schemaless stores JSON inside MessagePack'd LZ4 blobs; we store
+// JSON, so a compressed body is a small envelope naming the codec and
+// holding the base64 encoded payload:
+//
+//	{"_schemaless_z":"zstd","d":"KLUv/QBYbQAA..."}
+//
+// The codec's key is reserved, so that an application's own JSON bodies are
+// never mistaken for envelopes.
+// The envelope fits the JSON body columns of the mysql and postgres schemas
+// as well as sqlite's text column. Cells written before compression was
+// enabled are not envelopes and are read back unchanged, so compression can
+// be turned on for an existing table at any time.
+package compression
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Storage compresses the bodies of configured tables before handing them to
+// the wrapped backend.
+type Storage struct {
+	backend core.Storage
+
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// New wraps backend. No table is compressed until WithTableCodec is called,
+// but compressed cells are always decompressed on read.
+func New(backend core.Storage) *Storage {
+	return &Storage{backend: backend, codecs: make(map[string]Codec)}
+}
+
+// WithTableCodec compresses new cells written to tblName with c. A nil
+// codec turns compression off for the table again.
+func (s *Storage) WithTableCodec(tblName string, c Codec) *Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c == nil {
+		delete(s.codecs, tblName)
+	} else {
+		s.codecs[tblName] = c
+	}
+	return s
+}
+
+func (s *Storage) tableCodec(tblName string) (Codec, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.codecs[tblName]
+	return c, ok
+}
+
+func decodeCell(tblName string, cell *models.Cell) error {
+	body, err := Decode(cell.Body)
+	if err != nil {
+		return fmt.Errorf("decompressing %s (%s, %s, %d): %w", tblName, cell.RowKey, cell.ColumnName, cell.RefKey, err)
+	}
+	cell.Body = body
+	return nil
+}
+
+func decodeCells(tblName string, cells []models.Cell) error {
+	for i := range cells {
+		err := decodeCell(tblName, &cells[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements Storage.Get()
+func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.Get(ctx, tblName, rowKey, columnKey, refKey)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = decodeCell(tblName, &cell)
+	return cell, found, err
+}
+
+// GetLatest implements Storage.GetLatest()
+func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.GetLatest(ctx, tblName, rowKey, columnKey)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = decodeCell(tblName, &cell)
+	return cell, found, err
+}
+
+// History implements Storage.History()
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.History(ctx, tblName, rowKey, columnKey, limit)
+	if err != nil {
+		return cells, found, err
+	}
+	err = decodeCells(tblName, cells)
+	return cells, found, err
+}
+
+// GetLatestAsOf implements Storage.GetLatestAsOf()
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = decodeCell(tblName, &cell)
+	return cell, found, err
+}
+
+// GetRowAsOf implements Storage.GetRowAsOf()
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	if err != nil {
+		return cells, found, err
+	}
+	err = decodeCells(tblName, cells)
+	return cells, found, err
+}
+
+// PartitionRead implements Storage.PartitionRead()
+func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+	if err != nil {
+		return cells, found, err
+	}
+	err = decodeCells(tblName, cells)
+	return cells, found, err
+}
+
+// compress encodes body with tblName's codec, if it has one.
+func (s *Storage) compress(tblName, rowKey, columnKey string, refKey int64, body string) (string, error) {
+	c, ok := s.tableCodec(tblName)
+	if !ok {
+		return body, nil
+	}
+	encoded, err := Encode(c, body)
+	if err != nil {
+		return "", fmt.Errorf("compressing %s (%s, %s, %d): %w", tblName, rowKey, columnKey, refKey, err)
+	}
+	return encoded, nil
+}
+
+// Put implements Storage.Put()
+func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	body, err := s.compress(tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	return s.backend.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+// PutWithExpiry implements core.Expirer for backends that do.
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	body, err := s.compress(tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+// SweepExpired implements core.Expirer for backends that do.
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error) {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	return expirer.SweepExpired(ctx, tblName, now, limit)
+}
+
+// Purge implements core.Purger for backends that do.
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (int64, error) {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return purger.Purge(ctx, tblName, rowKey)
+}
+
+// PurgeCell implements core.Purger for backends that do.
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+// FindPartition implements Storage.FindPartition()
+func (s *Storage) FindPartition(tblName, rowKey string) int {
+	return s.backend.FindPartition(tblName, rowKey)
+}
+
+// ResetConnection implements Storage.ResetConnection()
+func (s *Storage) ResetConnection(ctx context.Context, key string) error {
+	return s.backend.ResetConnection(ctx, key)
+}
+
+// Destroy implements Storage.Destroy()
+func (s *Storage) Destroy(ctx context.Context) error {
+	return s.backend.Destroy(ctx)
+}
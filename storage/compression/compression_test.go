@@ -0,0 +1,118 @@
+package compression
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+func TestCodecs(t *testing.T) {
+	body := "{\"value\": \"" + strings.Repeat("The shaved yak drank from the bitter well. ", 20) + "\"}"
+
+	for _, c := range []Codec{LZ4, Zstd, Snappy} {
+		encoded, err := Encode(c, body)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+		if env, _, ok := parseEnvelope(encoded); !ok || env.Codec != c.Name() {
+			t.Errorf("%s: expected an envelope naming the codec, got %q", c.Name(), encoded)
+		}
+
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", c.Name(), err)
+		}
+		if decoded != body {
+			t.Errorf("%s: round trip mismatch: %q", c.Name(), decoded)
+		}
+	}
+
+	_, err := Decode("{\"_schemaless_z\": \"brotli\", \"d\": \"AAAA\"}")
+	if err == nil {
+		t.Error("expected an error decoding an unregistered codec")
+	}
+
+	err = Register(Snappy)
+	if err == nil {
+		t.Error("expected an error registering a duplicate codec")
+	}
+
+	// legacy bodies, including ones that look a little like envelopes
+	for _, legacy := range []string{
+		"",
+		"\n{\"value\": 1}",
+		"\t\r\n{\"_schemaless_z\": \"lz4\"}",
+		"{\"_schemaless_z\": \"lz4\", \"d\": \"not base64!\"}",
+		"{\"_schemaless_z\": \"lz4\", \"d\": \"AAAA\", \"value\": 1}",
+		"{\"_schemaless_z\": 1, \"d\": \"AAAA\"}",
+		// an application's own body with the keys of the old envelope
+		"{\"z\": \"lz4\", \"d\": \"AAAA\"}",
+		"plain text",
+	} {
+		if IsEncoded(legacy) {
+			t.Errorf("expected %q to be read as a legacy body", legacy)
+		}
+		decoded, err := Decode(legacy)
+		if err != nil {
+			t.Errorf("decoding legacy body %q: %s", legacy, err)
+		} else if decoded != legacy {
+			t.Errorf("expected legacy body %q unchanged, got %q", legacy, decoded)
+		}
+	}
+}
+
+func TestCompressedSQLite(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	// a cell written before compression was enabled
+	ctx := context.TODO()
+	legacy := "{\"value\": \"uncompressed\"}"
+	err := backend.Put(ctx, "cell", "legacy", "BASE", 1, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(backend).WithTableCodec("cell", Zstd)
+
+	cell, found, err := m.GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || cell.Body != legacy {
+		t.Errorf("expected legacy cell %q, got %q", legacy, cell.Body)
+	}
+
+	err = m.Put(ctx, "cell", "legacy", "BASE", 2, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := backend.GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, _, ok := parseEnvelope(raw.Body); !ok || env.Codec != Zstd.Name() {
+		t.Errorf("expected a zstd compressed body, got %q", raw.Body)
+	}
+	if !json.Valid([]byte(raw.Body)) {
+		t.Errorf("expected a compressed body that is valid JSON, got %q", raw.Body)
+	}
+
+	// a legacy body with leading whitespace is not mistaken for compressed
+	padded := "\n" + legacy
+	err = backend.Put(ctx, "cell", "padded", "BASE", 1, padded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell, _, err = m.GetLatest(ctx, "cell", "padded", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Body != padded {
+		t.Errorf("expected legacy cell %q, got %q", padded, cell.Body)
+	}
+
+	storagetest.StorageTest(t, m)
+}
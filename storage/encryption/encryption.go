@@ -0,0 +1,593 @@
+// Package encryption wraps a storage backend so that cell bodies are
+// encrypted at rest, independently of the database.
+//
+// Bodies are encrypted with AES-GCM under a per-table data key. Data keys
+// are themselves encrypted ("wrapped") by a master key from a KeyProvider
+// and stored, wrapped, as ordinary cells under the reserved row
+// KeyRingRowKey of the same table on each shard. Every encrypted body
+// records the version of the data key it was sealed with:
+//
+//	{"enc":"AES256-GCM","kid":2,"ct":"<base64 nonce and ciphertext>"}
+//
+// The envelope is JSON so it can be stored in the JSON body columns of the
+// mysql and postgres schemas. Row keys, column names and ref keys stay in
+// clear so that routing and indexes work as before, and are bound to the
+// ciphertext so a body cannot be copied into another cell. Bodies that are
+// not envelopes were written before encryption was enabled and are returned
+// unchanged.
+//
+// When combined with compression, compress first: wrap the encrypting
+// Storage in the compressing one, since ciphertext does not compress.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+const (
+	// KeyRingRowKey is the reserved row holding a table's wrapped data keys.
+	KeyRingRowKey = "_schemaless_data_keys"
+
+	dataKeyColumn = "DATA_KEY"
+	algorithm     = "AES256-GCM"
+	keySize       = 32
+
+	// the most data key versions read back for a table
+	maxDataKeys = 1 << 16
+
+	// DefaultKeyringTTL is how long a table's data keys are cached; see
+	// WithKeyringTTL.
+	DefaultKeyringTTL = time.Minute
+)
+
+// ErrReservedRowKey is returned when writing to or purging KeyRingRowKey
+// through the encrypting Storage.
+var ErrReservedRowKey = errors.New("row key " + KeyRingRowKey + " is reserved for data keys")
+
+type envelope struct {
+	Enc        string `json:"enc"`
+	KeyID      int64  `json:"kid"`
+	Ciphertext string `json:"ct"`
+}
+
+type wrappedKey struct {
+	MasterKeyID string `json:"master_key_id"`
+	WrappedKey  string `json:"wrapped_key"`
+}
+
+type dataKey struct {
+	id          int64
+	masterKeyID string
+	aead        cipher.AEAD
+}
+
+// keyring holds the data keys of one table. Only the current key is
+// unwrapped when the keyring is read; older keys are unwrapped the first
+// time a cell sealed with them is read, so that a master key can be retired
+// once no cell needs the data keys it wrapped. The set of keys is never
+// modified once built; rotation replaces the keyring.
+type keyring struct {
+	wrapped map[int64]wrappedKey
+	current *dataKey
+	loaded  time.Time
+
+	mu   sync.Mutex
+	keys map[int64]*dataKey
+}
+
+// Storage encrypts the bodies of configured tables before handing them to
+// the wrapped backend.
+type Storage struct {
+	backend  core.Storage
+	provider KeyProvider
+
+	// ttl bounds how long a keyring is sealed with; see WithKeyringTTL
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	tables   map[string]bool
+	keyrings map[string]*keyring
+}
+
+// New wraps backend, using master keys from provider. No table is
+// encrypted until WithTable is called, but encrypted cells are always
+// decrypted on read.
+func New(backend core.Storage, provider KeyProvider) *Storage {
+	return &Storage{
+		backend:  backend,
+		provider: provider,
+		ttl:      DefaultKeyringTTL,
+		tables:   make(map[string]bool),
+		keyrings: make(map[string]*keyring),
+	}
+}
+
+// WithKeyringTTL sets how long a table's data keys are cached before new
+// cells are sealed with keys read again from the table. It bounds how long
+// this Storage keeps using the old data key after another process, sharing
+// the shard, rotates it; Reencrypt waits as long before it finishes, to
+// reach the cells sealed in the meantime. Zero reads the keys for every
+// write.
+func (s *Storage) WithKeyringTTL(ttl time.Duration) *Storage {
+	s.ttl = ttl
+	return s
+}
+
+// WithTable encrypts new cells written to tblName.
+func (s *Storage) WithTable(tblName string) *Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tables[tblName] = true
+	return s
+}
+
+func (s *Storage) encrypted(tblName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tables[tblName]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the base64 encoded nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, aad)), nil
+}
+
+func open(aead cipher.AEAD, sealed string, aad []byte) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], aad)
+}
+
+func cellAAD(tblName, rowKey, columnKey string, refKey int64) []byte {
+	return []byte(tblName + "\x00" + rowKey + "\x00" + columnKey + "\x00" + strconv.FormatInt(refKey, 10))
+}
+
+func dataKeyAAD(tblName string, id int64) []byte {
+	return []byte(tblName + "\x00" + strconv.FormatInt(id, 10))
+}
+
+// parseEnvelope reports whether body is an encrypted envelope. The JSON
+// columns of mysql may reorder keys and add whitespace, so it is parsed
+// rather than matched.
+func parseEnvelope(body string) (envelope, bool) {
+	var env envelope
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") || !strings.Contains(body, "\"enc\"") {
+		return env, false
+	}
+	err := json.Unmarshal([]byte(body), &env)
+	if err != nil || env.Enc != algorithm || env.Ciphertext == "" {
+		return env, false
+	}
+	return env, true
+}
+
+// newDataKey generates data key version id for tblName, wraps it with the
+// current master key and stores it.
+func (s *Storage) newDataKey(ctx context.Context, tblName string, id int64) (*dataKey, error) {
+	masterKeyID, masterKey, err := s.provider.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wrapper, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, keySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(wrapper, key, dataKeyAAD(tblName, id))
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(wrappedKey{MasterKeyID: masterKeyID, WrappedKey: wrapped})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.backend.Put(ctx, tblName, KeyRingRowKey, dataKeyColumn, id, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{id: id, masterKeyID: masterKeyID, aead: aead}, nil
+}
+
+func (s *Storage) unwrapDataKey(ctx context.Context, tblName string, id int64, wk wrappedKey) (*dataKey, error) {
+	masterKey, err := s.provider.Key(ctx, wk.MasterKeyID)
+	if err != nil {
+		return nil, err
+	}
+	wrapper, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := open(wrapper, wk.WrappedKey, dataKeyAAD(tblName, id))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key %d of %s: %w", id, tblName, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{id: id, masterKeyID: wk.MasterKeyID, aead: aead}, nil
+}
+
+// readKeyring reads the wrapped data keys of tblName and unwraps the
+// current one.
+func (s *Storage) readKeyring(ctx context.Context, tblName string) (*keyring, error) {
+	cells, _, err := s.backend.History(ctx, tblName, KeyRingRowKey, dataKeyColumn, maxDataKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := &keyring{
+		wrapped: make(map[int64]wrappedKey, len(cells)),
+		loaded:  time.Now(),
+		keys:    make(map[int64]*dataKey, 1),
+	}
+	var currentID int64
+	for _, cell := range cells {
+		var wk wrappedKey
+		err = json.Unmarshal([]byte(cell.Body), &wk)
+		if err != nil {
+			return nil, fmt.Errorf("data key %d of %s: %w", cell.RefKey, tblName, err)
+		}
+		kr.wrapped[cell.RefKey] = wk
+		if cell.RefKey > currentID {
+			currentID = cell.RefKey
+		}
+	}
+
+	if currentID != 0 {
+		kr.current, err = s.unwrapDataKey(ctx, tblName, currentID, kr.wrapped[currentID])
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[currentID] = kr.current
+	}
+	return kr, nil
+}
+
+// key returns data key version id, unwrapping it on first use. found is
+// false if the keyring has no such version.
+func (kr *keyring) key(ctx context.Context, s *Storage, tblName string, id int64) (dk *dataKey, found bool, err error) {
+	kr.mu.Lock()
+	dk, ok := kr.keys[id]
+	kr.mu.Unlock()
+	if ok {
+		return dk, true, nil
+	}
+
+	wk, ok := kr.wrapped[id]
+	if !ok {
+		return nil, false, nil
+	}
+	dk, err = s.unwrapDataKey(ctx, tblName, id, wk)
+	if err != nil {
+		return nil, true, err
+	}
+
+	kr.mu.Lock()
+	kr.keys[id] = dk
+	kr.mu.Unlock()
+	return dk, true, nil
+}
+
+// loadKeyring reads the data keys of tblName and caches them. If create is
+// set, it creates the first data key when the table has none. Reads never
+// create one: they may be served by a replica the key has yet to reach.
+func (s *Storage) loadKeyring(ctx context.Context, tblName string, create bool) (*keyring, error) {
+	kr, err := s.readKeyring(ctx, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	if kr.current == nil {
+		if !create {
+			return kr, nil
+		}
+		dk, err := s.newDataKey(ctx, tblName, 1)
+		if err != nil {
+			// another writer may have created it first
+			kr, err = s.readKeyring(ctx, tblName)
+			if err != nil {
+				return nil, err
+			}
+			if kr.current == nil {
+				return nil, fmt.Errorf("no data key for %s", tblName)
+			}
+		} else {
+			kr.keys[dk.id] = dk
+			kr.current = dk
+		}
+	}
+
+	s.mu.Lock()
+	s.keyrings[tblName] = kr
+	s.mu.Unlock()
+	return kr, nil
+}
+
+// keyring returns the keyring new cells of tblName are sealed with, read
+// again once it is older than the Storage's ttl.
+func (s *Storage) keyring(ctx context.Context, tblName string) (*keyring, error) {
+	s.mu.RLock()
+	kr, ok := s.keyrings[tblName]
+	s.mu.RUnlock()
+	if ok && time.Since(kr.loaded) < s.ttl {
+		return kr, nil
+	}
+
+	// we avoid holding the lock during a call to a storage engine
+	return s.loadKeyring(ctx, tblName, true)
+}
+
+// dataKey returns data key version id of tblName, re-reading the keyring
+// once in case another writer has rotated since it was loaded.
+func (s *Storage) dataKey(ctx context.Context, tblName string, id int64) (*dataKey, error) {
+	s.mu.RLock()
+	kr, ok := s.keyrings[tblName]
+	s.mu.RUnlock()
+	if ok {
+		dk, found, err := kr.key(ctx, s, tblName, id)
+		if found {
+			return dk, err
+		}
+	}
+
+	kr, err := s.loadKeyring(ctx, tblName, false)
+	if err != nil {
+		return nil, err
+	}
+	dk, found, err := kr.key(ctx, s, tblName, id)
+	if found {
+		return dk, err
+	}
+	return nil, fmt.Errorf("data key %d of %s not found", id, tblName)
+}
+
+func (s *Storage) encrypt(dk *dataKey, tblName, rowKey, columnKey string, refKey int64, body string) (string, error) {
+	sealed, err := seal(dk.aead, []byte(body), cellAAD(tblName, rowKey, columnKey, refKey))
+	if err != nil {
+		return "", err
+	}
+	env, err := json.Marshal(envelope{Enc: algorithm, KeyID: dk.id, Ciphertext: sealed})
+	if err != nil {
+		return "", err
+	}
+	return string(env), nil
+}
+
+func (s *Storage) decryptCell(ctx context.Context, tblName string, cell *models.Cell) error {
+	env, ok := parseEnvelope(cell.Body)
+	if !ok {
+		return nil
+	}
+
+	dk, err := s.dataKey(ctx, tblName, env.KeyID)
+	if err == nil {
+		var plaintext []byte
+		plaintext, err = open(dk.aead, env.Ciphertext, cellAAD(tblName, cell.RowKey, cell.ColumnName, cell.RefKey))
+		if err == nil {
+			cell.Body = string(plaintext)
+			return nil
+		}
+	}
+	return fmt.Errorf("decrypting %s (%s, %s, %d): %w", tblName, cell.RowKey, cell.ColumnName, cell.RefKey, err)
+}
+
+func (s *Storage) decryptCells(ctx context.Context, tblName string, cells []models.Cell) error {
+	for i := range cells {
+		err := s.decryptCell(ctx, tblName, &cells[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements Storage.Get()
+func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.Get(ctx, tblName, rowKey, columnKey, refKey)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = s.decryptCell(ctx, tblName, &cell)
+	return cell, found, err
+}
+
+// GetLatest implements Storage.GetLatest()
+func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.GetLatest(ctx, tblName, rowKey, columnKey)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = s.decryptCell(ctx, tblName, &cell)
+	return cell, found, err
+}
+
+// History implements Storage.History()
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.History(ctx, tblName, rowKey, columnKey, limit)
+	if err != nil {
+		return cells, found, err
+	}
+	err = s.decryptCells(ctx, tblName, cells)
+	return cells, found, err
+}
+
+// GetLatestAsOf implements Storage.GetLatestAsOf()
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	cell, found, err = s.backend.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+	if err != nil || !found {
+		return cell, found, err
+	}
+	err = s.decryptCell(ctx, tblName, &cell)
+	return cell, found, err
+}
+
+// GetRowAsOf implements Storage.GetRowAsOf()
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.GetRowAsOf(ctx, tblName, rowKey, asOf)
+	if err != nil {
+		return cells, found, err
+	}
+	err = s.decryptCells(ctx, tblName, cells)
+	return cells, found, err
+}
+
+// PartitionRead implements Storage.PartitionRead(). The wrapped data keys
+// are left out of the results.
+func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	cells, found, err = s.backend.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+	if err != nil {
+		return cells, found, err
+	}
+
+	n := 0
+	for _, cell := range cells {
+		if cell.RowKey != KeyRingRowKey {
+			cells[n] = cell
+			n++
+		}
+	}
+	cells = cells[:n]
+
+	err = s.decryptCells(ctx, tblName, cells)
+	return cells, n > 0, err
+}
+
+// Put implements Storage.Put()
+func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	body, err := s.seal(ctx, tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	return s.backend.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+// PutWithExpiry implements core.Expirer for backends that do.
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	body, err := s.seal(ctx, tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return err
+	}
+	return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+// SweepExpired implements core.Expirer for backends that do.
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (int64, error) {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	return expirer.SweepExpired(ctx, tblName, now, limit)
+}
+
+// seal encrypts body under tblName's current data key, if the table is
+// encrypted.
+func (s *Storage) seal(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) (string, error) {
+	if rowKey == KeyRingRowKey {
+		return "", ErrReservedRowKey
+	}
+	if !s.encrypted(tblName) {
+		return body, nil
+	}
+
+	kr, err := s.keyring(ctx, tblName)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.encrypt(kr.current, tblName, rowKey, columnKey, refKey, body)
+	if err != nil {
+		return "", fmt.Errorf("encrypting %s (%s, %s, %d): %w", tblName, rowKey, columnKey, refKey, err)
+	}
+	return sealed, nil
+}
+
+// Purge implements core.Purger for backends that do.
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (int64, error) {
+	if rowKey == KeyRingRowKey {
+		return 0, ErrReservedRowKey
+	}
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return purger.Purge(ctx, tblName, rowKey)
+}
+
+// PurgeCell implements core.Purger for backends that do.
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	if rowKey == KeyRingRowKey {
+		return ErrReservedRowKey
+	}
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+}
+
+// FindPartition implements Storage.FindPartition()
+func (s *Storage) FindPartition(tblName, rowKey string) int {
+	return s.backend.FindPartition(tblName, rowKey)
+}
+
+// ResetConnection implements Storage.ResetConnection()
+func (s *Storage) ResetConnection(ctx context.Context, key string) error {
+	return s.backend.ResetConnection(ctx, key)
+}
+
+// Destroy implements Storage.Destroy()
+func (s *Storage) Destroy(ctx context.Context) error {
+	return s.backend.Destroy(ctx)
+}
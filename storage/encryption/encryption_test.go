@@ -0,0 +1,287 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+func writeKeyFile(t *testing.T, path, current string, ids ...string) {
+	kf := keyFile{Current: current, Keys: make(map[string]string)}
+	for _, id := range ids {
+		key := make([]byte, keySize)
+		_, err := rand.Read(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	// keep keys already in the file
+	if contents, err := ioutil.ReadFile(path); err == nil {
+		var old keyFile
+		if err = json.Unmarshal(contents, &old); err == nil {
+			for id, key := range old.Keys {
+				kf.Keys[id] = key
+			}
+		}
+	}
+
+	contents, err := json.Marshal(kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedSQLite(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, keyPath, "k1", "k1")
+	provider, err := NewFileKeyProvider(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := storagetest.NewSQLite(t)
+
+	// a cell written before encryption was enabled
+	ctx := context.TODO()
+	secret := "{\"ssn\": \"078-05-1120\"}"
+	err = backend.Put(ctx, "cell", "legacy", "BASE", 1, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(backend, provider).WithTable("cell").WithKeyringTTL(10 * time.Millisecond)
+
+	cell, found, err := m.GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || cell.Body != secret {
+		t.Errorf("expected legacy cell %q, got %q", secret, cell.Body)
+	}
+
+	err = m.Put(ctx, "cell", "legacy", "BASE", 2, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, err := backend.GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env, ok := parseEnvelope(raw.Body); !ok || env.KeyID != 1 || strings.Contains(raw.Body, "078-05-1120") {
+		t.Errorf("expected a body sealed with data key 1, got %q", raw.Body)
+	}
+
+	err = m.Put(ctx, "cell", KeyRingRowKey, "BASE", 1, secret)
+	if err != ErrReservedRowKey {
+		t.Errorf("expected ErrReservedRowKey, got %v", err)
+	}
+
+	// a copied ciphertext must not decrypt under other coordinates
+	err = backend.Put(ctx, "cell", "copied", "BASE", 1, raw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = m.GetLatest(ctx, "cell", "copied", "BASE")
+	if err == nil {
+		t.Error("expected an error decrypting a body copied to another cell")
+	}
+	err = backend.Rewrite(ctx, "cell", "copied", "BASE", 1, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a cell that has expired but not yet been swept
+	err = m.PutWithExpiry(ctx, "cell", "expired", "BASE", 1, secret, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate the master key, then the data key, and move every cell to it
+	writeKeyFile(t, keyPath, "k2", "k2")
+	err = provider.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Rotate(ctx, "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 {
+		t.Errorf("expected data key 2, got %d", id)
+	}
+
+	res := <-m.StartReencrypt(ctx, "cell", 2)
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	// the legacy, sealed, copied and expired cells
+	if res.Rewritten != 4 {
+		t.Errorf("expected 4 cells re-encrypted, got %d", res.Rewritten)
+	}
+	cells, _, err := backend.Scan(ctx, "cell", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cells {
+		if env, ok := parseEnvelope(c.Body); c.RowKey == "expired" && (!ok || env.KeyID != 2) {
+			t.Errorf("expected the expired cell sealed with data key 2, got %q", c.Body)
+		}
+	}
+
+	for _, refKey := range []int64{1, 2} {
+		raw, _, err = backend.Get(ctx, "cell", "legacy", "BASE", refKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env, ok := parseEnvelope(raw.Body); !ok || env.KeyID != 2 {
+			t.Errorf("expected ref key %d sealed with data key 2, got %q", refKey, raw.Body)
+		}
+
+		cell, _, err = m.Get(ctx, "cell", "legacy", "BASE", refKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cell.Body != secret {
+			t.Errorf("expected %q after re-encryption, got %q", secret, cell.Body)
+		}
+	}
+
+	// a fresh wrapper finds the rotated keys in the table
+	cell, _, err = New(backend, provider).GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Body != secret {
+		t.Errorf("expected %q from a new wrapper, got %q", secret, cell.Body)
+	}
+
+	// every cell has moved to data key 2, so master key k1 can be retired
+	contents, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kf keyFile
+	err = json.Unmarshal(contents, &kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(kf.Keys, "k1")
+	contents, err = json.Marshal(kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyPath, contents, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cell, _, err = New(backend, provider).GetLatest(ctx, "cell", "legacy", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell.Body != secret {
+		t.Errorf("expected %q after retiring k1, got %q", secret, cell.Body)
+	}
+
+	storagetest.StorageTest(t, m)
+}
+
+func TestKeyringTTL(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, keyPath, "k1", "k1")
+	provider, err := NewFileKeyProvider(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := storagetest.NewSQLite(t)
+
+	ctx := context.TODO()
+	kid := func(refKey int64) int64 {
+		raw, _, err := backend.Get(ctx, "cell", "trip1", "BASE", refKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, _ := parseEnvelope(raw.Body)
+		return env.KeyID
+	}
+
+	// two processes sharing the shard, one of which rotates the data key
+	const ttl = 200 * time.Millisecond
+	rotator := New(backend, provider).WithTable("cell").WithKeyringTTL(ttl)
+	other := New(backend, provider).WithTable("cell").WithKeyringTTL(ttl)
+	err = other.Put(ctx, "cell", "trip1", "BASE", 1, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rotator.Rotate(ctx, "cell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := rotator.StartReencrypt(ctx, "cell", 10)
+
+	// the other keeps sealing with the old key until its keyring expires
+	err = other.Put(ctx, "cell", "trip1", "BASE", 2, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := kid(2); id != 1 {
+		t.Errorf("expected a cell sealed with the cached data key 1, got %d", id)
+	}
+	time.Sleep(ttl)
+	err = other.Put(ctx, "cell", "trip1", "BASE", 3, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := kid(3); id != 2 {
+		t.Errorf("expected the rotated data key once the keyring expired, got %d", id)
+	}
+
+	// and re-encryption waits long enough to move what it sealed
+	res := <-done
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	for refKey := int64(1); refKey <= 3; refKey++ {
+		if id := kid(refKey); id != 2 {
+			t.Errorf("expected ref key %d re-encrypted with data key 2, got %d", refKey, id)
+		}
+	}
+
+	// reading an encrypted cell never creates a data key, e.g. on a replica
+	// the keys have yet to reach
+	replica := storagetest.NewSQLite(t)
+	raw, _, err := backend.Get(ctx, "cell", "trip1", "BASE", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = replica.Put(ctx, "cell", "trip1", "BASE", 3, raw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = New(replica, provider).Get(ctx, "cell", "trip1", "BASE", 3)
+	if err == nil {
+		t.Error("expected an error reading a cell whose data key is missing")
+	}
+	keys, found, err := replica.History(ctx, "cell", KeyRingRowKey, dataKeyColumn, 10)
+	if err != nil || found {
+		t.Errorf("expected no data key created by a read, got %v err=%v", keys, err)
+	}
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// KeyProvider supplies the master keys that data keys are wrapped with. A
+// provider backed by a KMS would typically wrap and unwrap remotely; this
+// interface hands out the key material itself, which keeps local use simple.
+type KeyProvider interface {
+	// CurrentKey returns the master key that new data keys are wrapped with.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the master key with the given id, which may have since
+	// been rotated out.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// FileKeyProvider reads master keys from a JSON file of the form
+//
+//	{
+//	  "current": "2021-06",
+//	  "keys": {
+//	    "2021-01": "<base64 encoded 32 byte key>",
+//	    "2021-06": "<base64 encoded 32 byte key>"
+//	  }
+//	}
+//
+// To rotate the master key, add a new key, point "current" at it and call
+// Reload. Keep old keys in the file until every cell has been re-encrypted.
+type FileKeyProvider struct {
+	path string
+
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewFileKeyProvider loads the master keys in path.
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	err := p.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload re-reads the key file.
+func (p *FileKeyProvider) Reload() error {
+	contents, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var kf keyFile
+	err = json.Unmarshal(contents, &kf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	keys := make(map[string][]byte, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("%s: key %s: %w", p.path, id, err)
+		}
+		if len(key) != keySize {
+			return fmt.Errorf("%s: key %s is %d bytes, expected %d", p.path, id, len(key), keySize)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[kf.Current]; !ok {
+		return fmt.Errorf("%s: current key '%s' is not in keys", p.path, kf.Current)
+	}
+
+	p.mu.Lock()
+	p.current = kf.Current
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// CurrentKey implements KeyProvider.CurrentKey()
+func (p *FileKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current, p.keys[p.current], nil
+}
+
+// Key implements KeyProvider.Key()
+func (p *FileKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("master key '%s' not found in %s", id, p.path)
+	}
+	return key, nil
+}
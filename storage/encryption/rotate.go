@@ -0,0 +1,158 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Rewriter is implemented by backends that can replace the body of an
+// existing cell in place, which re-encryption requires.
+type Rewriter interface {
+	Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error
+}
+
+// Scanner is implemented by backends that can list every cell of a table in
+// added_at order, including cells that have expired but not yet been swept,
+// which re-encryption requires.
+type Scanner interface {
+	Scan(ctx context.Context, tblName string, addedAt int64, limit int) (cells []models.Cell, found bool, err error)
+}
+
+// Rotate creates a new data key for tblName, wrapped with the provider's
+// current master key, and encrypts new cells with it from then on. Cells
+// sealed with older data keys remain readable; use Reencrypt to move them
+// to the new key. Rotate returns the new data key's version.
+//
+// Older data keys are only unwrapped when a cell sealed with them is read,
+// so once Reencrypt has finished the master keys that wrapped them can be
+// removed from the provider.
+func (s *Storage) Rotate(ctx context.Context, tblName string) (int64, error) {
+	kr, err := s.loadKeyring(ctx, tblName, true)
+	if err != nil {
+		return 0, err
+	}
+
+	dk, err := s.newDataKey(ctx, tblName, kr.current.id+1)
+	if err != nil {
+		return 0, fmt.Errorf("rotating data key of %s: %w", tblName, err)
+	}
+
+	rotated := &keyring{
+		wrapped: kr.wrapped,
+		current: dk,
+		loaded:  time.Now(),
+		keys:    map[int64]*dataKey{dk.id: dk},
+	}
+	kr.mu.Lock()
+	for id, k := range kr.keys {
+		rotated.keys[id] = k
+	}
+	kr.mu.Unlock()
+
+	s.mu.Lock()
+	s.keyrings[tblName] = rotated
+	s.mu.Unlock()
+	return dk.id, nil
+}
+
+// Reencrypt rewrites every cell of tblName that is not sealed with the
+// current data key, including cells written before encryption was enabled,
+// reading batchSize cells at a time. Cells that have expired but not yet
+// been swept are rewritten too. It returns the number of cells rewritten.
+// The wrapped backend must implement Rewriter and Scanner.
+//
+// Other processes sharing the shard may seal new cells with the old data
+// key until their cached keyring expires, so Reencrypt keeps going, over
+// the cells added since, until the keyring TTL has passed since it began.
+func (s *Storage) Reencrypt(ctx context.Context, tblName string, batchSize int) (int, error) {
+	rw, ok := s.backend.(Rewriter)
+	if !ok {
+		return 0, fmt.Errorf("backend %T cannot rewrite cells", s.backend)
+	}
+	sc, ok := s.backend.(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("backend %T cannot scan cells", s.backend)
+	}
+
+	kr, err := s.keyring(ctx, tblName)
+	if err != nil {
+		return 0, err
+	}
+	current := kr.current
+	until := time.Now().Add(s.ttl)
+
+	var (
+		n      int
+		cursor int64
+	)
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return n, err
+		}
+
+		cells, found, err := sc.Scan(ctx, tblName, cursor, batchSize)
+		if err != nil {
+			return n, err
+		}
+		if !found {
+			wait := time.Until(until)
+			if wait <= 0 {
+				return n, nil
+			}
+			select {
+			case <-ctx.Done():
+				return n, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		for _, cell := range cells {
+			if cell.AddedAt >= cursor {
+				cursor = cell.AddedAt + 1
+			}
+			if cell.RowKey == KeyRingRowKey {
+				continue
+			}
+			if env, ok := parseEnvelope(cell.Body); ok && env.KeyID == current.id {
+				continue
+			}
+
+			err = s.decryptCell(ctx, tblName, &cell)
+			if err != nil {
+				return n, err
+			}
+			body, err := s.encrypt(current, tblName, cell.RowKey, cell.ColumnName, cell.RefKey, cell.Body)
+			if err != nil {
+				return n, err
+			}
+			err = rw.Rewrite(ctx, tblName, cell.RowKey, cell.ColumnName, cell.RefKey, body)
+			if err != nil {
+				return n, fmt.Errorf("re-encrypting %s (%s, %s, %d): %w", tblName, cell.RowKey, cell.ColumnName, cell.RefKey, err)
+			}
+			n++
+		}
+	}
+}
+
+// ReencryptResult is the outcome of a background re-encryption.
+type ReencryptResult struct {
+	Rewritten int
+	Err       error
+}
+
+// StartReencrypt runs Reencrypt in the background. The result is sent on
+// the returned channel once every cell has been visited or ctx is done.
+func (s *Storage) StartReencrypt(ctx context.Context, tblName string, batchSize int) <-chan ReencryptResult {
+	done := make(chan ReencryptResult, 1)
+	go func() {
+		n, err := s.Reencrypt(ctx, tblName, batchSize)
+		done <- ReencryptResult{Rewritten: n, Err: err}
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"go.uber.org/zap"
+)
+
+// Logging logs every call at debug level, and every failed call at warn
+// level, with its table, row key and duration.
+func Logging(l *zap.Logger) core.Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+
+		fields := []zap.Field{
+			zap.String("op", call.Op),
+			zap.String("table", call.Table),
+			zap.String("row_key", call.RowKey),
+			zap.Duration("duration", time.Since(start)),
+		}
+		if err != nil {
+			l.Warn("storage call failed", append(fields, zap.Error(err))...)
+		} else {
+			l.Debug("storage call", fields...)
+		}
+		return err
+	})
+}
@@ -0,0 +1,191 @@
+// Package middleware provides core.Middlewares for logging, timing,
+// read-only mode and per-table quotas, and Intercept, which turns a single
+// function that sees every call into a middleware of its own.
+//
+//	kv.Use(middleware.Logging(logger), middleware.ReadOnly())
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Call describes a call to a shard's Storage.
+type Call struct {
+	Op     string // the Storage method, e.g. "GetLatest"
+	Table  string
+	RowKey string // empty for calls that span rows
+	Write  bool   // whether the call changes the shard's cells
+}
+
+// Interceptor is called in place of each call to a shard, which it makes by
+// calling next. It may change the context, return an error instead of
+// calling next, or look at the error next returns.
+//
+// FindPartition, ResetConnection and Destroy are not intercepted.
+type Interceptor func(ctx context.Context, call Call, next func(ctx context.Context) error) error
+
+// Intercept returns a middleware that passes every call through i.
+func Intercept(i Interceptor) core.Middleware {
+	return func(backend core.Storage) core.Storage {
+		return &Storage{backend: backend, intercept: i}
+	}
+}
+
+// Storage is a backend wrapped by an Interceptor.
+type Storage struct {
+	backend   core.Storage
+	intercept Interceptor
+}
+
+func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "Get", Table: tblName, RowKey: rowKey}, func(ctx context.Context) (err error) {
+		cell, found, err = s.backend.Get(ctx, tblName, rowKey, columnKey, refKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "GetLatest", Table: tblName, RowKey: rowKey}, func(ctx context.Context) (err error) {
+		cell, found, err = s.backend.GetLatest(ctx, tblName, rowKey, columnKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "History", Table: tblName, RowKey: rowKey}, func(ctx context.Context) (err error) {
+		cells, found, err = s.backend.History(ctx, tblName, rowKey, columnKey, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "GetLatestAsOf", Table: tblName, RowKey: rowKey}, func(ctx context.Context) (err error) {
+		cell, found, err = s.backend.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "GetRowAsOf", Table: tblName, RowKey: rowKey}, func(ctx context.Context) (err error) {
+		cells, found, err = s.backend.GetRowAsOf(ctx, tblName, rowKey, asOf)
+		return err
+	})
+	return
+}
+
+func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.intercept(ctx, Call{Op: "PartitionRead", Table: tblName}, func(ctx context.Context) (err error) {
+		cells, found, err = s.backend.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	return s.intercept(ctx, Call{Op: "Put", Table: tblName, RowKey: rowKey, Write: true}, func(ctx context.Context) error {
+		return s.backend.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+	})
+}
+
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	return s.intercept(ctx, Call{Op: "PutWithExpiry", Table: tblName, RowKey: rowKey, Write: true}, func(ctx context.Context) error {
+		return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+	})
+}
+
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (n int64, err error) {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	err = s.intercept(ctx, Call{Op: "SweepExpired", Table: tblName, Write: true}, func(ctx context.Context) (err error) {
+		n, err = expirer.SweepExpired(ctx, tblName, now, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (n int64, err error) {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	err = s.intercept(ctx, Call{Op: "Purge", Table: tblName, RowKey: rowKey, Write: true}, func(ctx context.Context) (err error) {
+		n, err = purger.Purge(ctx, tblName, rowKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return s.intercept(ctx, Call{Op: "PurgeCell", Table: tblName, RowKey: rowKey, Write: true}, func(ctx context.Context) error {
+		return purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+	})
+}
+
+// Rewrite forwards to the backend's Rewrite, so that an encryption wrapper
+// around the middleware can re-encrypt cells.
+func (s *Storage) Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	rw, ok := s.backend.(interface {
+		Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error
+	})
+	if !ok {
+		return fmt.Errorf("backend does not support rewriting cells: %T", s.backend)
+	}
+	return s.intercept(ctx, Call{Op: "Rewrite", Table: tblName, RowKey: rowKey, Write: true}, func(ctx context.Context) error {
+		return rw.Rewrite(ctx, tblName, rowKey, columnKey, refKey, body)
+	})
+}
+
+// Scan forwards to the backend's Scan, so that an encryption wrapper around
+// the middleware can re-encrypt cells.
+func (s *Storage) Scan(ctx context.Context, tblName string, addedAt int64, limit int) (cells []models.Cell, found bool, err error) {
+	sc, ok := s.backend.(interface {
+		Scan(ctx context.Context, tblName string, addedAt int64, limit int) ([]models.Cell, bool, error)
+	})
+	if !ok {
+		return nil, false, fmt.Errorf("backend does not support scanning cells: %T", s.backend)
+	}
+	err = s.intercept(ctx, Call{Op: "Scan", Table: tblName}, func(ctx context.Context) (err error) {
+		cells, found, err = sc.Scan(ctx, tblName, addedAt, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	pinger, ok := s.backend.(core.Pinger)
+	if !ok {
+		return nil
+	}
+	return s.intercept(ctx, Call{Op: "Ping"}, pinger.Ping)
+}
+
+func (s *Storage) FindPartition(tblName, rowKey string) int {
+	return s.backend.FindPartition(tblName, rowKey)
+}
+
+func (s *Storage) ResetConnection(ctx context.Context, key string) error {
+	return s.backend.ResetConnection(ctx, key)
+}
+
+func (s *Storage) Destroy(ctx context.Context) error {
+	return s.backend.Destroy(ctx)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+func TestMiddlewareSQLite(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	var calls []Call
+	timed := Timing(func(call Call, d time.Duration, err error) {
+		calls = append(calls, call)
+	})
+	storagetest.StorageTest(t, timed(backend))
+
+	if len(calls) == 0 || calls[0].Op != "Get" || calls[0].Table != "cell" {
+		t.Errorf("expected every call to be timed, got %v", calls)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	on := true
+	s := ReadOnlyWhen(func() bool { return on })(backend)
+
+	ctx := context.TODO()
+	err := s.Put(ctx, "cell", "trip1", "BASE", 1, "{}")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected a read-only error, got %v", err)
+	}
+	_, err = s.(core.Purger).Purge(ctx, "cell", "trip1")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected Purge to be rejected, got %v", err)
+	}
+	_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+	if err != nil {
+		t.Errorf("expected reads to be allowed, got %v", err)
+	}
+
+	on = false
+	err = s.Put(ctx, "cell", "trip1", "BASE", 1, "{}")
+	if err != nil {
+		t.Errorf("expected writes once read-only mode is off, got %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	now := time.Now()
+	q := NewQuota(map[string]Limit{"cell": {Writes: 1, Burst: 2}}).WithClock(func() time.Time { return now })
+	s := q.Middleware()(backend)
+
+	ctx := context.TODO()
+	for i := int64(1); i <= 2; i++ {
+		err := s.Put(ctx, "cell", "trip1", "BASE", i, "{}")
+		if err != nil {
+			t.Fatalf("expected the burst to be allowed, got %v", err)
+		}
+	}
+	err := s.Put(ctx, "cell", "trip1", "BASE", 3, "{}")
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the quota to be exceeded, got %v", err)
+	}
+
+	// reads and other tables have no limit
+	for i := 0; i < 5; i++ {
+		_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+		if err != nil {
+			t.Fatalf("expected reads to be unlimited, got %v", err)
+		}
+	}
+
+	// a token is refilled every second
+	now = now.Add(time.Second)
+	err = s.Put(ctx, "cell", "trip1", "BASE", 3, "{}")
+	if err != nil {
+		t.Errorf("expected a refilled token, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// ErrQuotaExceeded is returned for a call over its table's quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Limit is a table's quota: how many reads and writes per second it may
+// make, on average, across every shard the Quota is used on. Up to Burst
+// calls of each kind may be made at once. A zero rate is unlimited.
+type Limit struct {
+	Reads  float64 `json:"reads_per_second,omitempty"`
+	Writes float64 `json:"writes_per_second,omitempty"`
+	Burst  int     `json:"burst,omitempty"`
+}
+
+// Quota rate limits the calls to each table, using a token bucket per
+// table and kind of call.
+type Quota struct {
+	limits map[string]Limit
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	table string
+	write bool
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewQuota returns a Quota enforcing limits, by table name. Tables without
+// a limit are not limited.
+func NewQuota(limits map[string]Limit) *Quota {
+	return &Quota{limits: limits, now: time.Now, buckets: make(map[bucketKey]*bucket)}
+}
+
+// WithClock sets the clock that tokens are refilled by.
+func (q *Quota) WithClock(now func() time.Time) *Quota {
+	q.now = now
+	return q
+}
+
+// Middleware returns the middleware that enforces the quota. Using it on
+// several shards makes the limits apply to all of them together.
+func (q *Quota) Middleware() core.Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		if !q.allow(call) {
+			return fmt.Errorf("%s %s: %w", call.Op, call.Table, ErrQuotaExceeded)
+		}
+		return next(ctx)
+	})
+}
+
+// allow takes a token for call from its bucket, if there is one.
+func (q *Quota) allow(call Call) bool {
+	limit, ok := q.limits[call.Table]
+	if !ok {
+		return true
+	}
+	rate := limit.Reads
+	if call.Write {
+		rate = limit.Writes
+	}
+	if rate <= 0 {
+		return true
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	key := bucketKey{table: call.Table, write: call.Write}
+	b, ok := q.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		q.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
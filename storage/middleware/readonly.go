@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// ErrReadOnly is returned for a write to a shard in read-only mode.
+var ErrReadOnly = errors.New("storage is read-only")
+
+// ReadOnly rejects every call that would change a shard's cells, e.g.
+// while the shard is being copied.
+func ReadOnly() core.Middleware {
+	return ReadOnlyWhen(func() bool { return true })
+}
+
+// ReadOnlyWhen rejects writes while on returns true, so that read-only
+// mode can be switched at run time.
+func ReadOnlyWhen(on func() bool) core.Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		if call.Write && on() {
+			return fmt.Errorf("%s %s: %w", call.Op, call.Table, ErrReadOnly)
+		}
+		return next(ctx)
+	})
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// Timing calls observe with the duration and outcome of every call, e.g.
+// to record it in a histogram.
+func Timing(observe func(call Call, d time.Duration, err error)) core.Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		observe(call, time.Since(start), err)
+		return err
+	})
+}
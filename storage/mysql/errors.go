@@ -0,0 +1,49 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// MySQL server error numbers that are classified specially.
+const (
+	errDupEntry        = 1062
+	errLockWaitTimeout = 1205
+	errLockDeadlock    = 1213
+	errConCount        = 1040 // too many connections
+	errServerShutdown  = 1053
+)
+
+// classifyError maps a MySQL error into core's error classes.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return core.Classify(core.ErrNotFound, err)
+	}
+	if errors.Is(err, mysql.ErrInvalidConn) || core.IsConnectionError(err) {
+		return core.Classify(core.ErrShardUnavailable, err)
+	}
+	var merr *mysql.MySQLError
+	if errors.As(err, &merr) {
+		switch merr.Number {
+		case errDupEntry:
+			return core.Classify(core.ErrConflict, err)
+		case errLockWaitTimeout, errLockDeadlock, errConCount:
+			return core.Classify(core.ErrTransient, err)
+		case errServerShutdown:
+			return core.Classify(core.ErrShardUnavailable, err)
+		}
+	}
+	return core.Classify(core.ErrPermanent, err)
+}
+
+// classify classifies *err in place; defer it from methods with a named
+// error result.
+func classify(err *error) {
+	*err = classifyError(*err)
+}
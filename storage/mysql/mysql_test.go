@@ -1,7 +1,10 @@
 package mysql
 
 import (
+	"github.com/go-sql-driver/mysql"
+	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/storagetest"
+	"go.uber.org/zap/zaptest"
 	"os"
 	"testing"
 )
@@ -37,18 +40,56 @@ func TestMySQL(t *testing.T) {
 		WithPass(pass).
 		WithHost(host).
 		WithPort(port).
-		WithDatabase(db)
+		WithDatabase(db).
+		WithLogger(zaptest.NewLogger(t))
 
-	err := m.WithZap()
+	err := m.Open()
 	if err != nil {
 		t.Fatal(err)
 	}
+	storagetest.StorageTest(t, m)
+}
 
-	defer m.sugar.Sync()
+func TestQuote(t *testing.T) {
+	for name, want := range map[string]string{
+		"cell":                   "`cell`",
+		"cell`; DROP TABLE cell": "`cell``; DROP TABLE cell`",
+	} {
+		if got := Quote(name); got != want {
+			t.Errorf("Quote(%q) = %s, expected %s", name, got, want)
+		}
+	}
+}
 
-	err = m.Open()
+func TestDSN(t *testing.T) {
+	m := New().WithUser("user").
+		WithPass("p@ss/word").
+		WithHost("db.example.com").
+		WithPort("3306").
+		WithDatabase("trips").
+		WithParams(map[string]string{"charset": "utf8mb4", "readTimeout": "5s"}).
+		WithTLS(core.TLS{Mode: core.TLSRequire})
+
+	dsn, err := m.dsn()
 	if err != nil {
 		t.Fatal(err)
 	}
-	storagetest.StorageTest(t, m)
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Passwd != "p@ss/word" || cfg.Addr != "db.example.com:3306" || cfg.DBName != "trips" {
+		t.Errorf("expected the shard's address and credentials, got %s", dsn)
+	}
+	if !cfg.ParseTime || cfg.ReadTimeout.String() != "5s" || cfg.Params["charset"] != "utf8mb4" {
+		t.Errorf("expected parseTime and the given parameters, got %s", dsn)
+	}
+	if cfg.TLSConfig == "" {
+		t.Errorf("expected a TLS configuration, got %s", dsn)
+	}
+
+	_, err = m.WithTLS(core.TLS{Mode: "sometimes"}).dsn()
+	if err == nil {
+		t.Error("expected an unrecognized TLS mode to be rejected")
+	}
 }
@@ -9,8 +9,11 @@ CREATE TABLE IF NOT EXISTS trips
 	column_name   VARCHAR(64) NOT NULL,
 	ref_key	      INTEGER NOT NULL,
 	body          JSON,
-	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
-	UNIQUE `cell_idx`(`row_key`, `column_name`, `ref_key`)
+	created_at    DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+	expires_at    DATETIME(6) NULL,
+	UNIQUE `cell_idx`(`row_key`, `column_name`, `ref_key`),
+	INDEX `asof_idx`(`row_key`, `column_name`, `created_at`),
+	INDEX `expires_idx`(`expires_at`)
 ) ENGINE=InnoDB;
 
 SHOW WARNINGS;
@@ -22,8 +25,11 @@ CREATE TABLE IF NOT EXISTS trips_base_driver_partner_uuid
 	column_name   VARCHAR(64) NOT NULL,
 	ref_key       INTEGER NOT NULL,
 	body          JSON,
-	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
-	UNIQUE `cell_idx`(`row_key`, `column_name`, `ref_key`)
+	created_at    DATETIME(6) DEFAULT CURRENT_TIMESTAMP(6),
+	expires_at    DATETIME(6) NULL,
+	UNIQUE `cell_idx`(`row_key`, `column_name`, `ref_key`),
+	INDEX `asof_idx`(`row_key`, `column_name`, `created_at`),
+	INDEX `expires_idx`(`expires_at`)
 ) ENGINE=InnoDB;
 
 SHOW WARNINGS;
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// classifyError maps a Postgres error into core's error classes.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return core.Classify(core.ErrNotFound, err)
+	}
+	if core.IsConnectionError(err) {
+		return core.Classify(core.ErrShardUnavailable, err)
+	}
+	var perr *pq.Error
+	if errors.As(err, &perr) {
+		switch {
+		case perr.Code == "23505": // unique_violation
+			return core.Classify(core.ErrConflict, err)
+		case perr.Code == "40001", perr.Code == "40P01", perr.Code == "55P03", perr.Code == "53300":
+			// serialization_failure, deadlock_detected, lock_not_available, too_many_connections
+			return core.Classify(core.ErrTransient, err)
+		case perr.Code.Class() == "08", perr.Code.Class() == "57":
+			// connection exceptions, and the server shutting down
+			return core.Classify(core.ErrShardUnavailable, err)
+		}
+	}
+	return core.Classify(core.ErrPermanent, err)
+}
+
+// classify classifies *err in place; defer it from methods with a named
+// error result.
+func classify(err *error) {
+	*err = classifyError(*err)
+}
@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// Quote returns name quoted as a PostgreSQL identifier. It is folded to
+// lower case first, as an unquoted name would be, so that tables created
+// before names were quoted are still found.
+func Quote(name string) string {
+	return pq.QuoteIdentifier(strings.ToLower(name))
+}
+
+// table checks tblName and returns it quoted, for use in a statement.
+func table(tblName string) (string, error) {
+	err := core.ValidateTableName(tblName)
+	if err != nil {
+		return "", err
+	}
+	return Quote(tblName), nil
+}
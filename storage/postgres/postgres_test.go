@@ -1,7 +1,10 @@
 package postgres
 
 import (
+	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/storagetest"
+	"go.uber.org/zap/zaptest"
+	"net/url"
 	"os"
 	"testing"
 )
@@ -37,18 +40,67 @@ func TestPostgres(t *testing.T) {
 		WithPass(pass).
 		WithHost(host).
 		WithPort(port).
-		WithDatabase(db)
+		WithDatabase(db).
+		WithLogger(zaptest.NewLogger(t))
 
-	err := m.WithZap()
+	err := m.Open()
 	if err != nil {
 		t.Fatal(err)
 	}
+	storagetest.StorageTest(t, m)
+}
+
+func TestQuote(t *testing.T) {
+	for name, want := range map[string]string{
+		"cell":                   `"cell"`,
+		"Trips":                  `"trips"`,
+		`cell"; DROP TABLE cell`: `"cell""; drop table cell"`,
+	} {
+		if got := Quote(name); got != want {
+			t.Errorf("Quote(%q) = %s, expected %s", name, got, want)
+		}
+	}
+}
 
-	defer m.sugar.Sync()
+func TestDSN(t *testing.T) {
+	m := New().WithUser("user").
+		WithPass("p@ss/word").
+		WithHost("db.example.com").
+		WithPort("5432").
+		WithDatabase("trips")
 
-	err = m.Open()
+	dsn, err := m.dsn()
 	if err != nil {
 		t.Fatal(err)
 	}
-	storagetest.StorageTest(t, m)
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pass, _ := u.User.Password(); pass != "p@ss/word" || u.Host != "db.example.com:5432" || u.Path != "/trips" {
+		t.Errorf("expected the shard's address and credentials, got %s", dsn)
+	}
+	if got := u.Query().Get("sslmode"); got != "disable" {
+		t.Errorf("expected connections to be unencrypted by default, got sslmode=%s", got)
+	}
+
+	m.WithTLS(core.TLS{Mode: core.TLSVerifyFull, CAFile: "ca.pem"}).
+		WithParams(map[string]string{"application_name": "schemalessd"})
+	dsn, err = m.dsn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("sslmode") != "verify-full" || q.Get("sslrootcert") != "ca.pem" || q.Get("application_name") != "schemalessd" {
+		t.Errorf("expected the TLS settings and parameters, got %s", dsn)
+	}
+
+	_, err = m.WithTLS(core.TLS{Mode: "sometimes"}).dsn()
+	if err == nil {
+		t.Error("expected an unrecognized TLS mode to be rejected")
+	}
 }
@@ -13,11 +13,16 @@ CREATE TABLE trips
 	column_name	  VARCHAR(64) NOT NULL,
 	ref_key		  INTEGER NOT NULL,
 	body		  JSON,
-	created_at        TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	created_at        TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	expires_at        TIMESTAMP WITH TIME ZONE
 );
 
 CREATE UNIQUE INDEX TRIPS_IDX ON TRIPS ( row_key, column_name, ref_key ASC );
 
+CREATE INDEX TRIPS_ASOF_IDX ON TRIPS ( row_key, column_name, created_at );
+
+CREATE INDEX TRIPS_EXPIRES_IDX ON TRIPS ( expires_at );
+
 DROP TABLE IF EXISTS trips_base_driver_partner_uuid;
 
 CREATE SEQUENCE trips_base_driver_partner_uuid_added_at_seq;
@@ -29,8 +34,13 @@ CREATE TABLE trips_base_driver_partner_uuid
 	column_name	  VARCHAR(64) NOT NULL,
 	ref_key		  INTEGER NOT NULL,
 	body		  JSON,
-	created_at        TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	created_at        TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	expires_at        TIMESTAMP WITH TIME ZONE
 );
 
 CREATE UNIQUE INDEX TRIPS_BASE_DRIVER_PARTNER_UUID_IDX ON TRIPS_BASE_DRIVER_PARTNER_UUID ( row_key, column_name, ref_key ASC );
 
+CREATE INDEX TRIPS_BASE_DRIVER_PARTNER_UUID_ASOF_IDX ON TRIPS_BASE_DRIVER_PARTNER_UUID ( row_key, column_name, created_at );
+
+CREATE INDEX TRIPS_BASE_DRIVER_PARTNER_UUID_EXPIRES_IDX ON TRIPS_BASE_DRIVER_PARTNER_UUID ( expires_at );
+
@@ -0,0 +1,261 @@
+// Package retry wraps a storage backend so that idempotent calls which fail
+// with a retryable error (see core.IsRetryable) are tried again, after a
+// jittered exponential backoff.
+//
+// Reads, purges, sweeps, rewrites and pings are retried. Puts are not: a
+// Put whose reply was lost may have written its cell, and trying it again
+// would fail with core.ErrConflict instead of reporting what happened.
+//
+// Retrying stops when the attempts run out, when the error is not
+// retryable, or when the next backoff would end after the context's
+// deadline; the last error is returned.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Storage retries a backend's idempotent calls.
+type Storage struct {
+	backend  core.Storage
+	attempts int
+	base     time.Duration
+	max      time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New wraps backend so that each idempotent call is tried up to 3 times,
+// backing off from 50 milliseconds up to a second.
+func New(backend core.Storage) *Storage {
+	return &Storage{
+		backend:  backend,
+		attempts: 3,
+		base:     50 * time.Millisecond,
+		max:      time.Second,
+		now:      time.Now,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// WithAttempts sets how many times a call is tried, including the first.
+func (s *Storage) WithAttempts(n int) *Storage {
+	s.attempts = n
+	return s
+}
+
+// WithBackoff sets the backoff before the first retry, which doubles with
+// every further retry up to max. Each backoff is a random duration up to
+// that bound.
+func (s *Storage) WithBackoff(base, max time.Duration) *Storage {
+	s.base = base
+	s.max = max
+	return s
+}
+
+// WithRand sets the source of the backoff's jitter.
+func (s *Storage) WithRand(r *rand.Rand) *Storage {
+	s.rand = r
+	return s
+}
+
+// WithClock sets the clock that backoffs are compared to deadlines with.
+func (s *Storage) WithClock(now func() time.Time) *Storage {
+	s.now = now
+	return s
+}
+
+// backoff returns how long to wait before retry number n, counting from 0.
+func (s *Storage) backoff(n int) time.Duration {
+	bound := s.max
+	if n < 32 && s.base<<uint(n) < s.max && s.base<<uint(n) > 0 {
+		bound = s.base << uint(n)
+	}
+	if bound <= 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Duration(s.rand.Int63n(int64(bound) + 1))
+}
+
+// do calls op until it succeeds, fails with an error that isn't retryable,
+// or runs out of attempts or time.
+func (s *Storage) do(ctx context.Context, op func() error) error {
+	var err error
+	for n := 0; ; n++ {
+		err = op()
+		if err == nil || !core.IsRetryable(err) || n+1 >= s.attempts {
+			return err
+		}
+
+		wait := s.backoff(n)
+		if deadline, ok := ctx.Deadline(); ok && s.now().Add(wait).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cell, found, err = s.backend.Get(ctx, tblName, rowKey, columnKey, refKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cell, found, err = s.backend.GetLatest(ctx, tblName, rowKey, columnKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cells, found, err = s.backend.History(ctx, tblName, rowKey, columnKey, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cell, found, err = s.backend.GetLatestAsOf(ctx, tblName, rowKey, columnKey, asOf)
+		return err
+	})
+	return
+}
+
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cells, found, err = s.backend.GetRowAsOf(ctx, tblName, rowKey, asOf)
+		return err
+	})
+	return
+}
+
+func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	err = s.do(ctx, func() (err error) {
+		cells, found, err = s.backend.PartitionRead(ctx, tblName, partitionNumber, location, value, limit)
+		return err
+	})
+	return
+}
+
+// Put is tried once; see the package documentation.
+func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	return s.backend.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+// PutWithExpiry is tried once, like Put.
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) error {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	return expirer.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, expiresAt)
+}
+
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (n int64, err error) {
+	expirer, ok := s.backend.(core.Expirer)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrExpiryNotSupported, s.backend)
+	}
+	err = s.do(ctx, func() (err error) {
+		n, err = expirer.SweepExpired(ctx, tblName, now, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (n int64, err error) {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	err = s.do(ctx, func() (err error) {
+		n, err = purger.Purge(ctx, tblName, rowKey)
+		return err
+	})
+	return
+}
+
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) error {
+	purger, ok := s.backend.(core.Purger)
+	if !ok {
+		return fmt.Errorf("%w: %T", core.ErrPurgeNotSupported, s.backend)
+	}
+	return s.do(ctx, func() error {
+		return purger.PurgeCell(ctx, tblName, rowKey, columnKey, refKey)
+	})
+}
+
+// Rewrite forwards to the backend's Rewrite, so that an encryption wrapper
+// around the retrier can re-encrypt cells. Rewriting a cell twice with the
+// same body is harmless, so it is retried.
+func (s *Storage) Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	rw, ok := s.backend.(interface {
+		Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error
+	})
+	if !ok {
+		return fmt.Errorf("backend does not support rewriting cells: %T", s.backend)
+	}
+	return s.do(ctx, func() error {
+		return rw.Rewrite(ctx, tblName, rowKey, columnKey, refKey, body)
+	})
+}
+
+// Scan forwards to the backend's Scan, so that an encryption wrapper around
+// the retrier can re-encrypt cells.
+func (s *Storage) Scan(ctx context.Context, tblName string, addedAt int64, limit int) (cells []models.Cell, found bool, err error) {
+	sc, ok := s.backend.(interface {
+		Scan(ctx context.Context, tblName string, addedAt int64, limit int) ([]models.Cell, bool, error)
+	})
+	if !ok {
+		return nil, false, fmt.Errorf("backend does not support scanning cells: %T", s.backend)
+	}
+	err = s.do(ctx, func() (err error) {
+		cells, found, err = sc.Scan(ctx, tblName, addedAt, limit)
+		return err
+	})
+	return
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	pinger, ok := s.backend.(core.Pinger)
+	if !ok {
+		return nil
+	}
+	return s.do(ctx, func() error {
+		return pinger.Ping(ctx)
+	})
+}
+
+func (s *Storage) FindPartition(tblName, rowKey string) int {
+	return s.backend.FindPartition(tblName, rowKey)
+}
+
+func (s *Storage) ResetConnection(ctx context.Context, key string) error {
+	return s.backend.ResetConnection(ctx, key)
+}
+
+func (s *Storage) Destroy(ctx context.Context) error {
+	return s.backend.Destroy(ctx)
+}
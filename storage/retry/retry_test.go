@@ -0,0 +1,110 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+	"github.com/rbastic/go-schemaless/storagetest"
+)
+
+// flaky fails the first failures calls to GetLatest and Put with err.
+type flaky struct {
+	*sqlite.Storage
+	err      error
+	failures int
+	calls    int
+}
+
+func (f *flaky) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flaky) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	if err := f.fail(); err != nil {
+		return cell, false, err
+	}
+	return f.Storage.GetLatest(ctx, tblName, rowKey, columnKey)
+}
+
+func (f *flaky) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Storage.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+func TestRetrySQLite(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	storagetest.StorageTest(t, New(backend))
+}
+
+func TestRetry(t *testing.T) {
+	backend := storagetest.NewSQLite(t)
+
+	ctx := context.TODO()
+	err := backend.Put(ctx, "cell", "trip1", "BASE", 1, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transient := &core.StorageError{Class: core.ErrTransient, Err: errors.New("database is locked")}
+	shard := &flaky{Storage: backend, err: transient, failures: 2}
+	s := New(shard).WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+	// retryable failures are retried until the call succeeds
+	_, found, err := s.GetLatest(ctx, "cell", "trip1", "BASE")
+	if err != nil || !found || shard.calls != 3 {
+		t.Fatalf("expected success on the third attempt, got found=%v err=%v after %d calls", found, err, shard.calls)
+	}
+
+	// and given up on when the attempts run out
+	shard.calls, shard.failures = 0, 10
+	_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+	if !errors.Is(err, core.ErrTransient) || shard.calls != 3 {
+		t.Errorf("expected a transient error after 3 calls, got %v after %d calls", err, shard.calls)
+	}
+
+	// connection errors are retryable too
+	shard.calls, shard.failures, shard.err = 0, 1, driver.ErrBadConn
+	_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+	if err != nil || shard.calls != 2 {
+		t.Errorf("expected a connection error to be retried, got %v after %d calls", err, shard.calls)
+	}
+
+	// other errors are not
+	shard.calls, shard.failures, shard.err = 0, 1, &core.StorageError{Class: core.ErrPermanent, Err: errors.New("no such table")}
+	_, _, err = s.GetLatest(ctx, "cell", "trip1", "BASE")
+	if !errors.Is(err, core.ErrPermanent) || shard.calls != 1 {
+		t.Errorf("expected a permanent error to be returned at once, got %v after %d calls", err, shard.calls)
+	}
+
+	// nor are Puts, which aren't idempotent
+	shard.calls, shard.failures, shard.err = 0, 1, transient
+	err = s.Put(ctx, "cell", "trip1", "BASE", 2, "{}")
+	if !errors.Is(err, core.ErrTransient) || shard.calls != 1 {
+		t.Errorf("expected a Put not to be retried, got %v after %d calls", err, shard.calls)
+	}
+
+	// a backoff that would end after the deadline isn't waited for
+	s.WithBackoff(time.Hour, time.Hour).WithRand(rand.New(rand.NewSource(1)))
+	dctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	shard.calls, shard.failures = 0, 10
+	start := time.Now()
+	_, _, err = s.GetLatest(dctx, "cell", "trip1", "BASE")
+	if !errors.Is(err, core.ErrTransient) || time.Since(start) > time.Second {
+		t.Errorf("expected to give up before the deadline, got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,40 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// classifyError maps a sqlite error into core's error classes.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == sql.ErrNoRows {
+		return core.Classify(core.ErrNotFound, err)
+	}
+	if core.IsConnectionError(err) {
+		return core.Classify(core.ErrShardUnavailable, err)
+	}
+	if serr, ok := err.(sqlite3.Error); ok {
+		switch serr.Code {
+		case sqlite3.ErrConstraint:
+			if serr.ExtendedCode == sqlite3.ErrConstraintUnique || serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+				return core.Classify(core.ErrConflict, err)
+			}
+		case sqlite3.ErrBusy, sqlite3.ErrLocked:
+			return core.Classify(core.ErrTransient, err)
+		case sqlite3.ErrCantOpen, sqlite3.ErrIoErr:
+			return core.Classify(core.ErrShardUnavailable, err)
+		}
+	}
+	return core.Classify(core.ErrPermanent, err)
+}
+
+// classify classifies *err in place; defer it from methods with a named
+// error result.
+func classify(err *error) {
+	*err = classifyError(*err)
+}
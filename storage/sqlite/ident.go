@@ -0,0 +1,21 @@
+package sqlite
+
+import (
+	"strings"
+
+	"github.com/rbastic/go-schemaless/core"
+)
+
+// Quote returns name quoted as a sqlite identifier.
+func Quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// table checks tblName and returns it quoted, for use in a statement.
+func table(tblName string) (string, error) {
+	err := core.ValidateTableName(tblName)
+	if err != nil {
+		return "", err
+	}
+	return Quote(tblName), nil
+}
@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rbastic/go-schemaless/logging"
+	"github.com/rbastic/go-schemaless/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.uber.org/zap"
+)
+
+// WithLogger sets the logger of every statement the Storage runs; see
+// WithSlowQueries. The Storage logs nothing without one, or with a nil one.
+func (s *Storage) WithLogger(l *zap.Logger) *Storage {
+	if l == nil {
+		l = zap.NewNop()
+	}
+	s.log = l
+	return s
+}
+
+// WithSlog is WithLogger for a slog.Handler.
+func (s *Storage) WithSlog(h slog.Handler) *Storage {
+	return s.WithLogger(logging.FromSlog(h))
+}
+
+// WithSlowQueries sets how slow a statement must be to be logged above
+// debug level.
+func (s *Storage) WithSlowQueries(t logging.Thresholds) *Storage {
+	s.slow = t
+	return s
+}
+
+// statement starts the span of a SQL statement, and returns the function
+// that ends it and logs the statement.
+func (s *Storage) statement(ctx context.Context, op, tblName, query string) (context.Context, func(error)) {
+	ctx, span := tracing.StartSQL(ctx, semconv.DBSystemSqlite, op, tblName, query)
+	start := time.Now()
+	return ctx, func(err error) {
+		tracing.End(span, err)
+		logging.Statement(s.log, s.slow, op, tblName, query, time.Since(start), err)
+	}
+}
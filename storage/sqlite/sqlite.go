@@ -5,10 +5,15 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/logging"
 	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/storage/stmtcache"
 	"go.uber.org/zap"
 )
 
@@ -16,23 +21,48 @@ import (
 var ErrNotAtStorageLevel = errors.New("not implemented at storage level")
 
 type Storage struct {
+	// tblName and path locate the database file, for ResetConnection
+	tblName string
+	path    string
+
+	mu    sync.RWMutex
 	store *sql.DB
-	sugar *zap.SugaredLogger
+	stmts *stmtcache.Cache
+	// cacheSize bounds stmts; see WithStatementCache
+	cacheSize int
+	// log gets every statement; see WithLogger
+	log  *zap.Logger
+	slow logging.Thresholds
+	// now is the clock that decides which cells have expired
+	now func() time.Time
+	// timeouts bound calls whose context has no deadline; see WithTimeouts
+	timeouts core.Timeouts
 }
 
 const (
 	driver = "sqlite3"
 
-	createTableSQL      = "CREATE TABLE IF NOT EXISTS %s ( added_at INTEGER PRIMARY KEY AUTOINCREMENT, row_key VARCHAR(36) NOT NULL, column_name VARCHAR(64) NOT NULL, ref_key INTEGER NOT NULL, body TEXT, created_at INTEGER DEFAULT 0)"
-	createIndexSQL      = "CREATE UNIQUE INDEX IF NOT EXISTS uniq%s_idx ON %s ( row_key, column_name, ref_key )"
-	getCellSQL          = "SELECT added_at, row_key, column_name, ref_key, body, created_at FROM %s WHERE row_key = ? AND column_name = ? AND ref_key = ? LIMIT 1"
-	getCellLatestSQL    = "SELECT added_at, row_key, column_name, ref_key, body, created_at FROM %s WHERE row_key = ? AND column_name = ? ORDER BY ref_key DESC LIMIT 1"
-	getCellsForShardSQL = "SELECT added_at, row_key, column_name, ref_key, body, created_at FROM %s WHERE %s >= ? LIMIT %d"
-	putCellSQL          = "INSERT INTO %s ( row_key, column_name, ref_key, body, created_at ) VALUES(?, ?, ?, ?, ?)"
+	createTableSQL        = "CREATE TABLE IF NOT EXISTS %s ( added_at INTEGER PRIMARY KEY AUTOINCREMENT, row_key VARCHAR(36) NOT NULL, column_name VARCHAR(64) NOT NULL, ref_key INTEGER NOT NULL, body TEXT, created_at INTEGER DEFAULT 0)"
+	createIndexSQL        = "CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ( row_key, column_name, ref_key )"
+	createAsOfIndexSQL    = "CREATE INDEX IF NOT EXISTS %s ON %s ( row_key, column_name, created_at )"
+	getCellSQL            = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE row_key = ? AND column_name = ? AND ref_key = ? AND ( expires_at IS NULL OR expires_at > ? ) LIMIT 1"
+	getCellLatestSQL      = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM ( SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE row_key = ? AND column_name = ? ORDER BY ref_key DESC LIMIT 1 ) latest WHERE expires_at IS NULL OR expires_at > ?"
+	getCellLatestAsOfSQL  = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM ( SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE row_key = ? AND column_name = ? AND created_at <= ? ORDER BY ref_key DESC LIMIT 1 ) latest WHERE expires_at IS NULL OR expires_at > ?"
+	getRowAsOfSQL         = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s c WHERE row_key = ? AND ref_key = ( SELECT MAX(ref_key) FROM %s WHERE row_key = c.row_key AND column_name = c.column_name AND created_at <= ? ) AND ( expires_at IS NULL OR expires_at > ? ) ORDER BY column_name"
+	getCellHistorySQL     = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE row_key = ? AND column_name = ? AND ( expires_at IS NULL OR expires_at > ? ) ORDER BY ref_key DESC LIMIT %d"
+	getCellsForShardSQL   = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE %s >= ? AND ( expires_at IS NULL OR expires_at > ? ) ORDER BY %s LIMIT %d"
+	scanCellsSQL          = "SELECT added_at, row_key, column_name, ref_key, body, created_at, expires_at FROM %s WHERE added_at >= ? ORDER BY added_at LIMIT %d"
+	putCellSQL            = "INSERT INTO %s ( row_key, column_name, ref_key, body, created_at, expires_at ) VALUES(?, ?, ?, ?, ?, ?)"
+	rewriteCellSQL        = "UPDATE %s SET body = ? WHERE row_key = ? AND column_name = ? AND ref_key = ?"
+	purgeRowSQL           = "DELETE FROM %s WHERE row_key = ?"
+	purgeCellSQL          = "DELETE FROM %s WHERE row_key = ? AND column_name = ? AND ref_key = ?"
+	sweepExpiredSQL       = "DELETE FROM %s WHERE added_at IN ( SELECT added_at FROM %s WHERE expires_at <= ? LIMIT %d )"
+	addExpiresAtSQL       = "ALTER TABLE %s ADD COLUMN expires_at INTEGER"
+	createExpiresIndexSQL = "CREATE INDEX IF NOT EXISTS %s ON %s ( expires_at )"
 )
 
-func exec(db *sql.DB, sqlStr string) error {
-	_, err := db.Exec(sqlStr)
+func exec(ctx context.Context, db *sql.DB, sqlStr string) error {
+	_, err := db.ExecContext(ctx, sqlStr)
 	if err != nil {
 		return err
 	}
@@ -40,48 +70,166 @@ func exec(db *sql.DB, sqlStr string) error {
 }
 
 func CreateTable(ctx context.Context, db *sql.DB, tblName string) error {
-	return exec(db, fmt.Sprintf(createTableSQL, tblName))
+	tbl, err := table(tblName)
+	if err != nil {
+		return err
+	}
+	err = exec(ctx, db, fmt.Sprintf(createTableSQL, tbl))
+	if err != nil {
+		return err
+	}
+
+	// tables created before cells could expire lack expires_at
+	var n int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'expires_at'", tblName).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	return exec(ctx, db, fmt.Sprintf(addExpiresAtSQL, tbl))
 }
 
 func CreateIndex(ctx context.Context, db *sql.DB, tblName string) error {
-	return exec(db, fmt.Sprintf(createIndexSQL, tblName, tblName))
+	tbl, err := table(tblName)
+	if err != nil {
+		return err
+	}
+	err = exec(ctx, db, fmt.Sprintf(createIndexSQL, Quote("uniq"+tblName+"_idx"), tbl))
+	if err != nil {
+		return err
+	}
+	// supports GetLatestAsOf and GetRowAsOf
+	err = exec(ctx, db, fmt.Sprintf(createAsOfIndexSQL, Quote("asof"+tblName+"_idx"), tbl))
+	if err != nil {
+		return err
+	}
+	// supports SweepExpired
+	return exec(ctx, db, fmt.Sprintf(createExpiresIndexSQL, Quote("exp"+tblName+"_idx"), tbl))
 }
 
-// New returns a new sqlite file-backed Storage
-func New(tblName, path string) (*Storage, error) {
-	db, err := sql.Open(driver, path+"_"+tblName+".db")
+// Migrations returns the forward-only schema changes for a cell table, in
+// order. Applying the first n of them brings a table to schema version n.
+// tblName is quoted but not checked; see core.ValidateTableName.
+func Migrations(tblName string) []string {
+	tbl := Quote(tblName)
+	return []string{
+		fmt.Sprintf(createTableSQL, tbl),
+		fmt.Sprintf(createIndexSQL, Quote("uniq"+tblName+"_idx"), tbl),
+		fmt.Sprintf(createAsOfIndexSQL, Quote("asof"+tblName+"_idx"), tbl),
+		fmt.Sprintf(addExpiresAtSQL, tbl),
+		fmt.Sprintf(createExpiresIndexSQL, Quote("exp"+tblName+"_idx"), tbl),
+	}
+}
+
+// Open opens the sqlite database file that New uses for tblName and path.
+func Open(tblName, path string) (*sql.DB, error) {
+	err := core.ValidateTableName(tblName)
 	if err != nil {
 		return nil, err
 	}
+	return sql.Open(driver, path+"_"+tblName+".db")
+}
 
-	err = CreateTable(context.TODO(), db, tblName)
+// New returns a new sqlite file-backed Storage
+func New(tblName, path string) (*Storage, error) {
+	db, err := Open(tblName, path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = CreateIndex(context.TODO(), db, tblName)
+	err = CreateTable(context.TODO(), db, tblName)
 	if err != nil {
 		return nil, err
 	}
 
-	logger, err := zap.NewProduction()
+	err = CreateIndex(context.TODO(), db, tblName)
 	if err != nil {
 		return nil, err
 	}
-	s := logger.Sugar()
 
 	return &Storage{
 		// initialize top-level
-		store: db,
-		sugar: s,
+		tblName:   tblName,
+		path:      path,
+		store:     db,
+		stmts:     stmtcache.New(db, stmtcache.DefaultSize),
+		cacheSize: stmtcache.DefaultSize,
+		log:       zap.NewNop(),
+		now:       time.Now,
 	}, nil
 }
 
 func (s *Storage) GetDB() *sql.DB {
+	return s.db()
+}
+
+// Stats returns the statistics of the connection pool, which start again
+// from zero when ResetConnection replaces it.
+func (s *Storage) Stats() sql.DBStats {
+	return s.db().Stats()
+}
+
+// db returns the current connection pool, which ResetConnection replaces.
+func (s *Storage) db() *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.store
 }
 
+// cache returns the prepared statements of the current connection pool.
+func (s *Storage) cache() *stmtcache.Cache {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stmts
+}
+
+// query runs the cached statement of query.
+func (s *Storage) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, release, err := s.cache().Prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.QueryContext(ctx, args...)
+}
+
+// execute runs the cached statement of query.
+func (s *Storage) execute(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, release, err := s.cache().Prepare(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return stmt.ExecContext(ctx, args...)
+}
+
+// WithStatementCache sets how many prepared statements the Storage keeps,
+// stmtcache.DefaultSize unless set. Zero prepares every statement afresh.
+func (s *Storage) WithStatementCache(size int) *Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cacheSize = size
+	if s.stmts != nil {
+		s.stmts.Resize(size)
+	}
+	return s
+}
+
+// Ping checks the connection to the database.
+func (s *Storage) Ping(ctx context.Context) (err error) {
+	defer classify(&err)
+
+	return s.db().PingContext(ctx)
+}
+
 func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (cell models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
 	var (
 		resAddedAt   int64
 		resRowKey    string
@@ -89,13 +237,18 @@ func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, re
 		resRefKey    int64
 		resBody      string
 		resCreatedAt int64
+		resExpiresAt sql.NullInt64
 		rows         *sql.Rows
 	)
-	sqlQuery := fmt.Sprintf(getCellSQL, tblName)
-
-	//s.sugar.Infow("Get", "query", sqlQuery, "rowKey", rowKey, "columnKey", columnKey, "refKey", refKey)
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlQuery := fmt.Sprintf(getCellSQL, tbl)
 
-	rows, err = s.store.Query(sqlQuery, rowKey, columnKey, refKey)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlQuery)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlQuery, rowKey, columnKey, refKey, s.now().UnixNano())
 	if err != nil {
 		return
 	}
@@ -103,11 +256,10 @@ func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, re
 
 	found = false
 	for rows.Next() {
-		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt)
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
 		if err != nil {
 			return
 		}
-		//s.sugar.Infow("Get scanned data", "AddedAt", resAddedAt, "RowKey", resRowKey, "ColName", resColName, "RefKey", resRefKey, "Body", resBody, "CreatedAt", resCreatedAt)
 
 		cell.AddedAt = resAddedAt
 		cell.RowKey = resRowKey
@@ -115,6 +267,7 @@ func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, re
 		cell.RefKey = resRefKey
 		cell.Body = resBody
 		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
 		found = true
 	}
 
@@ -127,6 +280,129 @@ func (s *Storage) Get(ctx context.Context, tblName, rowKey, columnKey string, re
 }
 
 func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (cell models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var (
+		resAddedAt   int64
+		resRowKey    string
+		resColName   string
+		resRefKey    int64
+		resBody      string
+		resCreatedAt int64
+		resExpiresAt sql.NullInt64
+		rows         *sql.Rows
+	)
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlQuery := fmt.Sprintf(getCellLatestSQL, tbl)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlQuery)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlQuery, rowKey, columnKey, s.now().UnixNano())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	found = false
+	for rows.Next() {
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
+		if err != nil {
+			return
+		}
+
+		cell.AddedAt = resAddedAt
+		cell.RowKey = resRowKey
+		cell.ColumnName = resColName
+		cell.RefKey = resRefKey
+		cell.Body = resBody
+		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
+		found = true
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	return cell, found, nil
+}
+
+// History returns up to limit cells for a given rowKey and columnKey, ordered
+// from the highest ref_key to the lowest. Expired cells are left out unless
+// ctx is from core.IncludeExpired.
+func (s *Storage) History(ctx context.Context, tblName, rowKey, columnKey string, limit int) (cells []models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var (
+		resAddedAt   int64
+		resRowKey    string
+		resColName   string
+		resRefKey    int64
+		resBody      string
+		resCreatedAt int64
+		resExpiresAt sql.NullInt64
+		rows         *sql.Rows
+	)
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlQuery := fmt.Sprintf(getCellHistorySQL, tbl, limit)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlQuery)
+	defer func() { done(err) }()
+	cutoff := s.now().UnixNano()
+	if core.IncludesExpired(ctx) {
+		cutoff = math.MinInt64
+	}
+	rows, err = s.query(ctx, sqlQuery, rowKey, columnKey, cutoff)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	found = false
+	for rows.Next() {
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
+		if err != nil {
+			return
+		}
+
+		var cell models.Cell
+		cell.AddedAt = resAddedAt
+		cell.RowKey = resRowKey
+		cell.ColumnName = resColName
+		cell.RefKey = resRefKey
+		cell.Body = resBody
+		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
+		cells = append(cells, cell)
+		found = true
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	return cells, found, nil
+}
+
+// GetLatestAsOf returns the highest ref_key cell for a given rowKey and
+// columnKey that was created at or before asOf.
+func (s *Storage) GetLatestAsOf(ctx context.Context, tblName, rowKey, columnKey string, asOf time.Time) (cell models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
 	var (
 		resAddedAt   int64
 		resRowKey    string
@@ -134,12 +410,18 @@ func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey stri
 		resRefKey    int64
 		resBody      string
 		resCreatedAt int64
+		resExpiresAt sql.NullInt64
 		rows         *sql.Rows
 	)
-	//s.sugar.Infow("GetLatest", "query", getCellSQL, "rowKey", rowKey, "columnKey", columnKey)
 
-	sqlQuery := fmt.Sprintf(getCellLatestSQL, tblName)
-	rows, err = s.store.Query(sqlQuery, rowKey, columnKey)
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlQuery := fmt.Sprintf(getCellLatestAsOfSQL, tbl)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlQuery)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlQuery, rowKey, columnKey, asOf.UTC().UnixNano(), s.now().UnixNano())
 	if err != nil {
 		return
 	}
@@ -147,11 +429,10 @@ func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey stri
 
 	found = false
 	for rows.Next() {
-		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt)
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
 		if err != nil {
 			return
 		}
-		//s.sugar.Infow("GetLatest scanned data", "AddedAt", resAddedAt, "RowKey", resRowKey, "ColName", resColName, "RefKey", resRefKey, "Body", resBody, "CreatedAt", resCreatedAt)
 
 		cell.AddedAt = resAddedAt
 		cell.RowKey = resRowKey
@@ -159,6 +440,7 @@ func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey stri
 		cell.RefKey = resRefKey
 		cell.Body = resBody
 		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
 		found = true
 	}
 
@@ -170,11 +452,72 @@ func (s *Storage) GetLatest(ctx context.Context, tblName, rowKey, columnKey stri
 	return cell, found, nil
 }
 
+// GetRowAsOf returns, for every column of rowKey, the highest ref_key cell
+// that was created at or before asOf.
+func (s *Storage) GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) (cells []models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var (
+		resAddedAt   int64
+		resRowKey    string
+		resColName   string
+		resRefKey    int64
+		resBody      string
+		resCreatedAt int64
+		resExpiresAt sql.NullInt64
+		rows         *sql.Rows
+	)
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlQuery := fmt.Sprintf(getRowAsOfSQL, tbl, tbl)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlQuery)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlQuery, rowKey, asOf.UTC().UnixNano(), s.now().UnixNano())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	found = false
+	for rows.Next() {
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
+		if err != nil {
+			return
+		}
+
+		var cell models.Cell
+		cell.AddedAt = resAddedAt
+		cell.RowKey = resRowKey
+		cell.ColumnName = resColName
+		cell.RefKey = resRefKey
+		cell.Body = resBody
+		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
+		cells = append(cells, cell)
+		found = true
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	return cells, found, nil
+}
+
 func (s *Storage) FindPartition(tblName, rowKey string) int {
 	panic("FindPartition not implemented at storage level")
 }
 
 func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNumber int, location string, value int64, limit int) (cells []models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Scan)
+	defer cancel()
 
 	var (
 		resAddedAt   int64
@@ -183,6 +526,7 @@ func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNu
 		resRefKey    int64
 		resBody      string
 		resCreatedAt int64
+		resExpiresAt sql.NullInt64
 
 		locationColumn string
 	)
@@ -201,11 +545,16 @@ func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNu
 		return
 	}
 
-	sqlStr := fmt.Sprintf(getCellsForShardSQL, tblName, locationColumn, limit)
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlStr := fmt.Sprintf(getCellsForShardSQL, tbl, locationColumn, locationColumn, limit)
 
 	var rows *sql.Rows
-	s.sugar.Infow("PartitionRead", "query", sqlStr, "value", value)
-	rows, err = s.store.Query(sqlStr, value)
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlStr)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlStr, value, s.now().UnixNano())
 	if err != nil {
 		return
 	}
@@ -213,11 +562,10 @@ func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNu
 
 	found = false
 	for rows.Next() {
-		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt)
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
 		if err != nil {
 			return
 		}
-		//s.sugar.Infow("PartitionRead: scanned data", "AddedAt", resAddedAt, "RowKey", resRowKey, "ColName", resColName, "RefKey", resRefKey, "Body", resBody, "CreatedAt", resCreatedAt)
 
 		var cell models.Cell
 		cell.AddedAt = resAddedAt
@@ -226,6 +574,7 @@ func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNu
 		cell.RefKey = resRefKey
 		cell.Body = resBody
 		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
 		cells = append(cells, cell)
 		found = true
 	}
@@ -239,16 +588,32 @@ func (s *Storage) PartitionRead(ctx context.Context, tblName string, partitionNu
 }
 
 func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) (err error) {
-	createdAt := time.Now().UTC().UnixNano()
-	var stmt *sql.Stmt
-	stmt, err = s.store.Prepare(fmt.Sprintf(putCellSQL, tblName))
+	return s.PutWithExpiry(ctx, tblName, rowKey, columnKey, refKey, body, time.Time{})
+}
+
+// PutWithExpiry is Put for a cell that expires at expiresAt. Once expired,
+// a cell is no longer read back, and SweepExpired deletes it. A zero
+// expiresAt never expires.
+func (s *Storage) PutWithExpiry(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string, expiresAt time.Time) (err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	createdAt := s.now().UTC().UnixNano()
+	var expires sql.NullInt64
+	if !expiresAt.IsZero() {
+		expires = sql.NullInt64{Int64: expiresAt.UnixNano(), Valid: true}
+	}
+	tbl, err := table(tblName)
 	if err != nil {
 		return err
 	}
+	query := fmt.Sprintf(putCellSQL, tbl)
+	ctx, done := s.statement(ctx, "INSERT", tblName, query)
+	defer func() { done(err) }()
 	var res sql.Result
 
-
-	res, err = stmt.Exec(rowKey, columnKey, refKey, body, createdAt)
+	res, err = s.execute(ctx, query, rowKey, columnKey, refKey, body, createdAt, expires)
 	if err != nil {
 		return err
 	}
@@ -263,13 +628,195 @@ func (s *Storage) Put(ctx context.Context, tblName, rowKey, columnKey string, re
 	return nil
 }
 
-// ResetConnection closes the store.
+// SweepExpired deletes up to limit cells of tblName that expired at or
+// before now, and returns how many were deleted.
+func (s *Storage) SweepExpired(ctx context.Context, tblName string, now time.Time, limit int) (n int64, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Sweep)
+	defer cancel()
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf(sweepExpiredSQL, tbl, tbl, limit)
+	ctx, done := s.statement(ctx, "DELETE", tblName, query)
+	defer func() { done(err) }()
+	res, err := s.execute(ctx, query, now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// WithClock sets the clock that decides which cells have expired.
+func (s *Storage) WithClock(now func() time.Time) *Storage {
+	s.now = now
+	return s
+}
+
+// WithTimeouts sets the default deadlines of the Storage's calls to its
+// database, by operation.
+func (s *Storage) WithTimeouts(t core.Timeouts) *Storage {
+	s.timeouts = t
+	return s
+}
+
+// Rewrite replaces the body of an existing cell in place. Cells are
+// otherwise immutable; this exists so that a body can be re-encoded (e.g.
+// re-encrypted under a new key) without changing what it decodes to.
+func (s *Storage) Rewrite(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) (err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(rewriteCellSQL, tbl)
+	ctx, done := s.statement(ctx, "UPDATE", tblName, query)
+	defer func() { done(err) }()
+	res, err := s.execute(ctx, query, body, rowKey, columnKey, refKey)
+	if err != nil {
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowCnt == 0 {
+		return fmt.Errorf("rewriting %s (%s, %s, %d): %w", tblName, rowKey, columnKey, refKey, core.ErrNotFound)
+	}
+	return nil
+}
+
+// Scan returns up to limit cells of tblName from added_at addedAt on, in
+// added_at order. Unlike PartitionRead it includes cells that have expired
+// but not yet been swept, so that maintenance such as re-encryption can
+// reach every cell in the table.
+func (s *Storage) Scan(ctx context.Context, tblName string, addedAt int64, limit int) (cells []models.Cell, found bool, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Scan)
+	defer cancel()
+
+	var (
+		resAddedAt   int64
+		resRowKey    string
+		resColName   string
+		resRefKey    int64
+		resBody      string
+		resCreatedAt int64
+		resExpiresAt sql.NullInt64
+	)
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return
+	}
+	sqlStr := fmt.Sprintf(scanCellsSQL, tbl, limit)
+
+	var rows *sql.Rows
+	ctx, done := s.statement(ctx, "SELECT", tblName, sqlStr)
+	defer func() { done(err) }()
+	rows, err = s.query(ctx, sqlStr, addedAt)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = rows.Scan(&resAddedAt, &resRowKey, &resColName, &resRefKey, &resBody, &resCreatedAt, &resExpiresAt)
+		if err != nil {
+			return
+		}
+
+		var cell models.Cell
+		cell.AddedAt = resAddedAt
+		cell.RowKey = resRowKey
+		cell.ColumnName = resColName
+		cell.RefKey = resRefKey
+		cell.Body = resBody
+		cell.CreatedAt = resCreatedAt
+		cell.ExpiresAt = resExpiresAt.Int64
+		cells = append(cells, cell)
+		found = true
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	return cells, found, nil
+}
+
+// Purge deletes every cell of rowKey and returns how many were deleted. It
+// is the only way cells leave a table, and exists for erasure requests.
+func (s *Storage) Purge(ctx context.Context, tblName, rowKey string) (n int64, err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf(purgeRowSQL, tbl)
+	ctx, done := s.statement(ctx, "DELETE", tblName, query)
+	defer func() { done(err) }()
+	res, err := s.execute(ctx, query, rowKey)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeCell deletes a single cell, e.g. a secondary index entry pointing at
+// a purged row. Deleting a cell that does not exist is not an error.
+func (s *Storage) PurgeCell(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (err error) {
+	defer classify(&err)
+	ctx, cancel := core.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	tbl, err := table(tblName)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(purgeCellSQL, tbl)
+	ctx, done := s.statement(ctx, "DELETE", tblName, query)
+	defer func() { done(err) }()
+	_, err = s.execute(ctx, query, rowKey, columnKey, refKey)
+	return err
+}
+
+// ResetConnection replaces the connection pool with a newly opened one,
+// and closes the old one once the queries using it have finished.
 func (s *Storage) ResetConnection(ctx context.Context, key string) error {
-	return s.store.Close()
+	db, err := Open(s.tblName, s.path)
+	if err != nil {
+		return classifyError(err)
+	}
+	return s.swap(db).Close()
+}
+
+// swap replaces the connection pool, returning the old one, and closes the
+// statements prepared on the old one.
+func (s *Storage) swap(db *sql.DB) *sql.DB {
+	s.mu.Lock()
+	old, oldStmts := s.store, s.stmts
+	s.store, s.stmts = db, stmtcache.New(db, s.cacheSize)
+	s.mu.Unlock()
+
+	if oldStmts != nil {
+		oldStmts.Close()
+	}
+	return old
 }
 
 // Destroy closes the store
 func (s *Storage) Destroy(ctx context.Context) error {
-	s.sugar.Sync()
-	return s.store.Close()
+	s.log.Sync()
+	s.cache().Close()
+	return s.db().Close()
 }
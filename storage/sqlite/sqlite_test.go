@@ -1,10 +1,22 @@
-package sqlite
+package sqlite_test
 
 import (
-	"github.com/rbastic/go-schemaless/storagetest"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/logging"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
+	"github.com/rbastic/go-schemaless/storage/stmtcache"
+	"github.com/rbastic/go-schemaless/storagetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestSQLite(t *testing.T) {
@@ -15,7 +27,7 @@ func TestSQLite(t *testing.T) {
 		t.Skipf("Unable to create temporary directory: %s", err)
 	}
 
-	m, err := New("cell", dir)
+	m, err := sqlite.New("cell", dir)
 	if err != nil {
 		t.Skipf("Unable to create sqlite storage adapter: %s", err)
 	}
@@ -24,3 +36,115 @@ func TestSQLite(t *testing.T) {
 	// cleanup
 	os.RemoveAll(dir)
 }
+
+func TestSlowQueries(t *testing.T) {
+	m := storagetest.NewSQLite(t)
+
+	// statements run silently without a logger
+	err := m.Put(context.TODO(), "cell", "trip1", "BASE", 1, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	m.WithLogger(zap.New(core)).WithSlowQueries(logging.Thresholds{Warn: time.Nanosecond})
+	_, _, err = m.GetLatest(context.TODO(), "cell", "trip1", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterMessage("slow statement").All()
+	if len(entries) != 1 || entries[0].Level != zapcore.WarnLevel || entries[0].ContextMap()["op"] != "SELECT" {
+		t.Errorf("expected a slow SELECT to be logged at warn level, got %v", logs.All())
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	m := storagetest.NewSQLite(t)
+
+	m.WithTimeouts(core.Timeouts{Read: time.Nanosecond, Write: time.Minute})
+	err := m.Put(context.TODO(), "cell", "trip1", "BASE", 1, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = m.GetLatest(context.TODO(), "cell", "trip1", "BASE")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a read to time out, got %v", err)
+	}
+
+	// the caller's earlier deadline wins
+	m.WithTimeouts(core.Timeouts{Read: time.Minute})
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+	_, _, err = m.GetLatest(ctx, "cell", "trip1", "BASE")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's deadline to be kept, got %v", err)
+	}
+}
+
+// benchmarkStorage runs op against a fresh sqlite Storage, once with the
+// statement cache and once preparing every statement afresh.
+func benchmarkStorage(b *testing.B, op func(b *testing.B, m *sqlite.Storage, i int)) {
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"uncached", 0},
+		{"cached", stmtcache.DefaultSize},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := storagetest.NewSQLite(b)
+			m.WithStatementCache(bc.size)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op(b, m, i)
+			}
+		})
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	benchmarkStorage(b, func(b *testing.B, m *sqlite.Storage, i int) {
+		err := m.Put(context.TODO(), "cell", "trip"+strconv.Itoa(i), "BASE", 1, "{}")
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkGetLatest(b *testing.B) {
+	benchmarkStorage(b, func(b *testing.B, m *sqlite.Storage, i int) {
+		if i == 0 {
+			err := m.Put(context.TODO(), "cell", "trip1", "BASE", 1, "{}")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		_, found, err := m.GetLatest(context.TODO(), "cell", "trip1", "BASE")
+		if err != nil || !found {
+			b.Fatalf("found=%v err=%v", found, err)
+		}
+	})
+}
+
+func TestQuote(t *testing.T) {
+	m := storagetest.NewSQLite(t)
+
+	// a quoted name is a single identifier, however it is spelled
+	name := `cell"; DROP TABLE cell; --`
+	_, err := m.GetDB().Exec("CREATE TABLE " + sqlite.Quote(name) + " ( id INTEGER )")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = m.GetDB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('cell', ?)", name).Scan(&n)
+	if err != nil || n != 2 {
+		t.Errorf("expected both tables to exist, got %d: %v", n, err)
+	}
+
+	_, err = sqlite.New("../cell", t.TempDir())
+	if !errors.Is(err, core.ErrInvalidTableName) {
+		t.Errorf("expected a table name outside the directory to be rejected, got %v", err)
+	}
+}
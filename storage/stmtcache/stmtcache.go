@@ -0,0 +1,175 @@
+// Package stmtcache keeps the prepared statements of a SQL backend, so that
+// a query is prepared once per connection pool rather than on every call.
+//
+//	stmt, release, err := cache.Prepare(ctx, query)
+//	if err != nil {
+//		return err
+//	}
+//	defer release()
+//	rows, err := stmt.QueryContext(ctx, args...)
+package stmtcache
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// DefaultSize is the number of statements a backend keeps unless told
+// otherwise.
+const DefaultSize = 100
+
+// Cache holds up to a bounded number of prepared statements of a
+// connection pool, by query. Queries are built with the name of their
+// table, so each table's statements are cached apart. When the cache is
+// full, the least recently used statement is closed once no call is using
+// it.
+type Cache struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element // of *entry
+	lru     *list.List               // most recently used first
+	closed  bool
+}
+
+type entry struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int  // calls using the statement
+	evicted bool // close it when refs reaches zero
+}
+
+// New returns an empty Cache of up to size statements of db. A size of zero
+// or less caches nothing: every statement is closed after its call.
+func New(db *sql.DB, size int) *Cache {
+	return &Cache{
+		db:      db,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Prepare returns the prepared statement of query, preparing it if it isn't
+// cached, and a function to call once the statement has been run. Rows
+// returned by the statement stay usable after the release.
+func (c *Cache) Prepare(ctx context.Context, query string) (*sql.Stmt, func(), error) {
+	if stmt, release := c.get(query); stmt != nil {
+		return stmt, release, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.put(query, stmt)
+}
+
+// get returns the cached statement of query, if there is one.
+func (c *Cache) get(query string) (*sql.Stmt, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[query]
+	if !ok {
+		return nil, nil
+	}
+	c.lru.MoveToFront(el)
+	e := el.Value.(*entry)
+	e.refs++
+	return e.stmt, c.releaser(e)
+}
+
+// put caches a newly prepared statement, unless another call cached one for
+// the same query first.
+func (c *Cache) put(query string, stmt *sql.Stmt) (*sql.Stmt, func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[query]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(el)
+		e := el.Value.(*entry)
+		e.refs++
+		return e.stmt, c.releaser(e), nil
+	}
+
+	e := &entry{query: query, stmt: stmt, refs: 1}
+	if c.closed || c.size <= 0 {
+		e.evicted = true
+		return stmt, c.releaser(e), nil
+	}
+	c.entries[query] = c.lru.PushFront(e)
+	c.evict()
+	return stmt, c.releaser(e), nil
+}
+
+// releaser returns the function that ends a call's use of e. c.mu must be
+// held.
+func (c *Cache) releaser(e *entry) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			e.refs--
+			if e.evicted && e.refs == 0 {
+				e.stmt.Close()
+			}
+		})
+	}
+}
+
+// evict removes the least recently used statements until the cache is
+// within its size. c.mu must be held.
+func (c *Cache) evict() {
+	for c.lru.Len() > 0 && c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove takes a statement out of the cache, closing it unless a call is
+// using it. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.query)
+	e.evicted = true
+	if e.refs == 0 {
+		e.stmt.Close()
+	}
+}
+
+// Resize changes the number of statements the cache holds, closing those
+// that no longer fit.
+func (c *Cache) Resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = size
+	c.evict()
+}
+
+// Len returns the number of cached statements.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Close closes every cached statement, or has it closed once no call is
+// using it. Statements prepared after Close are not cached.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
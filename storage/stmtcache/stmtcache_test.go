@@ -0,0 +1,126 @@
+package stmtcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestCache(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	ctx := context.TODO()
+	c := New(db, 2)
+
+	a, releaseA, err := c.Prepare(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseA()
+	again, release, err := c.Prepare(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if again != a {
+		t.Errorf("expected the cached statement to be reused")
+	}
+
+	// a statement in use when it is evicted stays open until released
+	b, releaseB, err := c.Prepare(ctx, "SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i <= 4; i++ {
+		_, release, err := c.Prepare(ctx, fmt.Sprintf("SELECT %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cached statements, got %d", c.Len())
+	}
+	if _, err := a.Exec(); err == nil {
+		t.Errorf("expected the least recently used statement to be closed")
+	}
+	if _, err := b.Exec(); err != nil {
+		t.Errorf("expected a statement in use to stay open, got %v", err)
+	}
+	releaseB()
+	if _, err := b.Exec(); err == nil {
+		t.Errorf("expected an evicted statement to be closed once released")
+	}
+
+	// Close closes everything, and nothing is cached after it
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, release, err = c.Prepare(ctx, "SELECT 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if c.Len() != 0 {
+		t.Errorf("expected a closed cache to stay empty, got %d statements", c.Len())
+	}
+}
+
+func TestUncached(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	c := New(db, 0)
+	stmt, release, err := c.Prepare(context.TODO(), "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if _, err := stmt.Exec(); err == nil || c.Len() != 0 {
+		t.Errorf("expected a size 0 cache to close statements after use")
+	}
+}
+
+func TestConcurrentPrepare(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	c := New(db, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stmt, release, err := c.Prepare(context.TODO(), fmt.Sprintf("SELECT %d", i%8))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			var n int
+			err = stmt.QueryRow().Scan(&n)
+			if err != nil || n != i%8 {
+				t.Errorf("SELECT %d returned %d, %v", i%8, n, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Len() > 4 {
+		t.Errorf("expected at most 4 cached statements, got %d", c.Len())
+	}
+}
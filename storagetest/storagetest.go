@@ -2,18 +2,23 @@ package storagetest
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
 	"github.com/rbastic/go-schemaless/models"
+	"github.com/rbastic/go-schemaless/storage/sqlite"
 )
 
 const (
 	sqlDateFormat = "2006-01-02 15:04:05" // TODO: Hmm, should we make this a constant somewhere? Likely.
 	tblName       = "cell"
 	baseCol       = "BASE"
+	statusCol     = "STATUS"
 	otherCellID   = "hello"
 	testString    = "{\"value\": \"The shaved yak drank from the bitter well\"}"
 	testString2   = "{\"value\": \"The printer is on fire\"}"
@@ -40,6 +45,25 @@ func runPuts(t *testing.T, storage schemaless.Storage) string {
 	return cellID
 }
 
+// NewSQLite returns a sqlite Storage of the cell table in a temporary
+// directory, which is destroyed when the test ends.
+func NewSQLite(t testing.TB) *sqlite.Storage {
+	t.Helper()
+	return NewSQLiteTable(t, tblName)
+}
+
+// NewSQLiteTable is NewSQLite for a table named tblName.
+func NewSQLiteTable(t testing.TB, tblName string) *sqlite.Storage {
+	t.Helper()
+
+	backend, err := sqlite.New(tblName, t.TempDir())
+	if err != nil {
+		t.Fatalf("Unable to create sqlite storage adapter: %s", err)
+	}
+	t.Cleanup(func() { backend.Destroy(context.TODO()) })
+	return backend
+}
+
 // StorageTest is a simple sanity check for a schemaless Storage backend
 func StorageTest(t *testing.T, storage schemaless.Storage) {
 	startTime := time.Now().UTC().UnixNano()
@@ -59,6 +83,11 @@ func StorageTest(t *testing.T, storage schemaless.Storage) {
 
 	cellID := runPuts(t, storage)
 
+	err = storage.Put(ctx, tblName, cellID, baseCol, 1, testString)
+	if !errors.Is(err, core.ErrConflict) {
+		t.Errorf("Put of an existing cell expected a conflict, got: %v\n", err)
+	}
+
 	v, ok, err = storage.GetLatest(ctx, tblName, cellID, baseCol)
 	if err != nil {
 		t.Fatal(err)
@@ -75,6 +104,61 @@ func StorageTest(t *testing.T, storage schemaless.Storage) {
 		t.Errorf("Get failed when retrieving an old value: body:%s ok=%v\n", string(v.Body), ok)
 	}
 
+	var history []models.Cell
+	history, ok, err = storage.History(ctx, tblName, cellID, baseCol, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(history) != 2 || history[0].Body != testString3 || history[1].Body != testString2 {
+		t.Errorf("History returned unexpected cells: %v ok=%v\n", history, ok)
+	}
+
+	v, ok, err = storage.GetLatestAsOf(ctx, tblName, cellID, baseCol, time.Unix(0, startTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("GetLatestAsOf found a cell written after the as-of time: v=%v\n", v)
+	}
+
+	v, ok, err = storage.GetLatestAsOf(ctx, tblName, cellID, baseCol, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || string(v.Body) != testString3 {
+		t.Errorf("GetLatestAsOf failed getting a valid key: v='%s' ok=%v\n", string(v.Body), ok)
+	}
+
+	err = storage.Put(ctx, tblName, cellID, statusCol, 1, testString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var row []models.Cell
+	row, ok, err = storage.GetRowAsOf(ctx, tblName, cellID, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(row) != 2 {
+		t.Fatalf("GetRowAsOf expected 2 cells, got: %v\n", row)
+	}
+	for _, c := range row {
+		if c.ColumnName == baseCol && c.Body != testString3 {
+			t.Errorf("GetRowAsOf returned a stale %s cell: %v\n", baseCol, c)
+		}
+		if c.ColumnName == statusCol && c.Body != testString {
+			t.Errorf("GetRowAsOf returned a bad %s cell: %v\n", statusCol, c)
+		}
+	}
+
+	row, ok, err = storage.GetRowAsOf(ctx, tblName, cellID, time.Unix(0, startTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("GetRowAsOf found cells written after the as-of time: %v\n", row)
+	}
+
 	partNo := 0
 
 	var cells []models.Cell
@@ -90,8 +174,243 @@ func StorageTest(t *testing.T, storage schemaless.Storage) {
 		t.Fatal("we have an obvious problem")
 	}
 
+	if purger, ok := storage.(core.Purger); ok {
+		err = purger.PurgeCell(ctx, tblName, cellID, statusCol, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err = storage.GetLatest(ctx, tblName, cellID, statusCol)
+		if err != nil || ok {
+			t.Errorf("PurgeCell left the cell behind: ok=%v err=%v\n", ok, err)
+		}
+
+		var n int64
+		n, err = purger.Purge(ctx, tblName, cellID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("Purge expected to delete 3 cells, deleted %d\n", n)
+		}
+		history, ok, err = storage.History(ctx, tblName, cellID, baseCol, 10)
+		if err != nil || ok {
+			t.Errorf("Purge left cells behind: %v err=%v\n", history, err)
+		}
+	}
+
+	if expirer, ok := storage.(core.Expirer); ok {
+		expiredID := uuid.Must(uuid.NewV4()).String()
+		err = expirer.PutWithExpiry(ctx, tblName, expiredID, baseCol, 1, testString, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok, err = storage.Get(ctx, tblName, expiredID, baseCol, 1)
+		if err != nil || ok {
+			t.Errorf("Get returned an expired cell: ok=%v err=%v\n", ok, err)
+		}
+
+		// an expired latest version hides the column, not just itself
+		err = storage.Put(ctx, tblName, expiredID, statusCol, 1, testString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = expirer.PutWithExpiry(ctx, tblName, expiredID, statusCol, 2, testString2, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok, err = storage.GetLatest(ctx, tblName, expiredID, statusCol)
+		if err != nil || ok {
+			t.Errorf("GetLatest returned a version older than the expired latest one: v=%v ok=%v err=%v\n", v, ok, err)
+		}
+		v, ok, err = storage.GetLatestAsOf(ctx, tblName, expiredID, statusCol, time.Now().Add(time.Minute))
+		if err != nil || ok {
+			t.Errorf("GetLatestAsOf returned a version older than the expired latest one: v=%v ok=%v err=%v\n", v, ok, err)
+		}
+		row, ok, err = storage.GetRowAsOf(ctx, tblName, expiredID, time.Now().Add(time.Minute))
+		if err != nil || ok {
+			t.Errorf("GetRowAsOf returned a version older than the expired latest one: %v err=%v\n", row, err)
+		}
+
+		if scanner, ok := storage.(interface {
+			Scan(ctx context.Context, tblName string, addedAt int64, limit int) ([]models.Cell, bool, error)
+		}); ok {
+			var scanned []models.Cell
+			scanned, _, err = scanner.Scan(ctx, tblName, 0, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok = false
+			for _, c := range scanned {
+				ok = ok || c.RowKey == expiredID
+			}
+			if !ok {
+				t.Errorf("Scan left out the expired cell before it was swept\n")
+			}
+		}
+
+		var n int64
+		n, err = expirer.SweepExpired(ctx, tblName, time.Now(), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 1 {
+			t.Errorf("SweepExpired expected to delete the expired cell, deleted %d\n", n)
+		}
+	}
+
 	err = storage.ResetConnection(ctx, tblName)
 	if err != nil {
 		t.Errorf("failed resetting connection for key: err=%v\n", err)
 	}
+	_, _, err = storage.GetLatest(ctx, tblName, cellID, baseCol)
+	if err != nil {
+		t.Errorf("storage unusable after resetting its connection: err=%v\n", err)
+	}
+
+	testCancellation(t, storage, cellID)
+	testTableNames(t, storage)
+	testPartitionOrder(t, storage)
+}
+
+// testCancellation checks that calls whose context is canceled, or past its
+// deadline, fail with the context's error and leave no trace.
+func testCancellation(t *testing.T, storage schemaless.Storage, cellID string) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"canceled", canceled, context.Canceled},
+		{"expired", expired, context.DeadlineExceeded},
+	} {
+		_, _, err := storage.Get(c.ctx, tblName, cellID, baseCol, 1)
+		if !errors.Is(err, c.want) {
+			t.Errorf("Get with a %s context: expected %v, got %v\n", c.name, c.want, err)
+		}
+		_, _, err = storage.GetLatest(c.ctx, tblName, cellID, baseCol)
+		if !errors.Is(err, c.want) {
+			t.Errorf("GetLatest with a %s context: expected %v, got %v\n", c.name, c.want, err)
+		}
+		_, _, err = storage.History(c.ctx, tblName, cellID, baseCol, 10)
+		if !errors.Is(err, c.want) {
+			t.Errorf("History with a %s context: expected %v, got %v\n", c.name, c.want, err)
+		}
+		_, _, err = storage.PartitionRead(c.ctx, tblName, 0, "added_at", 0, 10)
+		if !errors.Is(err, c.want) {
+			t.Errorf("PartitionRead with a %s context: expected %v, got %v\n", c.name, c.want, err)
+		}
+
+		rowKey := uuid.Must(uuid.NewV4()).String()
+		err = storage.Put(c.ctx, tblName, rowKey, baseCol, 1, testString)
+		if !errors.Is(err, c.want) {
+			t.Errorf("Put with a %s context: expected %v, got %v\n", c.name, c.want, err)
+		}
+		_, ok, err := storage.GetLatest(context.TODO(), tblName, rowKey, baseCol)
+		if err != nil || ok {
+			t.Errorf("Put with a %s context was written: ok=%v err=%v\n", c.name, ok, err)
+		}
+	}
+}
+
+// maliciousTableNames are table names that would change the meaning of a
+// statement, or the file a sqlite table is kept in, were they used as-is.
+var maliciousTableNames = []string{
+	"",
+	"cell; DROP TABLE cell",
+	"cell WHERE 1=1 --",
+	"cell\" WHERE \"1\"=\"1",
+	"cell` WHERE `1`=`1",
+	"cell UNION SELECT * FROM cell",
+	"cell/*",
+	"../cell",
+	"1cell",
+	"cell\x00",
+	"céll",
+	"cell_" + strings.Repeat("x", core.MaxTableNameLength),
+}
+
+// testTableNames checks that calls naming a malicious table fail with
+// core.ErrInvalidTableName, and leave the cell table as it was.
+func testTableNames(t *testing.T, storage schemaless.Storage) {
+	ctx := context.TODO()
+	cellID := uuid.Must(uuid.NewV4()).String()
+	err := storage.Put(ctx, tblName, cellID, baseCol, 1, testString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range maliciousTableNames {
+		_, _, err := storage.Get(ctx, name, cellID, baseCol, 1)
+		if !errors.Is(err, core.ErrInvalidTableName) {
+			t.Errorf("Get of table %q: expected %v, got %v\n", name, core.ErrInvalidTableName, err)
+		}
+		_, _, err = storage.GetLatest(ctx, name, cellID, baseCol)
+		if !errors.Is(err, core.ErrInvalidTableName) {
+			t.Errorf("GetLatest of table %q: expected %v, got %v\n", name, core.ErrInvalidTableName, err)
+		}
+		_, _, err = storage.PartitionRead(ctx, name, 0, "added_at", 0, 10)
+		if !errors.Is(err, core.ErrInvalidTableName) {
+			t.Errorf("PartitionRead of table %q: expected %v, got %v\n", name, core.ErrInvalidTableName, err)
+		}
+		err = storage.Put(ctx, name, cellID, baseCol, 2, testString2)
+		if !errors.Is(err, core.ErrInvalidTableName) {
+			t.Errorf("Put to table %q: expected %v, got %v\n", name, core.ErrInvalidTableName, err)
+		}
+		if purger, ok := storage.(core.Purger); ok {
+			_, err = purger.Purge(ctx, name, cellID)
+			if !errors.Is(err, core.ErrInvalidTableName) {
+				t.Errorf("Purge of table %q: expected %v, got %v\n", name, core.ErrInvalidTableName, err)
+			}
+		}
+	}
+
+	cell, ok, err := storage.GetLatest(ctx, tblName, cellID, baseCol)
+	if err != nil || !ok || cell.RefKey != 1 {
+		t.Errorf("cell table changed by calls to malicious tables: cell=%+v ok=%v err=%v\n", cell, ok, err)
+	}
+}
+
+// testPartitionOrder checks that PartitionRead by added_at returns cells in
+// added_at order, so that a caller paging through a table with a cursor past
+// the last cell of each batch visits every cell.
+func testPartitionOrder(t *testing.T, storage schemaless.Storage) {
+	ctx := context.TODO()
+	cellID := uuid.Must(uuid.NewV4()).String()
+	for refKey := int64(1); refKey <= 5; refKey++ {
+		err := storage.Put(ctx, tblName, cellID, statusCol, refKey, testString)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		cursor  int64
+		visited int
+	)
+	for {
+		cells, ok, err := storage.PartitionRead(ctx, tblName, 0, "added_at", cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		for _, cell := range cells {
+			if cell.AddedAt < cursor {
+				t.Fatalf("PartitionRead returned added_at %d after %d: %v\n", cell.AddedAt, cursor-1, cells)
+			}
+			cursor = cell.AddedAt + 1
+			if cell.RowKey == cellID {
+				visited++
+			}
+		}
+	}
+	if visited != 5 {
+		t.Errorf("paging through PartitionRead visited %d of 5 cells\n", visited)
+	}
 }
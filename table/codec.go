@@ -0,0 +1,123 @@
+package table
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec converts Go values to and from cell bodies. Bodies must be JSON, to
+// fit the JSON body columns of the mysql and postgres schemas, so binary
+// codecs store what they produce as a base64 encoded JSON string.
+type Codec interface {
+	Name() string
+	Encode(v interface{}) (string, error)
+	// Decode stores the value of body in the value pointed to by v.
+	Decode(body string, v interface{}) error
+}
+
+var (
+	// JSON stores values as JSON, readable by every other client of a table.
+	JSON Codec = jsonCodec{}
+	// Msgpack stores values as MessagePack, in a base64 encoded JSON string.
+	Msgpack Codec = msgpackCodec{}
+	// Protobuf stores protocol buffer messages as wire format, in a base64
+	// encoded JSON string.
+	// Use it with a message pointer type, e.g. Table[*pb.Trip].
+	Protobuf Codec = protobufCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Encode(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (jsonCodec) Decode(body string, v interface{}) error {
+	return json.Unmarshal([]byte(body), v)
+}
+
+// encodeBinary returns b as a JSON string of its base64 encoding.
+func encodeBinary(b []byte) (string, error) {
+	body, err := json.Marshal(base64.StdEncoding.EncodeToString(b))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func decodeBinary(body string) ([]byte, error) {
+	var encoded string
+	err := json.Unmarshal([]byte(body), &encoded)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Encode(v interface{}) (string, error) {
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return encodeBinary(b)
+}
+
+func (msgpackCodec) Decode(body string, v interface{}) error {
+	b, err := decodeBinary(body)
+	if err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(b, v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Encode(v interface{}) (string, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("%T is not a protocol buffer message", v)
+	}
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return encodeBinary(b)
+}
+
+func (protobufCodec) Decode(body string, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// Table[*pb.Trip] decodes into a **pb.Trip; allocate the message
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Ptr {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+			m, ok = rv.Elem().Interface().(proto.Message)
+		}
+		if !ok {
+			return fmt.Errorf("%T is not a protocol buffer message", v)
+		}
+	}
+
+	b, err := decodeBinary(body)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, m)
+}
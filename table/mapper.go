@@ -0,0 +1,201 @@
+package table
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// RowStore is the part of schemaless.DataStore that a Mapper uses.
+type RowStore interface {
+	Store
+	GetRowAsOf(ctx context.Context, tblName, rowKey string, asOf time.Time) ([]models.Cell, bool, error)
+	HistoryAll(ctx context.Context, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error)
+}
+
+// latest is an as-of time later than any cell's created_at, while still
+// representable as nanoseconds (sqlite) and as a DATETIME (mysql).
+var latest = time.Date(2262, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Row is an entity of type T along with the ref key and body last seen for
+// each of its columns, so that Save can tell what changed.
+type Row[T any] struct {
+	RowKey string
+	Value  T
+
+	refKeys map[string]int64
+	bodies  map[string]string
+}
+
+// RefKey returns the ref key of the latest cell of columnKey known to the
+// row, or 0 if it has none.
+func (r *Row[T]) RefKey(columnKey string) int64 {
+	return r.refKeys[columnKey]
+}
+
+type mappedColumn struct {
+	name  string
+	index int
+}
+
+// Mapper loads and saves entities whose fields span several columns of a
+// table. Each field holding a column body is tagged with the column name:
+//
+//	type Trip struct {
+//		Base    TripBase    `schemaless:"BASE"`
+//		Status  TripStatus  `schemaless:"STATUS"`
+//		Payment *Payment    `schemaless:"PAYMENT"`
+//	}
+//
+// Untagged fields and fields tagged "-" are not stored. A nil pointer field
+// is a column the entity does not have (yet).
+type Mapper[T any] struct {
+	store   RowStore
+	name    string
+	codec   Codec
+	columns []mappedColumn
+}
+
+// NewMapper returns a Mapper for entities of type T in tblName, using the
+// JSON codec. T must be a struct with at least one tagged field.
+func NewMapper[T any](store RowStore, tblName string) (*Mapper[T], error) {
+	var zero T
+	typ := reflect.TypeOf(zero)
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mapper for %s: %v is not a struct", tblName, typ)
+	}
+
+	m := &Mapper[T]{store: store, name: tblName, codec: JSON}
+	seen := make(map[string]string)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		column, ok := field.Tag.Lookup("schemaless")
+		if !ok || column == "-" {
+			continue
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("mapper for %s: field %s is not exported", tblName, field.Name)
+		}
+		if other, dup := seen[column]; dup {
+			return nil, fmt.Errorf("mapper for %s: fields %s and %s both map to column %s", tblName, other, field.Name, column)
+		}
+		seen[column] = field.Name
+		m.columns = append(m.columns, mappedColumn{name: column, index: i})
+	}
+	if len(m.columns) == 0 {
+		return nil, fmt.Errorf("mapper for %s: %v has no fields tagged with a column", tblName, typ)
+	}
+
+	return m, nil
+}
+
+// WithCodec sets the codec used for every column's body.
+func (m *Mapper[T]) WithCodec(c Codec) *Mapper[T] {
+	m.codec = c
+	return m
+}
+
+// NewRow returns a row that has not been saved yet.
+func (m *Mapper[T]) NewRow(rowKey string, v T) *Row[T] {
+	return &Row[T]{RowKey: rowKey, Value: v, refKeys: make(map[string]int64), bodies: make(map[string]string)}
+}
+
+// Load reads the latest cell of every mapped column of rowKey with a single
+// row fetch. found is false if the row has none of them. A column that is
+// deleted, or whose latest version has expired, is left zero, but the row
+// still remembers its highest ref key so that Save writes past it.
+func (m *Mapper[T]) Load(ctx context.Context, rowKey string) (row *Row[T], found bool, err error) {
+	cells, _, err := m.store.GetRowAsOf(ctx, m.name, rowKey, latest)
+	if err != nil {
+		return nil, false, err
+	}
+
+	row = m.NewRow(rowKey, *new(T))
+	byColumn := make(map[string]models.Cell, len(cells))
+	for _, cell := range cells {
+		byColumn[cell.ColumnName] = cell
+	}
+
+	value := reflect.ValueOf(&row.Value).Elem()
+	for _, col := range m.columns {
+		cell, ok := byColumn[col.name]
+		if !ok {
+			// a tombstone or an expired version may still hold ref keys
+			versions, _, err := m.store.HistoryAll(ctx, m.name, rowKey, col.name, 1)
+			if err != nil {
+				return nil, false, err
+			}
+			if len(versions) > 0 {
+				row.refKeys[col.name] = versions[0].RefKey
+			}
+			continue
+		}
+		found = true
+
+		field := value.Field(col.index)
+		err = m.codec.Decode(cell.Body, field.Addr().Interface())
+		if err != nil {
+			return nil, false, &DecodeError{
+				Table:      m.name,
+				RowKey:     cell.RowKey,
+				ColumnName: cell.ColumnName,
+				RefKey:     cell.RefKey,
+				Codec:      m.codec.Name(),
+				Err:        err,
+			}
+		}
+
+		// compare re-encoded bodies on save, so that formatting differences
+		// in what was stored don't count as changes
+		body, err := m.codec.Encode(field.Interface())
+		if err != nil {
+			return nil, false, err
+		}
+		row.refKeys[col.name] = cell.RefKey
+		row.bodies[col.name] = body
+	}
+
+	return row, found, nil
+}
+
+// Save writes a new cell, with the next ref key, for every column whose
+// value changed since the row was loaded or last saved, and returns the
+// columns written. Columns are written one at a time; if a write fails
+// (e.g. because another writer took the ref key first), the columns already
+// written stay written and the error is returned.
+func (m *Mapper[T]) Save(ctx context.Context, row *Row[T]) (written []string, err error) {
+	if row.refKeys == nil {
+		row.refKeys = make(map[string]int64)
+		row.bodies = make(map[string]string)
+	}
+
+	value := reflect.ValueOf(&row.Value).Elem()
+	for _, col := range m.columns {
+		field := value.Field(col.index)
+		if field.Kind() == reflect.Ptr && field.IsNil() {
+			continue
+		}
+
+		body, err := m.codec.Encode(field.Interface())
+		if err != nil {
+			return written, fmt.Errorf("encoding %s (%s, %s) as %s: %w", m.name, row.RowKey, col.name, m.codec.Name(), err)
+		}
+		if prev, ok := row.bodies[col.name]; ok && prev == body {
+			continue
+		}
+
+		refKey := row.refKeys[col.name] + 1
+		err = m.store.Put(ctx, m.name, row.RowKey, col.name, refKey, body)
+		if err != nil {
+			return written, err
+		}
+		row.refKeys[col.name] = refKey
+		row.bodies[col.name] = body
+		written = append(written, col.name)
+	}
+
+	return written, nil
+}
@@ -0,0 +1,165 @@
+package table
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type tripStatus struct {
+	IsCompleted bool `json:"is_completed"`
+}
+
+type payment struct {
+	Result string `json:"result"`
+}
+
+type tripEntity struct {
+	Base    trip       `schemaless:"BASE"`
+	Status  tripStatus `schemaless:"STATUS"`
+	Payment *payment   `schemaless:"PAYMENT"`
+	Scratch string
+}
+
+func TestMapper(t *testing.T) {
+	ctx := context.TODO()
+	store := newStore(t)
+
+	trips, err := NewMapper[tripEntity](store, "trips")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := trips.Load(ctx, "trip1")
+	if err != nil || found {
+		t.Fatalf("expected no row, got found=%v err=%v", found, err)
+	}
+
+	row := trips.NewRow("trip1", tripEntity{Base: trip{DriverPartnerUUID: "d1", Fare: 10}})
+	written, err := trips.Save(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, []string{"BASE", "STATUS"}) {
+		t.Errorf("expected BASE and STATUS to be written, got %v", written)
+	}
+
+	// a cell stored with different formatting is not a change
+	err = store.Put(ctx, "trips", "trip1", "STATUS", 2, `{ "is_completed" : false }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, found, err = trips.Load(ctx, "trip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || row.Value.Base.Fare != 10 || row.RefKey("STATUS") != 2 {
+		t.Errorf("unexpected row %+v", row)
+	}
+
+	written, err = trips.Save(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 0 {
+		t.Errorf("expected nothing to be written, got %v", written)
+	}
+
+	row.Value.Status.IsCompleted = true
+	row.Value.Payment = &payment{Result: "SUCCESS"}
+	written, err = trips.Save(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, []string{"STATUS", "PAYMENT"}) {
+		t.Errorf("expected STATUS and PAYMENT to be written, got %v", written)
+	}
+
+	for column, refKey := range map[string]int64{"BASE": 1, "STATUS": 3, "PAYMENT": 1} {
+		cell, _, err := store.GetLatest(ctx, "trips", "trip1", column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cell.RefKey != refKey {
+			t.Errorf("expected %s at ref key %d, got %d", column, refKey, cell.RefKey)
+		}
+	}
+
+	row, _, err = trips.Load(ctx, "trip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !row.Value.Status.IsCompleted || row.Value.Payment == nil || row.Value.Payment.Result != "SUCCESS" {
+		t.Errorf("unexpected row %+v", row.Value)
+	}
+
+	// a stale row can't overwrite a newer cell
+	stale, _, err := trips.Load(ctx, "trip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.Value.Base.Fare = 20
+	stale.Value.Base.Fare = 30
+	_, err = trips.Save(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = trips.Save(ctx, stale)
+	if err == nil {
+		t.Error("expected a conflicting save to fail")
+	}
+
+	_, err = NewMapper[trip](store, "trips")
+	if err == nil {
+		t.Error("expected an error for a struct without column tags")
+	}
+}
+
+func TestMapperAfterDelete(t *testing.T) {
+	ctx := context.TODO()
+	store := newStore(t)
+
+	trips, err := NewMapper[tripEntity](store, "trips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = trips.Save(ctx, trips.NewRow("trip1", tripEntity{Base: trip{DriverPartnerUUID: "d1", Fare: 10}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a deleted column is written again past its tombstone
+	err = store.Delete(ctx, "trips", "trip1", "BASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and an expired one past its expired version
+	err = store.PutWithExpiry(ctx, "trips", "trip1", "STATUS", 2, `{"is_completed": true}`, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, _, err := trips.Load(ctx, "trip1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.RefKey("BASE") != 2 || row.RefKey("STATUS") != 2 {
+		t.Errorf("expected the tombstone's and the expired version's ref keys, got BASE %d STATUS %d", row.RefKey("BASE"), row.RefKey("STATUS"))
+	}
+	row.Value.Base = trip{DriverPartnerUUID: "d2", Fare: 20}
+	row.Value.Status.IsCompleted = true
+	written, err := trips.Save(ctx, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written, []string{"BASE", "STATUS"}) {
+		t.Errorf("expected BASE and STATUS to be written, got %v", written)
+	}
+
+	row, found, err := trips.Load(ctx, "trip1")
+	if err != nil || !found || row.Value.Base.Fare != 20 || row.RefKey("BASE") != 3 || row.RefKey("STATUS") != 3 {
+		t.Errorf("expected the saved row back, got %+v found=%v err=%v", row, found, err)
+	}
+}
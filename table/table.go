@@ -0,0 +1,149 @@
+// Package table provides typed access to the columns of a schemaless table,
+// so that application code works with Go values instead of munging JSON
+// strings with gjson and sjson.
+//
+//	trips := table.New[Trip](store, "trips")
+//	err := trips.Put(ctx, tripUUID, "BASE", 1, Trip{DriverPartnerUUID: driver})
+//	trip, found, err := trips.GetLatest(ctx, tripUUID, "BASE")
+//
+// Bodies are JSON by default; see WithCodec for MessagePack and protocol
+// buffers.
+package table
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rbastic/go-schemaless/models"
+)
+
+// Store is the part of schemaless.DataStore that a Table uses.
+type Store interface {
+	Get(ctx context.Context, tblName, rowKey, columnKey string, refKey int64) (models.Cell, bool, error)
+	GetLatest(ctx context.Context, tblName, rowKey, columnKey string) (models.Cell, bool, error)
+	History(ctx context.Context, tblName, rowKey, columnKey string, limit int) ([]models.Cell, bool, error)
+	Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error
+}
+
+// Cell is a cell whose body has been decoded into a T.
+type Cell[T any] struct {
+	RowKey     string
+	ColumnName string
+	RefKey     int64
+	CreatedAt  int64
+	Value      T
+}
+
+// DecodeError is returned when a cell's body cannot be decoded, and says
+// which cell it was.
+type DecodeError struct {
+	Table      string
+	RowKey     string
+	ColumnName string
+	RefKey     int64
+	Codec      string
+	Err        error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decoding %s (%s, %s, %d) as %s: %s", e.Table, e.RowKey, e.ColumnName, e.RefKey, e.Codec, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Table reads and writes cells of tblName whose bodies hold a T.
+type Table[T any] struct {
+	store Store
+	name  string
+	codec Codec
+}
+
+// New returns a Table over tblName in store, using the JSON codec.
+func New[T any](store Store, tblName string) *Table[T] {
+	return &Table[T]{store: store, name: tblName, codec: JSON}
+}
+
+// WithCodec sets the codec used for bodies. Every client of a table must
+// agree on its codec.
+func (t *Table[T]) WithCodec(c Codec) *Table[T] {
+	t.codec = c
+	return t
+}
+
+// Name returns the table name.
+func (t *Table[T]) Name() string {
+	return t.name
+}
+
+func (t *Table[T]) decode(cell models.Cell) (T, error) {
+	var v T
+	err := t.codec.Decode(cell.Body, &v)
+	if err != nil {
+		return v, &DecodeError{
+			Table:      t.name,
+			RowKey:     cell.RowKey,
+			ColumnName: cell.ColumnName,
+			RefKey:     cell.RefKey,
+			Codec:      t.codec.Name(),
+			Err:        err,
+		}
+	}
+	return v, nil
+}
+
+// Put encodes v and writes it as the cell (rowKey, columnKey, refKey).
+func (t *Table[T]) Put(ctx context.Context, rowKey, columnKey string, refKey int64, v T) error {
+	body, err := t.codec.Encode(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s (%s, %s, %d) as %s: %w", t.name, rowKey, columnKey, refKey, t.codec.Name(), err)
+	}
+	return t.store.Put(ctx, t.name, rowKey, columnKey, refKey, body)
+}
+
+// Get returns the value of the cell (rowKey, columnKey, refKey).
+func (t *Table[T]) Get(ctx context.Context, rowKey, columnKey string, refKey int64) (v T, found bool, err error) {
+	cell, found, err := t.store.Get(ctx, t.name, rowKey, columnKey, refKey)
+	if err != nil || !found {
+		return v, found, err
+	}
+	v, err = t.decode(cell)
+	return v, found, err
+}
+
+// GetLatest returns the value of the cell with the highest ref key for
+// rowKey and columnKey.
+func (t *Table[T]) GetLatest(ctx context.Context, rowKey, columnKey string) (v T, found bool, err error) {
+	cell, found, err := t.store.GetLatest(ctx, t.name, rowKey, columnKey)
+	if err != nil || !found {
+		return v, found, err
+	}
+	v, err = t.decode(cell)
+	return v, found, err
+}
+
+// History returns up to limit versions of rowKey and columnKey, highest ref
+// key first.
+func (t *Table[T]) History(ctx context.Context, rowKey, columnKey string, limit int) ([]Cell[T], bool, error) {
+	cells, found, err := t.store.History(ctx, t.name, rowKey, columnKey, limit)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	history := make([]Cell[T], 0, len(cells))
+	for _, cell := range cells {
+		v, err := t.decode(cell)
+		if err != nil {
+			return history, found, err
+		}
+		history = append(history, Cell[T]{
+			RowKey:     cell.RowKey,
+			ColumnName: cell.ColumnName,
+			RefKey:     cell.RefKey,
+			CreatedAt:  cell.CreatedAt,
+			Value:      v,
+		})
+	}
+	return history, found, nil
+}
@@ -0,0 +1,125 @@
+package table
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type trip struct {
+	DriverPartnerUUID string  `json:"driver_partner_uuid" msgpack:"driver_partner_uuid"`
+	Fare              float64 `json:"fare" msgpack:"fare"`
+}
+
+func newStore(t *testing.T) *schemaless.DataStore {
+	return schemaless.New().WithSources("trips", []core.Shard{{Name: "shard0", Backend: storagetest.NewSQLiteTable(t, "trips")}})
+}
+
+// jsonColumn rejects bodies that aren't JSON, as the body columns of the
+// mysql and postgres schemas do.
+type jsonColumn struct {
+	core.Storage
+}
+
+func (j jsonColumn) Put(ctx context.Context, tblName, rowKey, columnKey string, refKey int64, body string) error {
+	if !json.Valid([]byte(body)) {
+		return fmt.Errorf("invalid JSON text in body: %q", body)
+	}
+	return j.Storage.Put(ctx, tblName, rowKey, columnKey, refKey, body)
+}
+
+// newJSONStore is newStore with a JSON body column.
+func newJSONStore(t *testing.T) *schemaless.DataStore {
+	return schemaless.New().WithSources("trips", []core.Shard{{Name: "shard0", Backend: jsonColumn{storagetest.NewSQLiteTable(t, "trips")}}})
+}
+
+func TestTable(t *testing.T) {
+	ctx := context.TODO()
+	store := newJSONStore(t)
+
+	for _, codec := range []Codec{JSON, Msgpack} {
+		trips := New[trip](store, "trips").WithCodec(codec)
+		rowKey := "trip-" + codec.Name()
+
+		for i := int64(1); i <= 3; i++ {
+			err := trips.Put(ctx, rowKey, "BASE", i, trip{DriverPartnerUUID: "d1", Fare: float64(i) * 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		v, found, err := trips.GetLatest(ctx, rowKey, "BASE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || v.Fare != 30 {
+			t.Errorf("%s: expected the latest fare of 30, got %v", codec.Name(), v)
+		}
+
+		v, _, err = trips.Get(ctx, rowKey, "BASE", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Fare != 10 || v.DriverPartnerUUID != "d1" {
+			t.Errorf("%s: expected the first version, got %v", codec.Name(), v)
+		}
+
+		history, _, err := trips.History(ctx, rowKey, "BASE", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 || history[0].RefKey != 3 || history[1].Value.Fare != 20 {
+			t.Errorf("%s: unexpected history %v", codec.Name(), history)
+		}
+	}
+
+	_, found, err := New[trip](store, "trips").GetLatest(ctx, "missing", "BASE")
+	if err != nil || found {
+		t.Errorf("expected a missing cell, got found=%v err=%v", found, err)
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	ctx := context.TODO()
+	store := newJSONStore(t)
+
+	notes := New[*wrapperspb.StringValue](store, "trips").WithCodec(Protobuf)
+	err := notes.Put(ctx, "trip1", "NOTES", 1, wrapperspb.String("left at the curb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _, err := notes.GetLatest(ctx, "trip1", "NOTES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.GetValue() != "left at the curb" {
+		t.Errorf("unexpected value %v", v)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	ctx := context.TODO()
+	store := newStore(t)
+
+	err := store.Put(ctx, "trips", "trip1", "BASE", 7, "not json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = New[trip](store, "trips").GetLatest(ctx, "trip1", "BASE")
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DecodeError, got %v", err)
+	}
+	if derr.Table != "trips" || derr.RowKey != "trip1" || derr.ColumnName != "BASE" || derr.RefKey != 7 {
+		t.Errorf("expected the cell's coordinates, got %s", derr)
+	}
+}
@@ -1 +1 @@
-go run main.go -db postgres -createFile ../../storage/postgres/cell.sql -name user -num 4 > create-shards.sql
+go run main.go -config ../../examples/schemalessd/shards.json.pg
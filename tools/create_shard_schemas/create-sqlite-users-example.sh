@@ -0,0 +1 @@
+go run main.go -config ../../examples/schemalessd/shards.json.sqlite
@@ -1,96 +1,230 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+	"github.com/rbastic/go-schemaless/schema"
+	stmysql "github.com/rbastic/go-schemaless/storage/mysql"
+	stpostgres "github.com/rbastic/go-schemaless/storage/postgres"
+	stsqlite "github.com/rbastic/go-schemaless/storage/sqlite"
 )
 
 func main() {
-	dbPtr := flag.String("db", "mysql", "the database you will be using (current options: mysql, postgres)")
-	namePtr := flag.String("name", "test", "the name of your schema (will end up as a prefix for all shards)")
-	numPtr := flag.Int("num", 4, "the number of shards (will be appended to shard names) - default is 4")
-	createFilePtr := flag.String("createFile", "", "cell schema CREATE TABLE file")
+	configPtr := flag.String("config", "", "schemalessd shards.json describing the shards to create or migrate")
+	storePtr := flag.String("store", "", "only migrate the named datastore (default: all)")
+	dryRunPtr := flag.Bool("dry-run", false, "print the pending migrations for each shard instead of applying them")
+	verifyPtr := flag.Bool("verify", false, "report schema drift on each shard instead of applying migrations; exits non-zero if any is found")
 
 	flag.Parse()
 
-	if *createFilePtr == "" {
-		panic("You must set a createFile. Find a suitable schema laying around somewhere.")
+	if *configPtr == "" {
+		fmt.Fprintln(os.Stderr, "You must set -config to a schemalessd shards.json.")
+		os.Exit(2)
 	}
 
-	var showWarnings bool
-	switch *dbPtr {
-	case "mysql":
-		showWarnings = true
-		createMysql(*namePtr, *numPtr, *createFilePtr, showWarnings)
-	case "postgres":
-		showWarnings = false
-		createPostgres(*namePtr, *numPtr, *createFilePtr, showWarnings)
-	default:
-		panic("Unrecognized database: " + *dbPtr)
+	shardConfig, err := config.LoadConfig(*configPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *verifyPtr {
+		drifted, err := verify(context.Background(), shardConfig, *storePtr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if drifted {
+			os.Exit(1)
+		}
+		return
 	}
 
+	err = run(context.Background(), shardConfig, *storePtr, *dryRunPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func createMysql(name string, num int, createFile string, showWarnings bool) {
-	for i := 0; i < num; i++ {
-		dbName := fmt.Sprintf("%s%d", name, i)
+func run(ctx context.Context, shardConfig *config.ShardConfig, onlyStore string, dryRun bool) error {
+	driver := shardConfig.Driver
+
+	for i := range shardConfig.Datastores {
+		datastore := &shardConfig.Datastores[i]
+		if onlyStore != "" && datastore.Name != onlyStore {
+			continue
+		}
 
-		dropDB := fmt.Sprintf("DROP DATABASE IF EXISTS %s;", dbName)
-		fmt.Printf("%s\n", dropDB)
+		for _, t := range targets(datastore) {
+			label := t.label
 
-		createDB := fmt.Sprintf("CREATE DATABASE %s;", dbName)
-		fmt.Printf("%s\n", createDB)
+			db, err := openShard(driver, datastore, t)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
 
-		if showWarnings {
-			fmt.Printf("SHOW WARNINGS;\n")
+			err = migrateShard(ctx, db, driver, label, datastore.Tables(), dryRun)
+			db.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
 		}
+	}
 
-		useDB := fmt.Sprintf("USE %s;", dbName)
-		fmt.Printf("%s\n", useDB)
+	return nil
+}
 
-		if showWarnings {
-			fmt.Printf("SHOW WARNINGS;\n")
-		}
+// verify reports schema drift for every table on every shard, returning
+// true if any was found.
+func verify(ctx context.Context, shardConfig *config.ShardConfig, onlyStore string) (bool, error) {
+	driver := shardConfig.Driver
+	drifted := false
 
-		contents, err := readFile(createFile)
-		if err != nil {
-			panic(err)
+	for i := range shardConfig.Datastores {
+		datastore := &shardConfig.Datastores[i]
+		if onlyStore != "" && datastore.Name != onlyStore {
+			continue
 		}
 
-		fmt.Printf("%s\n", contents)
-		if showWarnings {
-			fmt.Printf("SHOW WARNINGS;\n")
+		for _, t := range targets(datastore) {
+			label := t.label
+
+			db, err := openShard(driver, datastore, t)
+			if err != nil {
+				return drifted, fmt.Errorf("%s: %w", label, err)
+			}
+
+			var drift []schema.Drift
+			for _, tblName := range datastore.Tables() {
+				var tblDrift []schema.Drift
+				tblDrift, err = schema.Verify(ctx, db, driver, tblName)
+				if err != nil {
+					break
+				}
+				drift = append(drift, tblDrift...)
+			}
+			db.Close()
+			if err != nil {
+				return drifted, fmt.Errorf("%s: %w", label, err)
+			}
+
+			if len(drift) == 0 {
+				fmt.Printf("%s: ok\n", label)
+				continue
+			}
+
+			drifted = true
+			for _, d := range drift {
+				fmt.Printf("%s: %s: %s\n", label, d.Table, d.Problem)
+				fmt.Printf("%s:     suggested fix: %s\n", label, d.Fix)
+			}
 		}
 	}
-}
 
-func createPostgres(name string, num int, createFile string, showWarnings bool) {
-	for i := 0; i < num; i++ {
-		dbName := fmt.Sprintf("%s%d", name, i)
+	return drifted, nil
+}
 
-		dropDB := fmt.Sprintf("DROP DATABASE IF EXISTS %s;", dbName)
-		fmt.Printf("%s\n", dropDB)
+// target is a database holding a datastore's tables: one of its shards,
+// or its write buffer.
+type target struct {
+	label string
+	shard config.Shard
+}
 
-		createDB := fmt.Sprintf("CREATE DATABASE %s;", dbName)
-		fmt.Printf("%s\n", createDB)
+// targets returns every shard of datastore, labelled as schemalessd labels
+// them, followed by its write buffer if it has one.
+func targets(datastore *config.DatastoreConfig) []target {
+	var ts []target
+	for i, shard := range datastore.Shards {
+		ts = append(ts, target{datastore.Name + strconv.Itoa(i), shard})
+	}
+	if datastore.Buffer != nil {
+		ts = append(ts, target{datastore.Name + "_buffer", *datastore.Buffer})
+	}
+	return ts
+}
 
-		useDB := fmt.Sprintf("\\c %s", dbName)
-		fmt.Printf("%s\n", useDB)
+// openShard connects to a datastore's target the same way schemalessd does.
+func openShard(driver string, datastore *config.DatastoreConfig, t target) (*sql.DB, error) {
+	shard := t.shard
 
-		contents, err := readFile(createFile)
+	switch driver {
+	case "sqlite3":
+		return stsqlite.Open(datastore.Name, t.label)
+	case "mysql":
+		pool, err := shard.CorePool()
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("%s: %w", t.label, err)
 		}
-
-		fmt.Printf("%s\n", contents)
+		store := stmysql.New().
+			WithHost(shard.Host).
+			WithPort(shard.Port).
+			WithUser(shard.Username).
+			WithPass(shard.Password).
+			WithDatabase(shard.Database).
+			WithPool(pool).
+			WithTLS(shard.CoreTLS()).
+			WithParams(shard.Params)
+		err = store.Open()
+		if err != nil {
+			return nil, err
+		}
+		return store.GetDB(), nil
+	case "postgres":
+		pool, err := shard.CorePool()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.label, err)
+		}
+		store := stpostgres.New().
+			WithHost(shard.Host).
+			WithPort(shard.Port).
+			WithUser(shard.Username).
+			WithPass(shard.Password).
+			WithDatabase(shard.Database).
+			WithPool(pool).
+			WithTLS(shard.CoreTLS()).
+			WithParams(shard.Params)
+		err = store.Open()
+		if err != nil {
+			return nil, err
+		}
+		return store.GetDB(), nil
+	default:
+		return nil, fmt.Errorf("unrecognized driver: '%s'", driver)
 	}
 }
 
-func readFile(fileName string) (string, error) {
-	fb, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		return "", err
+func migrateShard(ctx context.Context, db *sql.DB, driver, label string, tables []string, dryRun bool) error {
+	for _, tblName := range tables {
+		if dryRun {
+			pending, version, err := schema.Pending(ctx, db, driver, tblName)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("-- %s: %s is at schema version %d, %d migration(s) pending\n", label, tblName, version, len(pending))
+			for _, stmt := range pending {
+				fmt.Printf("%s;\n", stmt)
+			}
+			continue
+		}
+
+		from, to, err := schema.Migrate(ctx, db, driver, tblName)
+		if err != nil {
+			return err
+		}
+		if from == to {
+			fmt.Printf("%s: %s is up to date at schema version %d\n", label, tblName, to)
+		} else {
+			fmt.Printf("%s: migrated %s from schema version %d to %d\n", label, tblName, from, to)
+		}
 	}
-	return string(fb), nil
+
+	return nil
 }
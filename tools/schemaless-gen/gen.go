@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+)
+
+// initialisms are kept upper case in generated identifiers, as golint expects.
+var initialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "JSON": true, "SQL": true,
+	"URL": true, "UUID": true, "UTC": true,
+}
+
+// exportedName turns a table, column or JSON property name such as
+// "driver_partner_uuid" or "BASE" into a Go identifier.
+func exportedName(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		upper := strings.ToUpper(word)
+		if initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		b.WriteString(upper[:1] + strings.ToLower(word[1:]))
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+type field struct {
+	Name    string
+	Type    string
+	Tag     string
+	Comment string
+}
+
+type structType struct {
+	Name    string
+	Comment string
+	Fields  []field
+}
+
+type column struct {
+	Name   string // column name, e.g. BASE
+	Ident  string // e.g. Base
+	GoType string
+}
+
+type index struct {
+	Table       string // index table name
+	Column      column
+	SourceField string
+	Ident       string // e.g. BaseByDriverPartnerUUID
+	GoType      string
+}
+
+type model struct {
+	Package string
+	Source  string
+	Tables  []*tableModel
+}
+
+type tableModel struct {
+	Name    string
+	Ident   string
+	Types   []structType
+	Columns []column
+	Indexes []index
+}
+
+// goType returns the Go type for a JSON Schema, adding struct types for
+// objects with properties to types under the given name.
+func goType(schema interface{}, name string, types *[]structType) string {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return "interface{}"
+	}
+
+	typ, _ := s["type"].(string)
+	if list, ok := s["type"].([]interface{}); ok {
+		// e.g. ["string", "null"]
+		for _, t := range list {
+			if t, ok := t.(string); ok && t != "null" {
+				typ = t
+				break
+			}
+		}
+	}
+
+	switch typ {
+	case "string":
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + goType(s["items"], name+"Item", types)
+	case "object":
+		props, ok := s["properties"].(map[string]interface{})
+		if !ok || len(props) == 0 {
+			return "map[string]interface{}"
+		}
+		addStruct(s, name, types)
+		return name
+	default:
+		return "interface{}"
+	}
+}
+
+func addStruct(s map[string]interface{}, name string, types *[]structType) {
+	props, _ := s["properties"].(map[string]interface{})
+	required := make(map[string]bool)
+	if list, ok := s["required"].([]interface{}); ok {
+		for _, r := range list {
+			if r, ok := r.(string); ok {
+				required[r] = true
+			}
+		}
+	}
+
+	var names []string
+	for p := range props {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	st := structType{Name: name, Comment: name + " is generated from a JSON Schema."}
+	if desc, ok := s["description"].(string); ok {
+		st.Comment += " " + desc
+	}
+	// reserve our place before nested types are added
+	*types = append(*types, st)
+	pos := len(*types) - 1
+
+	for _, p := range names {
+		fieldName := exportedName(p)
+		f := field{Name: fieldName, Type: goType(props[p], name+fieldName, types)}
+		if required[p] {
+			f.Tag = fmt.Sprintf("`json:\"%s\"`", p)
+		} else {
+			f.Tag = fmt.Sprintf("`json:\"%s,omitempty\"`", p)
+		}
+		if ps, ok := props[p].(map[string]interface{}); ok {
+			f.Comment, _ = ps["description"].(string)
+		}
+		st.Fields = append(st.Fields, f)
+	}
+	(*types)[pos] = st
+}
+
+func loadSchema(file string) (interface{}, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var schema interface{}
+	err = json.Unmarshal(contents, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return schema, nil
+}
+
+// buildTable describes the code to generate for a datastore's cell table:
+// one type per column with a schema (from its latest version), and one per
+// secondary index.
+func buildTable(datastore *config.DatastoreConfig) (*tableModel, error) {
+	t := &tableModel{Name: datastore.Name, Ident: exportedName(datastore.Name)}
+
+	columns := make(map[string]column)
+	for _, cs := range datastore.Schemas {
+		if cs.Table != "" && cs.Table != datastore.Name {
+			continue
+		}
+		if len(cs.Versions) == 0 {
+			return nil, fmt.Errorf("%s.%s: no schema versions", datastore.Name, cs.Column)
+		}
+
+		schema, err := loadSchema(cs.Versions[len(cs.Versions)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		col := column{Name: cs.Column, Ident: exportedName(cs.Column)}
+		name := cs.GoType
+		if name == "" {
+			name = t.Ident + col.Ident
+		}
+		col.GoType = goType(schema, name, &t.Types)
+		if col.GoType == "interface{}" {
+			col.GoType = "map[string]interface{}"
+		}
+		columns[col.Name] = col
+		t.Columns = append(t.Columns, col)
+	}
+
+	for _, idx := range datastore.Indexes {
+		if len(idx.ColumnDefs) == 0 {
+			continue
+		}
+		def := idx.ColumnDefs[0]
+		col, ok := columns[def.ColumnName]
+		if !ok {
+			col = column{Name: def.ColumnName, Ident: exportedName(def.ColumnName)}
+		}
+
+		ix := index{
+			Table:       datastore.Name + "_" + strings.ToLower(def.ColumnName) + "_" + def.IndexData.SourceField,
+			Column:      col,
+			SourceField: def.IndexData.SourceField,
+			Ident:       col.Ident + "By" + exportedName(def.IndexData.SourceField),
+		}
+		ix.GoType = t.Ident + ix.Ident
+
+		// index bodies are written by schemalessd's /api/put, which copies
+		// every field as a string whatever its declared type
+		st := structType{
+			Name:    ix.GoType,
+			Comment: fmt.Sprintf("%s is an entry of the %s index.", ix.GoType, ix.Table),
+			Fields:  []field{{Name: "RowKey", Type: "string", Tag: "`json:\"row_key\"`", Comment: "row key of the indexed cell"}},
+		}
+		var names []string
+		for f := range def.IndexData.Fields {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		for _, f := range names {
+			st.Fields = append(st.Fields, field{
+				Name: exportedName(f),
+				Type: "string",
+				Tag:  fmt.Sprintf("`json:\"%s,omitempty\"`", f),
+			})
+		}
+		t.Types = append(t.Types, st)
+		t.Indexes = append(t.Indexes, ix)
+	}
+
+	return t, nil
+}
+
+// generate renders Go source for the named datastores (all if empty).
+func generate(shardConfig *config.ShardConfig, source, pkg string, stores []string) ([]byte, error) {
+	m := model{Package: pkg, Source: source}
+
+	for i := range shardConfig.Datastores {
+		datastore := &shardConfig.Datastores[i]
+		if len(stores) > 0 && !contains(stores, datastore.Name) {
+			continue
+		}
+		t, err := buildTable(datastore)
+		if err != nil {
+			return nil, err
+		}
+		m.Tables = append(m.Tables, t)
+	}
+	if len(m.Tables) == 0 {
+		return nil, fmt.Errorf("no datastores to generate")
+	}
+
+	var buf bytes.Buffer
+	err := codeTemplate.Execute(&buf, m)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, buf.Bytes())
+	}
+	return code, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unexported lower-cases the first letter of an identifier.
+func unexported(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+var codeTemplate = template.Must(template.New("code").Funcs(template.FuncMap{"unexported": unexported}).Parse(`// Code generated by schemaless-gen from {{.Source}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/client"
+	"github.com/rbastic/go-schemaless/table"
+)
+{{range $t := .Tables}}
+// {{$t.Ident}}Table is the name of the {{$t.Name}} table.
+const {{$t.Ident}}Table = "{{$t.Name}}"
+{{if $t.Columns}}
+// Columns of the {{$t.Name}} table.
+const (
+{{- range $t.Columns}}
+	{{$t.Ident}}{{.Ident}}Column = "{{.Name}}"
+{{- end}}
+)
+{{end}}
+{{- if $t.Indexes}}
+// Index tables of the {{$t.Name}} table.
+const (
+{{- range $t.Indexes}}
+	{{$t.Ident}}{{.Ident}}Table = "{{.Table}}"
+{{- end}}
+)
+{{end}}
+{{- range $t.Types}}
+// {{.Comment}}
+type {{.Name}} struct {
+{{- range .Fields}}
+{{- if .Comment}}
+	// {{.Comment}}
+{{- end}}
+	{{.Name}} {{.Type}} {{.Tag}}
+{{- end}}
+}
+{{end}}
+// {{$t.Ident}} is a typed client for the {{$t.Name}} table.
+type {{$t.Ident}} struct {
+{{- range $t.Columns}}
+	{{unexported .Ident}} *table.Table[{{.GoType}}]
+{{- end}}
+{{- range $t.Indexes}}
+	{{unexported .Ident}} *table.Table[{{.GoType}}]
+{{- end}}
+}
+
+// New{{$t.Ident}} returns a typed client for the {{$t.Name}} table in store,
+// usually a *schemaless.DataStore.
+func New{{$t.Ident}}(store table.Store) *{{$t.Ident}} {
+	return &{{$t.Ident}}{
+{{- range $t.Columns}}
+		{{unexported .Ident}}: table.New[{{.GoType}}](store, {{$t.Ident}}Table),
+{{- end}}
+{{- range $t.Indexes}}
+		{{unexported .Ident}}: table.New[{{.GoType}}](store, {{$t.Ident}}{{.Ident}}Table),
+{{- end}}
+	}
+}
+
+// New{{$t.Ident}}Remote returns a typed client for the {{$t.Name}} table that
+// talks to schemalessd through c.
+func New{{$t.Ident}}Remote(c *client.Client) *{{$t.Ident}} {
+	return New{{$t.Ident}}(c.Store("{{$t.Name}}"))
+}
+{{range $t.Columns}}
+// Get{{.Ident}} returns the {{.Name}} cell of rowKey at refKey.
+func (t *{{$t.Ident}}) Get{{.Ident}}(ctx context.Context, rowKey string, refKey int64) ({{.GoType}}, bool, error) {
+	return t.{{unexported .Ident}}.Get(ctx, rowKey, {{$t.Ident}}{{.Ident}}Column, refKey)
+}
+
+// GetLatest{{.Ident}} returns the latest {{.Name}} cell of rowKey.
+func (t *{{$t.Ident}}) GetLatest{{.Ident}}(ctx context.Context, rowKey string) ({{.GoType}}, bool, error) {
+	return t.{{unexported .Ident}}.GetLatest(ctx, rowKey, {{$t.Ident}}{{.Ident}}Column)
+}
+
+// Put{{.Ident}} writes the {{.Name}} cell of rowKey at refKey.
+func (t *{{$t.Ident}}) Put{{.Ident}}(ctx context.Context, rowKey string, refKey int64, v {{.GoType}}) error {
+	return t.{{unexported .Ident}}.Put(ctx, rowKey, {{$t.Ident}}{{.Ident}}Column, refKey, v)
+}
+
+// History{{.Ident}} returns up to limit versions of the {{.Name}} cell of
+// rowKey, highest ref key first.
+func (t *{{$t.Ident}}) History{{.Ident}}(ctx context.Context, rowKey string, limit int) ([]table.Cell[{{.GoType}}], bool, error) {
+	return t.{{unexported .Ident}}.History(ctx, rowKey, {{$t.Ident}}{{.Ident}}Column, limit)
+}
+{{end}}
+{{- range $t.Indexes}}
+// Find{{.Ident}} returns up to limit entries of the {{.Table}}
+// index whose {{.SourceField}} is value, highest ref key first.
+func (t *{{$t.Ident}}) Find{{.Ident}}(ctx context.Context, value string, limit int) ([]{{.GoType}}, error) {
+	cells, _, err := t.{{unexported .Ident}}.History(ctx, value, "{{.Column.Name}}", limit)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]{{.GoType}}, 0, len(cells))
+	for _, cell := range cells {
+		entries = append(entries, cell.Value)
+	}
+	return entries, nil
+}
+{{end}}
+{{- end}}`))
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+)
+
+// TestGenerate regenerates the trips example and compares it against the
+// checked-in copy, so that changes to the generator show up in review.
+func TestGenerate(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// schema paths in tables.json are relative to the example
+	err = os.Chdir("../../examples/schemalessd/pkg/trips")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shardConfig, err := config.LoadConfig("tables.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := generate(shardConfig, "tables.json", "trips", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ioutil.ReadFile("trips_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, expected) {
+		t.Error("generated code differs from trips_gen.go; run go generate in examples/schemalessd/pkg/trips")
+	}
+
+	_, err = generate(shardConfig, "tables.json", "trips", []string{"nope"})
+	if err == nil {
+		t.Error("expected an error for an unknown datastore")
+	}
+}
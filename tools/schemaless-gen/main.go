@@ -0,0 +1,67 @@
+// schemaless-gen generates typed Go clients for the tables described by a
+// schemalessd shards.json.
+//
+// For each datastore it generates a type per column with a schema (from
+// the column's latest JSON Schema version, or named by "go_type"), a type
+// per secondary index, and a client with Get, GetLatest, Put and History
+// accessors for each column and a Find helper for each index. The client
+// works over a *schemaless.DataStore or, through New<Table>Remote, over
+// pkg/client talking to a running schemalessd.
+//
+// Typically run from go:generate:
+//
+//	//go:generate go run github.com/rbastic/go-schemaless/tools/schemaless-gen -config tables.json -package trips -out trips_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rbastic/go-schemaless/examples/schemalessd/pkg/config"
+)
+
+func main() {
+	configPtr := flag.String("config", "", "shards.json describing the tables, their column schemas and indexes")
+	storePtr := flag.String("store", "", "comma separated datastores to generate (default: all)")
+	packagePtr := flag.String("package", "", "package name of the generated code")
+	outPtr := flag.String("out", "", "file to write (default: stdout)")
+
+	flag.Parse()
+
+	if *configPtr == "" || *packagePtr == "" {
+		fmt.Fprintln(os.Stderr, "You must set -config and -package.")
+		os.Exit(2)
+	}
+
+	shardConfig, err := config.LoadConfig(*configPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var stores []string
+	if *storePtr != "" {
+		stores = strings.Split(*storePtr, ",")
+	}
+
+	code, err := generate(shardConfig, filepath.Base(*configPtr), *packagePtr, stores)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *outPtr == "" {
+		os.Stdout.Write(code)
+		return
+	}
+
+	err = ioutil.WriteFile(*outPtr, code, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
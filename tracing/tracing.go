@@ -0,0 +1,138 @@
+// Package tracing creates the OpenTelemetry spans of schemaless: one per
+// KVStore call, naming the shard it was routed to, and one per SQL
+// statement a backend runs. Spans go to the global TracerProvider, so they
+// are dropped unless the program sets one:
+//
+//	tp, err := tracing.NewFileProvider("traces.json")
+//	otel.SetTracerProvider(tp)
+//	defer tp.Shutdown(ctx)
+//
+// StartHTTPClient and StartHTTPServer carry a trace across HTTP, in W3C
+// Trace Context headers, whether or not a global propagator is set.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/rbastic/go-schemaless"
+
+// Attribute keys of schemaless spans.
+const (
+	TableKey          = attribute.Key("schemaless.table")
+	ShardKey          = attribute.Key("schemaless.shard")
+	MigrationShardKey = attribute.Key("schemaless.migration_shard")
+)
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Start starts a span named name as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording err if it isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartSQL starts the client span of a SQL statement run against tblName.
+// system is the database, e.g. semconv.DBSystemSqlite.
+func StartSQL(ctx context.Context, system attribute.KeyValue, op, tblName, statement string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, op+" "+tblName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			system,
+			semconv.DBOperationKey.String(op),
+			semconv.DBSQLTableKey.String(tblName),
+			semconv.DBStatementKey.String(statement),
+		))
+}
+
+// StartHTTPClient starts the client span of an outgoing request, and returns
+// the request with the span in its context and its trace in its headers.
+func StartHTTPClient(r *http.Request) (*http.Request, trace.Span) {
+	ctx, span := otel.Tracer(instrumentationName).Start(r.Context(), r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPURLKey.String(r.URL.String()),
+		))
+	r = r.WithContext(ctx)
+	Inject(ctx, r.Header)
+	return r, span
+}
+
+// StartHTTPServer starts the server span of an incoming request, as a child
+// of the trace in its headers, and returns the request with the span in its
+// context.
+func StartHTTPServer(r *http.Request) (*http.Request, trace.Span) {
+	ctx := Extract(r.Context(), r.Header)
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.Path),
+		))
+	return r.WithContext(ctx), span
+}
+
+// SetStatusCode records the status code of an HTTP response on span.
+func SetStatusCode(span trace.Span, code int) {
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(code))
+	if code >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(code))
+	}
+}
+
+// Inject writes the trace in ctx into the headers of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx with the trace of an incoming request's headers.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// NewFileProvider returns a TracerProvider that appends every span to the
+// named file, as JSON, when it ends. Shutting the provider down closes the
+// file.
+func NewFileProvider(path string) (*sdktrace.TracerProvider, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tp.RegisterSpanProcessor(closer{f})
+	return tp, nil
+}
+
+// closer closes the trace file once the exporter has been shut down.
+type closer struct{ f *os.File }
+
+func (c closer) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+func (c closer) OnEnd(sdktrace.ReadOnlySpan)                     {}
+func (c closer) Shutdown(context.Context) error                  { return c.f.Close() }
+func (c closer) ForceFlush(context.Context) error                { return c.f.Sync() }
@@ -0,0 +1,144 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rbastic/go-schemaless"
+	"github.com/rbastic/go-schemaless/core"
+	"github.com/rbastic/go-schemaless/storagetest"
+	"github.com/rbastic/go-schemaless/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tblName = "cell"
+
+// span is the part of an exported span the tests look at.
+type span struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ SpanID string }
+	Attributes  []struct {
+		Key   string
+		Value struct{ Value interface{} }
+	}
+}
+
+func (s span) attr(key string) interface{} {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+// record sets a file TracerProvider while f runs, and returns the spans it
+// wrote.
+func record(t *testing.T, f func()) map[string]span {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	tp, err := tracing.NewFileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	f()
+	otel.SetTracerProvider(prev)
+	err = tp.Shutdown(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	spans := make(map[string]span)
+	dec := json.NewDecoder(file)
+	for {
+		var s span
+		err := dec.Decode(&s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	ds := schemaless.New().WithSources(tblName, []core.Shard{{Name: "shard0", Backend: storagetest.NewSQLiteTable(t, tblName)}})
+	defer ds.Destroy(context.TODO())
+
+	var err error
+	spans := record(t, func() {
+		ctx, root := tracing.Start(context.TODO(), "test")
+		defer root.End()
+		err = ds.Put(ctx, tblName, "trip1", "BASE", 1, `{}`)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, put, insert := spans["test"], spans["KVStore.Put"], spans["INSERT "+tblName]
+	if put.Parent.SpanID != root.SpanContext.SpanID {
+		t.Errorf("expected the KVStore span to be a child of the caller's, got %+v", spans)
+	}
+	if got := put.attr(string(tracing.ShardKey)); got != "shard0" {
+		t.Errorf("expected the KVStore span to name shard0, got %v", got)
+	}
+	if insert.Parent.SpanID != put.SpanContext.SpanID {
+		t.Errorf("expected the SQL span to be a child of the KVStore span, got %+v", spans)
+	}
+	if got := insert.attr("db.system"); got != "sqlite" {
+		t.Errorf("expected the SQL span to name the database, got %v", got)
+	}
+	if insert.SpanContext.TraceID != root.SpanContext.TraceID {
+		t.Errorf("expected a single trace, got %+v", spans)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var server trace.SpanContext
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, span := tracing.StartHTTPServer(r)
+		defer span.End()
+		server = trace.SpanContextFromContext(r.Context())
+	}))
+	defer srv.Close()
+
+	var client trace.SpanContext
+	spans := record(t, func() {
+		request, err := http.NewRequest("POST", srv.URL+"/api/put", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, span := tracing.StartHTTPClient(request)
+		client = span.SpanContext()
+		response, err := http.DefaultClient.Do(request)
+		if err == nil {
+			tracing.SetStatusCode(span, response.StatusCode)
+			response.Body.Close()
+		}
+		tracing.End(span, err)
+	})
+
+	if !server.IsValid() || server.TraceID() != client.TraceID() {
+		t.Fatalf("expected the server to continue the client's trace, got %v and %v", client.TraceID(), server.TraceID())
+	}
+	if got := spans["POST /api/put"].attr("http.status_code"); got != float64(http.StatusOK) {
+		t.Errorf("expected the status code on the client span, got %v", got)
+	}
+}